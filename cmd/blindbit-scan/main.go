@@ -3,6 +3,7 @@ package main
 import (
 	"bytes"
 	"context"
+	"errors"
 	"flag"
 	"os"
 	"os/signal"
@@ -14,6 +15,7 @@ import (
 	"github.com/setavenger/blindbit-scan/pkg/database"
 	"github.com/setavenger/blindbit-scan/pkg/logging"
 	"github.com/setavenger/blindbit-scan/pkg/networking/nwc"
+	"github.com/setavenger/blindbit-scan/pkg/webhook"
 )
 
 func init() {
@@ -39,6 +41,19 @@ func main() {
 			Msg("startup failed, could not setup configs")
 	}
 
+	if config.StorageEncrypt {
+		passphrase, err := config.ReadPassphrase(!database.EncryptedDataExists())
+		if err != nil {
+			logging.L.Panic().Err(err).
+				Msg("startup failed, could not unlock the databases")
+		}
+		err = database.EnableEncryption(passphrase)
+		if err != nil {
+			logging.L.Panic().Err(err).
+				Msg("startup failed, could not setup encryption")
+		}
+	}
+
 	var d *daemon.Daemon
 
 	d, err = daemon.SetupDaemonNoWallet()
@@ -46,13 +61,27 @@ func main() {
 		logging.L.Panic().Err(err).
 			Msg("startup failed, could produce daemon hull")
 	}
-	w, err := database.TryLoadWalletFromDisk(config.PathDbWallet)
+	w, err := database.TryLoadWallet(d.Store)
+	if errors.Is(err, database.ErrWrongPassphrase) || errors.Is(err, database.ErrEncryptedData) {
+		// continuing would end up overwriting the wallet
+		logging.L.Panic().Err(err).
+			Msg("startup failed, could not decrypt wallet")
+	}
 	if err != nil {
 		logging.L.Warn().Err(err).
 			Msg("startup failed, could setup full daemon")
 	}
 	d.Wallet = w
 
+	// created once, the daemon is kept when new keys are added
+	webhooks, err := webhook.NewDispatcher(config.WebhookEndpoints, config.PathDbWebhooks, config.WebhookMaxAttempts)
+	if err != nil {
+		logging.L.Panic().Err(err).
+			Msg("startup failed, could not load webhook queue")
+	}
+	go webhooks.Run(context.Background())
+	d.Webhooks = webhooks
+
 	// Setup BlindBit Nostr Wallet Connect
 	nwcServer := nwcserver.NewNwcServer(d)
 
@@ -66,6 +95,7 @@ func main() {
 	controller.RegisterHandler(nwc.GET_INFO_METHOD, nwcServer.GetInfoHandler())
 	controller.RegisterHandler(nwc.GET_BALANCE_METHOD, nwcServer.GetBalanceHandler())
 	controller.RegisterHandler(nwc.LIST_UTXOS_METHOD, nwcServer.ListUtxosHandler())
+	controller.RegisterHandler(nwc.LIST_TRANSACTIONS_METHOD, nwcServer.ListTransactionsHandler())
 
 	err = controller.ConnectRelay()
 	if err != nil {
@@ -82,19 +112,18 @@ func main() {
 		}
 	}()
 
-	// when we exit we still flush the last state
+	// when we exit we still flush the last state, deferred calls run in reverse so the store is closed last
+	defer d.Store.Close()
 	defer d.SaveWalletToDB()
 
 	go func() {
-		// if the keys are not setup we wait
+		// if the keys are not setup we wait,
+		// the setup handler puts the new wallet into d and starts scanning so the servers keep seeing the live daemon
 		if d.Wallet == nil || bytes.Equal(d.Wallet.SecretKeyScan[:], make([]byte, 32)) || bytes.Equal(d.Wallet.PubKeySpend[:], make([]byte, 33)) {
 			logging.L.Info().Msg("waiting for keys")
 			<-config.KeysReadyChan
-			d, err = daemon.SetupDaemon(config.PathDbWallet)
-			if err != nil {
-				logging.L.Panic().Err(err).
-					Msg("startup failed, could setup full daemon")
-			}
+			logging.L.Info().Msg("keys were set up")
+			return
 		}
 		go d.ContinuousScan()
 	}()
@@ -2,8 +2,10 @@ package config
 
 import (
 	"encoding/hex"
+	"fmt"
 	"log"
 	"log/slog"
+	"net/url"
 	"strings"
 	"time"
 
@@ -33,11 +35,25 @@ func LoadConfigs(pathToConfig string) (err error) {
 
 	// map ENV var names
 	viper.BindEnv("network.expose_http", "EXPOSE_HTTP")
+	viper.BindEnv("network.backend", "NETWORK_BACKEND")
 	viper.BindEnv("network.blindbit_server", "BLINDBIT_SERVER")
+	viper.BindEnv("network.blindbit_servers", "BLINDBIT_SERVERS")
 	viper.BindEnv("network.electrum_server", "ELECTRUM_SERVER")
 	viper.BindEnv("network.chain", "NETWORK_CHAIN")
 	viper.BindEnv("network.electrum_tor", "ELECTRUM_TOR")
 	viper.BindEnv("network.electrum_tor_proxy_host", "ELECTRUM_TOR_PROXY_HOST")
+	viper.BindEnv("network.blindbit_tor", "BLINDBIT_TOR")
+
+	viper.BindEnv("oracle.timeout", "ORACLE_TIMEOUT")
+	viper.BindEnv("oracle.retries", "ORACLE_RETRIES")
+	viper.BindEnv("oracle.breaker_threshold", "ORACLE_BREAKER_THRESHOLD")
+	viper.BindEnv("oracle.breaker_cooldown", "ORACLE_BREAKER_COOLDOWN")
+	viper.BindEnv("oracle.paranoid", "ORACLE_PARANOID")
+
+	viper.BindEnv("bitcoind.rpc_url", "BITCOIND_RPC_URL")
+	viper.BindEnv("bitcoind.rpc_user", "BITCOIND_RPC_USER")
+	viper.BindEnv("bitcoind.rpc_pass", "BITCOIND_RPC_PASS")
+	viper.BindEnv("bitcoind.rpc_cookie", "BITCOIND_RPC_COOKIE")
 
 	viper.BindEnv("wallet.dust_limit", "WALLET_DUST_LIMIT")
 	viper.BindEnv("wallet.label_count", "WALLET_LABEL_COUNT")
@@ -45,8 +61,23 @@ func LoadConfigs(pathToConfig string) (err error) {
 	viper.BindEnv("wallet.scan_secret_key", "WALLET_SCAN_SECRET_KEY")
 	viper.BindEnv("wallet.spend_pub_key", "WALLET_SPEND_PUB_KEY")
 
+	viper.BindEnv("scan.concurrency", "SCAN_CONCURRENCY")
+	viper.BindEnv("scan.reorg_window", "SCAN_REORG_WINDOW")
+	viper.BindEnv("scan.tweak_index", "SCAN_TWEAK_INDEX")
+	viper.BindEnv("scan.mempool", "SCAN_MEMPOOL")
+	viper.BindEnv("scan.mempool_interval", "SCAN_MEMPOOL_INTERVAL")
+
+	viper.BindEnv("storage.engine", "STORAGE_ENGINE")
+	viper.BindEnv("storage.snapshots", "STORAGE_SNAPSHOTS")
+	viper.BindEnv("storage.encrypt", "STORAGE_ENCRYPT")
+	viper.BindEnv("storage.passphrase_file", "STORAGE_PASSPHRASE_FILE")
+
+	viper.BindEnv("webhooks.max_attempts", "WEBHOOKS_MAX_ATTEMPTS")
+
 	viper.BindEnv("auth.user", "AUTH_USER")
 	viper.BindEnv("auth.pass", "AUTH_PASS")
+	viper.BindEnv("auth.spend_user", "AUTH_SPEND_USER")
+	viper.BindEnv("auth.spend_pass", "AUTH_SPEND_PASS")
 
 	viper.BindEnv("log_level", "LOG_LEVEL")
 
@@ -56,17 +87,44 @@ func LoadConfigs(pathToConfig string) (err error) {
 	/* set defaults */
 	// network
 	viper.SetDefault("network.expose_http", "127.0.0.1:8080")
+	viper.SetDefault("network.backend", "blindbit")
 	viper.SetDefault("network.blindbit_server", "http://localhost:8000")
 	viper.SetDefault("network.electrum_server", "") // we set this to empty
 	viper.SetDefault("network.chain", "signet")
 	viper.SetDefault("network.electrum_tor", true)
 	viper.SetDefault("network.electrum_tor_proxy_host", "127.0.0.1:9050")
+	viper.SetDefault("network.blindbit_tor", false)
+
+	// oracle
+	viper.SetDefault("oracle.timeout", 30) // seconds
+	viper.SetDefault("oracle.retries", 3)
+	viper.SetDefault("oracle.breaker_threshold", 5)
+	viper.SetDefault("oracle.breaker_cooldown", 30) // seconds
+	viper.SetDefault("oracle.paranoid", false)
+
+	// bitcoind
+	viper.SetDefault("bitcoind.rpc_url", "http://127.0.0.1:8332")
 
 	// wallet
 	viper.SetDefault("wallet.dust_limit", 1000)
 	viper.SetDefault("wallet.label_count", 1) // do at least the change label
 	viper.SetDefault("wallet.birth_height", 840000)
 
+	// scan
+	viper.SetDefault("scan.concurrency", 4)
+	viper.SetDefault("scan.reorg_window", 100)
+	viper.SetDefault("scan.tweak_index", false)
+	viper.SetDefault("scan.mempool", false)
+	viper.SetDefault("scan.mempool_interval", 30) // seconds
+
+	// storage
+	viper.SetDefault("storage.engine", "json")
+	viper.SetDefault("storage.snapshots", 3)
+	viper.SetDefault("storage.encrypt", false)
+
+	// webhooks
+	viper.SetDefault("webhooks.max_attempts", 25)
+
 	viper.SetDefault("log_level", "info")
 
 	// app seed
@@ -74,7 +132,64 @@ func LoadConfigs(pathToConfig string) (err error) {
 
 	/* read and set config variables */
 	ExposeHttpHost = viper.GetString("network.expose_http")
+	IndexBackend = strings.ToLower(strings.TrimSpace(viper.GetString("network.backend")))
+	switch IndexBackend {
+	case "blindbit", "bitcoind":
+	default:
+		err = fmt.Errorf("invalid network.backend: (%s)", IndexBackend)
+		logging.L.Err(err).Msg("")
+		return err
+	}
 	BlindBitServerAddress = viper.GetString("network.blindbit_server")
+	BlindBitServerAddresses = nil
+	// the env var is a comma separated list
+	for _, entry := range viper.GetStringSlice("network.blindbit_servers") {
+		for _, address := range strings.Split(entry, ",") {
+			address = strings.TrimSpace(address)
+			if address != "" {
+				BlindBitServerAddresses = append(BlindBitServerAddresses, address)
+			}
+		}
+	}
+	if len(BlindBitServerAddresses) == 0 {
+		BlindBitServerAddresses = []string{BlindBitServerAddress}
+	}
+	BlindBitServerAddress = BlindBitServerAddresses[0]
+	OracleTorProxyHost = ""
+	if viper.GetBool("network.blindbit_tor") {
+		OracleTorProxyHost = viper.GetString("network.electrum_tor_proxy_host")
+	}
+	for _, address := range BlindBitServerAddresses {
+		parsed, err := url.Parse(address)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			err = fmt.Errorf("invalid network.blindbit_servers url: (%s)", address)
+			logging.L.Err(err).Msg("")
+			return err
+		}
+		// without the proxy the address can't be resolved, fail early instead of on every request
+		if strings.HasSuffix(parsed.Hostname(), ".onion") && OracleTorProxyHost == "" {
+			err = fmt.Errorf("invalid network.blindbit_servers url: (%s) is an onion address but network.blindbit_tor is disabled", address)
+			logging.L.Err(err).Msg("")
+			return err
+		}
+	}
+	OracleTimeout = time.Duration(viper.GetInt("oracle.timeout")) * time.Second
+	OracleRetries = viper.GetInt("oracle.retries")
+	if OracleRetries < 0 {
+		OracleRetries = 0
+	}
+	OracleBreakerThreshold = viper.GetInt("oracle.breaker_threshold")
+	OracleBreakerCooldown = time.Duration(viper.GetInt("oracle.breaker_cooldown")) * time.Second
+	OracleParanoid = viper.GetBool("oracle.paranoid")
+	if OracleParanoid && (IndexBackend != "blindbit" || len(BlindBitServerAddresses) < 2) {
+		err = fmt.Errorf("invalid oracle.paranoid: needs at least two network.blindbit_servers (%d)", len(BlindBitServerAddresses))
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	BitcoindRpcUrl = viper.GetString("bitcoind.rpc_url")
+	BitcoindRpcUser = viper.GetString("bitcoind.rpc_user")
+	BitcoindRpcPass = viper.GetString("bitcoind.rpc_pass")
+	BitcoindRpcCookie = viper.GetString("bitcoind.rpc_cookie")
 	ElectrumServerAddress = viper.GetString("network.electrum_server")
 	if ElectrumServerAddress != "" {
 		UseElectrum = true
@@ -100,6 +215,20 @@ func LoadConfigs(pathToConfig string) (err error) {
 		return err
 	}
 
+	AuthSpendUser = viper.GetString("auth.spend_user")
+	AuthSpendPass = viper.GetString("auth.spend_pass")
+
+	if (AuthSpendUser == "") != (AuthSpendPass == "") {
+		err := errors.New("config needs both auth.spend_user and auth.spend_pass")
+		slog.Error(err.Error())
+		return err
+	}
+	if AuthSpendUser != "" && AuthSpendUser == AuthUser {
+		err := fmt.Errorf("invalid auth.spend_user: has to differ from auth.user (%s)", AuthSpendUser)
+		slog.Error(err.Error())
+		return err
+	}
+
 	DustLimit = viper.GetUint64("wallet.dust_limit")
 
 	// load keys
@@ -128,6 +257,63 @@ func LoadConfigs(pathToConfig string) (err error) {
 	LabelCount = viper.GetInt("wallet.label_count")
 	BirthHeight = viper.GetUint64("wallet.birth_height")
 
+	ScanConcurrency = viper.GetInt("scan.concurrency")
+	if ScanConcurrency < 1 {
+		ScanConcurrency = 1
+	}
+	ReorgWindow = viper.GetInt("scan.reorg_window")
+	UseTweakIndex = viper.GetBool("scan.tweak_index")
+	ScanMempool = viper.GetBool("scan.mempool")
+	if ScanMempool && IndexBackend != "bitcoind" {
+		err = fmt.Errorf("invalid scan.mempool: needs network.backend bitcoind (%s)", IndexBackend)
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	MempoolScanInterval = time.Duration(viper.GetInt("scan.mempool_interval")) * time.Second
+	if MempoolScanInterval <= 0 {
+		MempoolScanInterval = 30 * time.Second
+	}
+
+	StorageEngine = strings.ToLower(strings.TrimSpace(viper.GetString("storage.engine")))
+	switch StorageEngine {
+	case "json", "bbolt":
+	default:
+		err = fmt.Errorf("invalid storage.engine: (%s)", StorageEngine)
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	StorageSnapshots = viper.GetInt("storage.snapshots")
+	if StorageSnapshots < 0 {
+		StorageSnapshots = 0
+	}
+	StorageEncrypt = viper.GetBool("storage.encrypt")
+	StoragePassphraseFile = viper.GetString("storage.passphrase_file")
+
+	WebhookEndpoints = nil
+	err = viper.UnmarshalKey("webhooks.endpoints", &WebhookEndpoints)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	for _, endpoint := range WebhookEndpoints {
+		parsed, err := url.Parse(endpoint.URL)
+		if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			err = fmt.Errorf("invalid webhooks.endpoints url: (%s)", endpoint.URL)
+			logging.L.Err(err).Msg("")
+			return err
+		}
+		if endpoint.Secret == "" {
+			err = fmt.Errorf("missing webhooks.endpoints secret: (%s)", endpoint.URL)
+			logging.L.Err(err).Msg("")
+			return err
+		}
+	}
+	WebhookMaxAttempts = viper.GetInt("webhooks.max_attempts")
+	if WebhookMaxAttempts < 1 {
+		WebhookMaxAttempts = 1
+	}
+
 	// extract the chain data and set the params
 	chain := viper.GetString("network.chain")
 	switch chain {
@@ -0,0 +1,85 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"golang.org/x/term"
+)
+
+// EnvPassphrase holds the passphrase for the encrypted databases.
+// It is read directly and not through viper, viper would write it into blindbit.toml together with the keys.
+const EnvPassphrase = "BLINDBIT_PASSPHRASE"
+
+var (
+	ErrNoPassphrase       = errors.New("encryption is enabled but no passphrase was provided")
+	ErrPassphraseMismatch = errors.New("the passphrases do not match")
+)
+
+// ReadPassphrase gets the passphrase from the environment, the key file or a prompt on the terminal, in that order.
+// With confirm the prompt asks twice, set it if nothing was encrypted yet and a typo can't be detected.
+func ReadPassphrase(confirm bool) ([]byte, error) {
+	if passphrase, ok := os.LookupEnv(EnvPassphrase); ok && passphrase != "" {
+		// don't hand it down to anything we might spawn
+		_ = os.Unsetenv(EnvPassphrase)
+		return []byte(passphrase), nil
+	}
+
+	if StoragePassphraseFile != "" {
+		data, err := os.ReadFile(StoragePassphraseFile)
+		if err != nil {
+			logging.L.Err(err).Str("path", StoragePassphraseFile).Msg("could not read passphrase file")
+			return nil, err
+		}
+		passphrase := bytes.TrimRight(data, "\r\n")
+		if len(passphrase) == 0 {
+			return nil, fmt.Errorf("passphrase file is empty: (%s)", StoragePassphraseFile)
+		}
+		return passphrase, nil
+	}
+
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		logging.L.Err(ErrNoPassphrase).Msg("")
+		return nil, ErrNoPassphrase
+	}
+
+	prompt := "Passphrase to unlock the wallet: "
+	if confirm {
+		prompt = "New passphrase to encrypt the wallet: "
+	}
+	passphrase, err := promptPassword(fd, prompt)
+	if err != nil {
+		return nil, err
+	}
+	if len(passphrase) == 0 {
+		return nil, ErrNoPassphrase
+	}
+	if !confirm {
+		return passphrase, nil
+	}
+
+	repeated, err := promptPassword(fd, "Repeat the passphrase: ")
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(passphrase, repeated) {
+		logging.L.Err(ErrPassphraseMismatch).Msg("")
+		return nil, ErrPassphraseMismatch
+	}
+	return passphrase, nil
+}
+
+func promptPassword(fd int, prompt string) ([]byte, error) {
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+	return passphrase, nil
+}
@@ -6,10 +6,12 @@ import "github.com/setavenger/blindbitd/src/utils"
 var (
 	DirectoryPath = "~/.blindbit-scan"
 
-	PathLogs     string
-	PathConfig   string
-	PathDbWallet string
-	PathDbNWC    string
+	PathLogs         string
+	PathConfig       string
+	PathDbWallet     string
+	PathDbWalletBolt string
+	PathDbNWC        string
+	PathDbWebhooks   string
 )
 
 // needed for the flag default
@@ -18,7 +20,9 @@ const DefaultDirectoryPath = "~/.blindbit-scan"
 const dataPath = "/data"
 const PathEndingConfig = "/blindbit.toml"
 const PathEndingWallet = dataPath + "/wallet"
+const PathEndingWalletBolt = dataPath + "/wallet.db"
 const PathEndingNWC = dataPath + "/nwc"
+const PathEndingWebhooks = dataPath + "/webhooks"
 const PathEndingKeys = dataPath + "/keys"
 
 func SetPaths(baseDirectory string) {
@@ -33,7 +37,9 @@ func SetPaths(baseDirectory string) {
 
 	PathConfig = DirectoryPath + PathEndingConfig
 	PathDbWallet = DirectoryPath + PathEndingWallet
+	PathDbWalletBolt = DirectoryPath + PathEndingWalletBolt
 	PathDbNWC = DirectoryPath + PathEndingNWC
+	PathDbWebhooks = DirectoryPath + PathEndingWebhooks
 
 	// create the directories
 	utils.TryCreateDirectoryPanic(DirectoryPath)
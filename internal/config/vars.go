@@ -10,6 +10,13 @@ func init() {
 	KeysReadyChan = make(chan struct{})
 }
 
+// WebhookEndpoint is a single [[webhooks.endpoints]] entry
+type WebhookEndpoint struct {
+	URL    string   `mapstructure:"url"`
+	Secret string   `mapstructure:"secret"` // HMAC-SHA256 key, the receiver uses it to verify the payloads
+	Labels []uint32 `mapstructure:"labels"` // only payments received on these labels (m), all payments if empty
+}
+
 var (
 	// ExposeHttpHost if set gRPC will be exposed via http and not unix socket. This variable also defines the where it will be exposed.
 	ExposeHttpHost string
@@ -17,6 +24,37 @@ var (
 	// BlindBitServerAddress Indexing server for silent payments that follows the blindbit standard
 	BlindBitServerAddress string
 
+	// BlindBitServerAddresses all indexing servers, the first one is BlindBitServerAddress.
+	// Requests fail over to the next server, see OracleParanoid.
+	BlindBitServerAddresses []string
+
+	// OracleParanoid sends every request to all BlindBitServerAddresses and halts the scan if their answers differ
+	OracleParanoid bool
+
+	// IndexBackend selects where block data for scanning comes from. Allowed values: blindbit, bitcoind
+	IndexBackend string
+
+	// OracleTimeout is the timeout of a single request to the indexing server
+	OracleTimeout time.Duration
+
+	// OracleRetries is how often failed requests to the indexing server are retried (network errors, 429 and 5xx)
+	OracleRetries int
+
+	// OracleBreakerThreshold consecutive failed requests stop all requests for OracleBreakerCooldown, 0 disables the breaker
+	OracleBreakerThreshold int
+
+	OracleBreakerCooldown time.Duration
+
+	// BitcoindRpcUrl JSON-RPC endpoint of the Bitcoin Core node used by the bitcoind backend
+	BitcoindRpcUrl string
+
+	// BitcoindRpcUser and BitcoindRpcPass are used for rpc auth. If BitcoindRpcCookie is set the cookie file is used instead.
+	BitcoindRpcUser string
+
+	BitcoindRpcPass string
+
+	BitcoindRpcCookie string
+
 	// ElectrumServerAddress Electrum server
 	ElectrumServerAddress string
 
@@ -28,6 +66,10 @@ var (
 	// ChainParams defines on which chain the wallet runs
 	ChainParams *chaincfg.Params
 
+	// OracleTorProxyHost if set the indexing servers are accessed through this SOCKS5 proxy.
+	// Shares network.electrum_tor_proxy_host with Electrum.
+	OracleTorProxyHost = ""
+
 	// ElectrumTorProxyHost if the host addr is given, tor will be used normally "127.0.0.1:9050". This is also the default setting
 	ElectrumTorProxyHost = ""
 
@@ -45,11 +87,52 @@ var (
 
 	LabelCount int
 
+	// ScanConcurrency is the number of block heights which are fetched and scanned in parallel.
+	// Results are still committed to the wallet strictly in height order.
+	ScanConcurrency int
+
+	// ReorgWindow is the number of recently scanned blocks which are tracked to detect and roll back reorgs
+	ReorgWindow int
+
+	// UseTweakIndex requests the full tweak index instead of the cut-through tweaks.
+	// Cut-through tweaks miss outputs which have been spent by now, rescans of older history need the full index.
+	UseTweakIndex bool
+
+	// ScanMempool enables scanning unconfirmed transactions, found outputs are added as unconfirmed
+	ScanMempool bool
+
+	// MempoolScanInterval is how often the mempool is scanned if ScanMempool is set
+	MempoolScanInterval time.Duration
+
+	// StorageEngine selects how the wallet is persisted. Allowed values: json, bbolt
+	StorageEngine string
+
+	// StorageSnapshots is the number of previous versions kept of the json databases.
+	// If the current file is corrupt the latest valid snapshot is loaded.
+	StorageSnapshots int
+
+	// StorageEncrypt encrypts the wallet and nwc databases with a passphrase, see ReadPassphrase
+	StorageEncrypt bool
+
+	// StoragePassphraseFile is a key file holding the passphrase
+	StoragePassphraseFile string
+
+	// WebhookEndpoints receive a signed POST for every payment found in a block
+	WebhookEndpoints []WebhookEndpoint
+
+	// WebhookMaxAttempts is how often a delivery is tried before it is dropped
+	WebhookMaxAttempts int
+
 	// basic auth details
 	AuthUser string
 
 	AuthPass string
 
+	// basic auth which may also read the private key tweaks, spend data is not served if unset
+	AuthSpendUser string
+
+	AuthSpendPass string
+
 	// keys are ready chan
 	KeysReadyChan chan struct{}
 )
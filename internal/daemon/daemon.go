@@ -2,14 +2,17 @@ package daemon
 
 import (
 	"context"
-	"errors"
-	"os"
+	"slices"
+	"sync"
+	"time"
 
 	"github.com/setavenger/blindbit-scan/internal/config"
 	"github.com/setavenger/blindbit-scan/pkg/database"
 	"github.com/setavenger/blindbit-scan/pkg/logging"
 	"github.com/setavenger/blindbit-scan/pkg/networking" // todo move all blindbitd/src/*
 	"github.com/setavenger/blindbit-scan/pkg/wallet"
+	"github.com/setavenger/blindbit-scan/pkg/webhook"
+	"github.com/setavenger/go-bip352"
 	"github.com/setavenger/go-electrum/electrum"
 )
 
@@ -18,15 +21,119 @@ type Daemon struct {
 	cancelFunc        context.CancelFunc
 	ShutdownChan      chan struct{}
 	ClientElectrum    *electrum.Client
-	ClientBlindBit    *networking.ClientBlindBit
+	Backend           networking.IndexBackend
 	Wallet            *wallet.Wallet
+	Store             database.WalletStore
 	NewBlockChan      <-chan *electrum.SubscribeHeadersResult
-	TriggerRescanChan chan uint64
+	TriggerRescanChan chan RescanRequest // holds at most one waiting rescan, see RequestRescan
+
+	// rescanMu serialises RequestRescan
+	rescanMu sync.Mutex
+
+	NewUTXOFilterStats FilterStats
+
+	// Events publishes what happens to the wallet and the sync, e.g. for streaming to clients
+	Events *EventBus
+	// Webhooks is notified about payments found in blocks, nil if no endpoints are configured
+	Webhooks *webhook.Dispatcher
+
+	status syncStatus
+
+	// walletMu serialises changes to the wallet and writing it to the store.
+	// The scan loop holds it while applying a block, the API while changing labels.
+	// Readers outside of the scan loop hold the read lock, see ReadWallet.
+	walletMu sync.RWMutex
+
+	// balance of the last balance_changed event, nil if none was published yet
+	lastBalance *wallet.Balance
+
+	// txids of the mempool transactions which were already scanned
+	mempoolScanned map[[32]byte]struct{}
+
+	// electrum subscriptions of the owned outputs, only set while ContinuousScan runs with electrum
+	watcher *scripthashWatcher
 }
 
-// Will try to load a wallet from disk or will create a new one based on the blindbit.toml config-file
-func SetupDaemon(path string) (*Daemon, error) {
-	clientBlindBit := networking.ClientBlindBit{BaseUrl: config.BlindBitServerAddress}
+// RescanRequest asks the daemon to rescan everything from Height up to the chain tip
+type RescanRequest struct {
+	Height     uint64
+	TweakIndex bool            // use the full tweak index instead of the cut-through tweaks for this rescan
+	Labels     []*bip352.Label // targeted rescan which only checks these labels, all labels if empty
+}
+
+// merge combines two rescans into one which covers both
+func (r RescanRequest) merge(other RescanRequest) RescanRequest {
+	merged := RescanRequest{
+		Height:     min(r.Height, other.Height),
+		TweakIndex: r.TweakIndex || other.TweakIndex,
+	}
+	if len(r.Labels) == 0 || len(other.Labels) == 0 {
+		return merged
+	}
+	merged.Labels = append(merged.Labels, r.Labels...)
+	for _, label := range other.Labels {
+		if !slices.ContainsFunc(merged.Labels, func(l *bip352.Label) bool { return l.M == label.M }) {
+			merged.Labels = append(merged.Labels, label)
+		}
+	}
+	return merged
+}
+
+// RequestRescan queues req for the scan loop without waiting for it.
+// A rescan which is still waiting is merged with req, so the scan loop runs one rescan covering both.
+func (d *Daemon) RequestRescan(req RescanRequest) {
+	d.rescanMu.Lock()
+	defer d.rescanMu.Unlock()
+	select {
+	case waiting := <-d.TriggerRescanChan:
+		req = waiting.merge(req)
+	default:
+	}
+	// only RequestRescan sends and the buffer was emptied above, so this does not block
+	d.TriggerRescanChan <- req
+}
+
+// newIndexBackend creates the backend which serves the block data for scanning
+func newIndexBackend() networking.IndexBackend {
+	switch config.IndexBackend {
+	case "bitcoind":
+		return networking.NewClientBitcoinCore(
+			config.BitcoindRpcUrl,
+			config.BitcoindRpcUser,
+			config.BitcoindRpcPass,
+			config.BitcoindRpcCookie,
+		)
+	default:
+		if len(config.BlindBitServerAddresses) < 2 {
+			return newOracleBackend(config.BlindBitServerAddress)
+		}
+		servers := make([]networking.OracleServer, len(config.BlindBitServerAddresses))
+		for i, address := range config.BlindBitServerAddresses {
+			servers[i] = networking.OracleServer{Name: address, Backend: newOracleBackend(address)}
+		}
+		return networking.NewMultiBackend(servers, config.OracleParanoid)
+	}
+}
+
+// newOracleBackend creates the client for a single oracle server, every server gets its own circuit breaker
+func newOracleBackend(address string) networking.IndexBackend {
+	return &networking.ClientBlindBit{
+		BaseUrl: address,
+		Oracle: networking.NewOracleClient(networking.OracleClientOptions{
+			Timeout:          config.OracleTimeout,
+			Retries:          config.OracleRetries,
+			MinBackoff:       500 * time.Millisecond,
+			MaxBackoff:       10 * time.Second,
+			BreakerThreshold: config.OracleBreakerThreshold,
+			BreakerCooldown:  config.OracleBreakerCooldown,
+			Proxy:            config.OracleTorProxyHost,
+		}),
+	}
+}
+
+// Will try to load a wallet from the store or will create a new one based on the blindbit.toml config-file
+func SetupDaemon(store database.WalletStore) (*Daemon, error) {
+	backend := newIndexBackend()
 	var clientElectrum *electrum.Client
 	var err error
 
@@ -39,12 +146,12 @@ func SetupDaemon(path string) (*Daemon, error) {
 		}
 	}
 
-	w, err := database.TryLoadWalletFromDisk(path)
+	w, err := database.TryLoadWallet(store)
 	if err != nil {
 		logging.L.Err(err).Msg("")
 		return nil, err
 	}
-	d, err := NewDaemon(w, &clientBlindBit, clientElectrum)
+	d, err := NewDaemon(w, store, backend, clientElectrum)
 	if err != nil {
 		logging.L.Err(err).Msg("")
 		return nil, err
@@ -53,7 +160,12 @@ func SetupDaemon(path string) (*Daemon, error) {
 	return d, err
 }
 
-func NewDaemon(wallet *wallet.Wallet, clientBlindBit *networking.ClientBlindBit, clientElectrum *electrum.Client) (*Daemon, error) {
+func NewDaemon(
+	wallet *wallet.Wallet,
+	store database.WalletStore,
+	backend networking.IndexBackend,
+	clientElectrum *electrum.Client,
+) (*Daemon, error) {
 	var channel <-chan *electrum.SubscribeHeadersResult
 	var err error
 	if config.UseElectrum {
@@ -66,15 +178,18 @@ func NewDaemon(wallet *wallet.Wallet, clientBlindBit *networking.ClientBlindBit,
 
 	daemon := Daemon{
 		Wallet:            wallet,
-		ClientBlindBit:    clientBlindBit,
+		Store:             store,
+		Backend:           backend,
 		ClientElectrum:    clientElectrum,
 		ShutdownChan:      make(chan struct{}),
 		NewBlockChan:      channel,
-		TriggerRescanChan: make(chan uint64),
+		TriggerRescanChan: make(chan RescanRequest, 1),
+		Events:            NewEventBus(),
 	}
 	ctx, cancel := context.WithCancel(context.Background())
 	daemon.ctx = ctx
 	daemon.cancelFunc = cancel
+	daemon.setBackendContext()
 
 	return &daemon, nil
 }
@@ -84,7 +199,7 @@ func NewDaemon(wallet *wallet.Wallet, clientBlindBit *networking.ClientBlindBit,
 //	 todo: remove and change the flow of the program.
 //		have proper handling of non existent keys on the first startup
 func SetupDaemonNoWallet() (*Daemon, error) {
-	clientBlindBit := networking.ClientBlindBit{BaseUrl: config.BlindBitServerAddress}
+	backend := newIndexBackend()
 	var clientElectrum *electrum.Client
 	var err error
 
@@ -97,7 +212,13 @@ func SetupDaemonNoWallet() (*Daemon, error) {
 		}
 	}
 
-	d, err := NewDaemon(nil, &clientBlindBit, clientElectrum)
+	store, err := database.NewWalletStore()
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	d, err := NewDaemon(nil, store, backend, clientElectrum)
 	if err != nil {
 		logging.L.Err(err).Msg("")
 		return nil, err
@@ -106,17 +227,18 @@ func SetupDaemonNoWallet() (*Daemon, error) {
 	return d, err
 }
 
-// ResetDaemonAndWallet deletes the stored wallet DB
+// ResetDaemonAndWallet deletes the stored wallet DB and continues with w
 // used when new keys are added such that scanning continues from scratch
-func (d *Daemon) ResetDaemonAndWallet() (err error) {
+func (d *Daemon) ResetDaemonAndWallet(w *wallet.Wallet) (err error) {
 	d.Cancel()
-	err = os.Remove(config.PathDbWallet)
-	if err != nil && !errors.Is(err, os.ErrNotExist) {
+	d.walletMu.Lock()
+	defer d.walletMu.Unlock()
+	err = d.Store.Reset()
+	if err != nil {
 		logging.L.Err(err).Msg("")
 		return
 	}
-	// clean out the error
-	err = nil
+	d.Wallet = w
 	return
 }
 
@@ -125,8 +247,61 @@ func (d *Daemon) Cancel() {
 	ctx, cancel := context.WithCancel(context.Background())
 	d.ctx = ctx
 	d.cancelFunc = cancel
+	d.setBackendContext()
+}
+
+// setBackendContext ties the requests of the backend to d.ctx
+func (d *Daemon) setBackendContext() {
+	if backend, ok := d.Backend.(networking.ContextBackend); ok {
+		backend.SetContext(d.ctx)
+	}
+}
+
+// ReadWallet calls f with the wallet while the scan loop can not change it.
+// f must not keep references to utxos of the wallet after it returned.
+func (d *Daemon) ReadWallet(f func(w *wallet.Wallet)) {
+	d.walletMu.RLock()
+	defer d.walletMu.RUnlock()
+	f(d.Wallet)
 }
 
 func (d *Daemon) SaveWalletToDB() (err error) {
-	return database.WriteWalletToDB(config.PathDbWallet, d.Wallet)
+	d.walletMu.Lock()
+	defer d.walletMu.Unlock()
+	return d.Store.SaveWallet(d.Wallet)
+}
+
+// AddLabel creates a new label with name and saves the wallet
+func (d *Daemon) AddLabel(name string) (*bip352.Label, error) {
+	d.walletMu.Lock()
+	defer d.walletMu.Unlock()
+
+	label, err := d.Wallet.AddLabel(name)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+	err = d.Store.SaveWallet(d.Wallet)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+	return label, nil
+}
+
+// SetLabelName renames the label m and saves the wallet
+func (d *Daemon) SetLabelName(m uint32, name string) error {
+	d.walletMu.Lock()
+	defer d.walletMu.Unlock()
+
+	err := d.Wallet.SetLabelName(m, name)
+	if err != nil {
+		return err
+	}
+	err = d.Store.SaveWallet(d.Wallet)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	return nil
 }
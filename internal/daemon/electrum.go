@@ -0,0 +1,365 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"sync"
+
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/pkg/database"
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/utils"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+	"github.com/setavenger/go-electrum/electrum"
+)
+
+// electrumBackend is the part of the electrum client used to decide the state of an owned output
+type electrumBackend interface {
+	ListUnspent(ctx context.Context, scripthash string) ([]*electrum.ListUnspentResult, error)
+	GetHistory(ctx context.Context, scripthash string) ([]*electrum.GetMempoolResult, error)
+	GetRawTransaction(ctx context.Context, txHash string) (string, error)
+}
+
+// scripthashSubscription is the part of the electrum subscription used by scripthashWatcher
+type scripthashSubscription interface {
+	Add(ctx context.Context, scripthash string, address ...string) error
+	Remove(scripthash string) error
+}
+
+// scripthashWatcher keeps the electrum subscriptions of the owned outputs.
+// Notified scripthashes are only collected here, they are checked by the scan loop which owns the wallet.
+type scripthashWatcher struct {
+	sub scripthashSubscription
+
+	mu         sync.Mutex
+	subscribed map[string]struct{}
+	pending    map[string]struct{}
+	wake       chan struct{}
+}
+
+func newScripthashWatcher(ctx context.Context, client *electrum.Client) *scripthashWatcher {
+	sub, notifications := client.SubscribeScripthash()
+	w := &scripthashWatcher{
+		sub:        sub,
+		subscribed: make(map[string]struct{}),
+		pending:    make(map[string]struct{}),
+		wake:       make(chan struct{}, 1),
+	}
+
+	// the subscription blocks until a notification is read, also while adding scripthashes
+	go func() {
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case notification := <-notifications:
+				w.mu.Lock()
+				w.pending[notification.Params[0]] = struct{}{}
+				w.mu.Unlock()
+				select {
+				case w.wake <- struct{}{}:
+				default:
+				}
+			}
+		}
+	}()
+
+	return w
+}
+
+// track subscribes to the scripthashes which are not subscribed yet
+// and drops the subscriptions of scripthashes which are no longer tracked, e.g. because all their outputs are spent.
+// The server answers a new subscription with the current status, so every new scripthash is checked once.
+// The client can't unsubscribe at the server, notifications of dropped scripthashes are ignored.
+func (w *scripthashWatcher) track(ctx context.Context, scripthashes map[string][]*wallet.OwnedUTXO) error {
+	w.mu.Lock()
+	var dropped []string
+	for scripthash := range w.subscribed {
+		if _, ok := scripthashes[scripthash]; !ok {
+			dropped = append(dropped, scripthash)
+		}
+	}
+	w.mu.Unlock()
+
+	for _, scripthash := range dropped {
+		err := w.sub.Remove(scripthash)
+		if err != nil {
+			return err
+		}
+		w.mu.Lock()
+		delete(w.subscribed, scripthash)
+		delete(w.pending, scripthash)
+		w.mu.Unlock()
+	}
+
+	for scripthash := range scripthashes {
+		w.mu.Lock()
+		_, ok := w.subscribed[scripthash]
+		w.mu.Unlock()
+		if ok {
+			continue
+		}
+
+		err := w.sub.Add(ctx, scripthash)
+		if err != nil {
+			return err
+		}
+
+		w.mu.Lock()
+		w.subscribed[scripthash] = struct{}{}
+		w.mu.Unlock()
+	}
+	return nil
+}
+
+func (w *scripthashWatcher) takePending() map[string]struct{} {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	pending := w.pending
+	w.pending = make(map[string]struct{})
+	return pending
+}
+
+// trackedUTXOs groups the outputs whose spends are tracked via electrum by scripthash.
+// Several outputs can share a script if a sender reuses it.
+func (d *Daemon) trackedUTXOs() map[string][]*wallet.OwnedUTXO {
+	d.walletMu.RLock()
+	defer d.walletMu.RUnlock()
+
+	byScripthash := make(map[string][]*wallet.OwnedUTXO)
+	for _, utxo := range d.Wallet.GetUTXOsByStates(wallet.StateUnspent, wallet.StateUnconfirmedSpent) {
+		scripthash := utils.ConvertPubKeyToScriptHash(utxo.PubKey)
+		byScripthash[scripthash] = append(byScripthash[scripthash], utxo)
+	}
+	return byScripthash
+}
+
+// watchUTXOs subscribes to the scripthashes of new outputs and drops the ones of spent outputs.
+// Without a running subscription, e.g. outside of ContinuousScan, all outputs are checked once instead.
+func (d *Daemon) watchUTXOs() error {
+	if !config.UseElectrum {
+		return nil
+	}
+	if d.watcher == nil {
+		return d.CheckUnspentUTXOs()
+	}
+
+	err := d.watcher.track(d.ctx, d.trackedUTXOs())
+	d.status.recordElectrum(err)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	return nil
+}
+
+// handleScripthashNotifications checks the outputs of all scripthashes whose status changed
+// and drops the subscriptions of scripthashes whose outputs are all spent now
+func (d *Daemon) handleScripthashNotifications() error {
+	pending := d.watcher.takePending()
+	tracked := d.trackedUTXOs()
+	for scripthash := range tracked {
+		if _, ok := pending[scripthash]; !ok {
+			delete(tracked, scripthash)
+		}
+	}
+	err := d.checkUTXOs(d.ClientElectrum, tracked)
+	if err != nil {
+		return err
+	}
+	return d.watchUTXOs()
+}
+
+// CheckUnspentUTXOs checks against electrum whether the unspent owned outputs are spent by now
+func (d *Daemon) CheckUnspentUTXOs() error {
+	if !config.UseElectrum {
+		// we don't use Electrum if set to false
+		logging.L.Warn().Msg("electrum is not configured")
+		return nil
+	}
+	return d.checkUTXOs(d.ClientElectrum, d.trackedUTXOs())
+}
+
+// checkUTXOs updates the state of the given outputs and commits and publishes the changes
+func (d *Daemon) checkUTXOs(client electrumBackend, byScripthash map[string][]*wallet.OwnedUTXO) error {
+	var changes []outpointChange
+	for scripthash, utxos := range byScripthash {
+		scripthashChanges, err := d.outpointStates(client, scripthash, utxos)
+		d.status.recordElectrum(err)
+		if err != nil {
+			logging.L.Err(err).Msg("")
+			return err
+		}
+		changes = append(changes, scripthashChanges...)
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	d.walletMu.Lock()
+	defer d.walletMu.Unlock()
+
+	update := &database.WalletUpdate{}
+	var changed [][36]byte
+	previousStates := make(map[[36]byte]wallet.UTXOState)
+	for _, change := range changes {
+		utxo := change.utxo
+		key, err := utxo.GetKey()
+		if err != nil {
+			logging.L.Err(err).Msg("")
+			return err
+		}
+		previousStates[key] = utxo.State
+		changed = append(changed, key)
+
+		utxo.State = change.state
+		utxo.SpentTxid = change.spentTxid
+		utxo.SpentHeight = change.spentHeight
+		update.UTXOs = append(update.UTXOs, utxo)
+	}
+
+	err := d.Store.Commit(d.Wallet, update)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	d.publishUTXOs(EventUTXOStateChanged, changed, previousStates)
+	d.publishBalance()
+	return nil
+}
+
+type outpointChange struct {
+	utxo        *wallet.OwnedUTXO
+	state       wallet.UTXOState
+	spentTxid   [32]byte
+	spentHeight uint64
+}
+
+// outpointStates decides the state of each output of a script by its outpoint.
+// Outputs in listunspent are unspent, the server leaves out outputs spent in the mempool.
+// For the others the spending transaction is searched in the history of the script,
+// its height tells whether the spend is confirmed. Outputs whose spend can't be found are left as they are.
+func (d *Daemon) outpointStates(
+	client electrumBackend,
+	scripthash string,
+	utxos []*wallet.OwnedUTXO,
+) (
+	[]outpointChange,
+	error,
+) {
+	unspent, err := client.ListUnspent(d.ctx, scripthash)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []outpointChange
+	var spent []*wallet.OwnedUTXO
+	for _, utxo := range utxos {
+		txid := hex.EncodeToString(utxo.Txid[:])
+		var isUnspent bool
+		for _, output := range unspent {
+			if output.Hash == txid && output.Position == utxo.Vout {
+				isUnspent = true
+				break
+			}
+		}
+		switch {
+		case !isUnspent:
+			spent = append(spent, utxo)
+		case utxo.State == wallet.StateUnconfirmedSpent:
+			// the spending transaction left the mempool
+			changes = append(changes, outpointChange{utxo: utxo, state: wallet.StateUnspent})
+		}
+	}
+	if len(spent) == 0 {
+		return changes, nil
+	}
+
+	history, err := client.GetHistory(d.ctx, scripthash)
+	if err != nil {
+		return nil, err
+	}
+
+	ownTxids := make(map[string]struct{}, len(utxos))
+	for _, utxo := range utxos {
+		ownTxids[hex.EncodeToString(utxo.Txid[:])] = struct{}{}
+	}
+
+	for _, tx := range history {
+		if len(spent) == 0 {
+			break
+		}
+		// the receiving transactions can't spend the outputs
+		if _, ok := ownTxids[tx.Hash]; ok {
+			continue
+		}
+
+		rawTx, err := client.GetRawTransaction(d.ctx, tx.Hash)
+		if err != nil {
+			return nil, err
+		}
+		spentTxid, spends, err := spendsOutpoints(rawTx, spent)
+		if err != nil {
+			logging.L.Warn().Err(err).Str("txid", tx.Hash).Msg("invalid transaction in history")
+			continue
+		}
+
+		var remaining []*wallet.OwnedUTXO
+		for _, utxo := range spent {
+			if _, ok := spends[utxo]; !ok {
+				remaining = append(remaining, utxo)
+				continue
+			}
+			change := outpointChange{utxo: utxo, state: wallet.StateUnconfirmedSpent, spentTxid: spentTxid}
+			// unconfirmed transactions have a height of 0 or -1
+			if tx.Height > 0 {
+				change.state = wallet.StateSpent
+				change.spentHeight = uint64(tx.Height)
+			}
+			if change.state != utxo.State || change.spentHeight != utxo.SpentHeight {
+				changes = append(changes, change)
+			}
+		}
+		spent = remaining
+	}
+
+	for _, utxo := range spent {
+		logging.L.Debug().Hex("txid", utxo.Txid[:]).Uint32("vout", utxo.Vout).
+			Msg("output is not unspent but no spending transaction was found")
+	}
+
+	return changes, nil
+}
+
+// spendsOutpoints returns the txid of rawTx and which of the utxos it spends
+func spendsOutpoints(rawTx string, utxos []*wallet.OwnedUTXO) ([32]byte, map[*wallet.OwnedUTXO]struct{}, error) {
+	txBytes, err := hex.DecodeString(rawTx)
+	if err != nil {
+		return [32]byte{}, nil, err
+	}
+	var tx wire.MsgTx
+	err = tx.Deserialize(bytes.NewReader(txBytes))
+	if err != nil {
+		return [32]byte{}, nil, err
+	}
+
+	spends := make(map[*wallet.OwnedUTXO]struct{})
+	for _, in := range tx.TxIn {
+		for _, utxo := range utxos {
+			// the wallet keeps txids in the human-readable byte order
+			if in.PreviousOutPoint.Index == utxo.Vout && in.PreviousOutPoint.Hash.String() == hex.EncodeToString(utxo.Txid[:]) {
+				spends[utxo] = struct{}{}
+			}
+		}
+	}
+
+	hash := tx.TxHash()
+	txid, err := hex.DecodeString(hash.String())
+	if err != nil {
+		return [32]byte{}, nil, err
+	}
+	return [32]byte(txid), spends, nil
+}
@@ -0,0 +1,180 @@
+package daemon
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"testing"
+
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/btcsuite/btcd/wire"
+	"github.com/setavenger/blindbit-scan/pkg/networking"
+	"github.com/setavenger/blindbit-scan/pkg/utils"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+	"github.com/setavenger/go-electrum/electrum"
+)
+
+type fakeElectrum struct {
+	unspent map[string][]*electrum.ListUnspentResult
+	history map[string][]*electrum.GetMempoolResult
+	txs     map[string]string
+}
+
+func (f *fakeElectrum) ListUnspent(_ context.Context, scripthash string) ([]*electrum.ListUnspentResult, error) {
+	return f.unspent[scripthash], nil
+}
+
+func (f *fakeElectrum) GetHistory(_ context.Context, scripthash string) ([]*electrum.GetMempoolResult, error) {
+	return f.history[scripthash], nil
+}
+
+func (f *fakeElectrum) GetRawTransaction(_ context.Context, txHash string) (string, error) {
+	return f.txs[txHash], nil
+}
+
+// spendingTx returns the txid and the raw hex of a transaction spending txid:vout
+func spendingTx(t *testing.T, txid [32]byte, vout uint32) (string, string) {
+	t.Helper()
+	hash, err := chainhash.NewHashFromStr(hex.EncodeToString(txid[:]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(hash, vout), nil, nil))
+	tx.AddTxOut(wire.NewTxOut(1_000, []byte{0x51}))
+	var buf bytes.Buffer
+	if err = tx.Serialize(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return tx.TxHash().String(), hex.EncodeToString(buf.Bytes())
+}
+
+func TestCheckUTXOsByOutpoint(t *testing.T) {
+	d := newTestDaemon(t, networking.NewFixtureBackend())
+
+	// a sender reused the script, both outputs share the scripthash
+	_, output := testPayment(t, d.Wallet, "sender-1", nil)
+	_, other := testPayment(t, d.Wallet, "sender-2", nil)
+	_, err := d.Wallet.AddUTXOs([]*wallet.OwnedUTXO{
+		{Txid: [32]byte{1}, Vout: 0, Amount: 10_000, PubKey: output, State: wallet.StateUnspent},
+		{Txid: [32]byte{2}, Vout: 1, Amount: 20_000, PubKey: output, State: wallet.StateUnspent},
+		{Txid: [32]byte{3}, Vout: 0, Amount: 30_000, PubKey: other, State: wallet.StateUnspent},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scripthash := utils.ConvertPubKeyToScriptHash(output)
+	otherScripthash := utils.ConvertPubKeyToScriptHash(other)
+	mempoolSpend, mempoolRaw := spendingTx(t, [32]byte{1}, 0)
+	confirmedSpend, confirmedRaw := spendingTx(t, [32]byte{3}, 0)
+	txid1, txid2 := [32]byte{1}, [32]byte{2}
+	client := &fakeElectrum{
+		unspent: map[string][]*electrum.ListUnspentResult{
+			scripthash: {{Hash: hex.EncodeToString(txid2[:]), Position: 1, Height: 5, Value: 20_000}},
+		},
+		history: map[string][]*electrum.GetMempoolResult{
+			scripthash:      {{Hash: mempoolSpend, Height: 0}},
+			otherScripthash: {{Hash: confirmedSpend, Height: 7}},
+		},
+		txs: map[string]string{mempoolSpend: mempoolRaw, confirmedSpend: confirmedRaw},
+	}
+
+	if err = d.checkUTXOs(client, d.trackedUTXOs()); err != nil {
+		t.Fatal(err)
+	}
+
+	states := make(map[byte]*wallet.OwnedUTXO)
+	for _, utxo := range d.Wallet.UTXOs {
+		states[utxo.Txid[0]] = utxo
+	}
+	if utxo := states[1]; utxo.State != wallet.StateUnconfirmedSpent || hex.EncodeToString(utxo.SpentTxid[:]) != mempoolSpend {
+		t.Errorf("expected utxo 1 to be spent in the mempool, got %s", utxo.State)
+	}
+	if utxo := states[2]; utxo.State != wallet.StateUnspent {
+		t.Errorf("expected utxo 2 to stay unspent, got %s", utxo.State)
+	}
+	if utxo := states[3]; utxo.State != wallet.StateSpent || utxo.SpentHeight != 7 {
+		t.Errorf("expected utxo 3 to be spent at height 7, got %s at %d", utxo.State, utxo.SpentHeight)
+	}
+
+	// the mempool transaction got dropped
+	client.unspent[scripthash] = append(client.unspent[scripthash],
+		&electrum.ListUnspentResult{Hash: hex.EncodeToString(txid1[:]), Position: 0, Height: 5, Value: 10_000},
+	)
+	if err = d.checkUTXOs(client, d.trackedUTXOs()); err != nil {
+		t.Fatal(err)
+	}
+	if utxo := states[1]; utxo.State != wallet.StateUnspent || utxo.SpentTxid != [32]byte{} {
+		t.Errorf("expected utxo 1 to be unspent again, got %s", utxo.State)
+	}
+}
+
+type fakeSubscription struct {
+	subscribed map[string]struct{}
+}
+
+func (f *fakeSubscription) Add(_ context.Context, scripthash string, _ ...string) error {
+	f.subscribed[scripthash] = struct{}{}
+	return nil
+}
+
+func (f *fakeSubscription) Remove(scripthash string) error {
+	delete(f.subscribed, scripthash)
+	return nil
+}
+
+func TestScripthashWatcherDropsSpentScripthashes(t *testing.T) {
+	d := newTestDaemon(t, networking.NewFixtureBackend())
+
+	_, output := testPayment(t, d.Wallet, "sender-1", nil)
+	_, other := testPayment(t, d.Wallet, "sender-2", nil)
+	_, err := d.Wallet.AddUTXOs([]*wallet.OwnedUTXO{
+		{Txid: [32]byte{1}, Vout: 0, Amount: 10_000, PubKey: output, State: wallet.StateUnspent},
+		{Txid: [32]byte{2}, Vout: 0, Amount: 20_000, PubKey: other, State: wallet.StateUnspent},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	scripthash := utils.ConvertPubKeyToScriptHash(output)
+	otherScripthash := utils.ConvertPubKeyToScriptHash(other)
+
+	sub := &fakeSubscription{subscribed: make(map[string]struct{})}
+	d.watcher = &scripthashWatcher{
+		sub:        sub,
+		subscribed: make(map[string]struct{}),
+		pending:    make(map[string]struct{}),
+		wake:       make(chan struct{}, 1),
+	}
+	if err = d.watcher.track(context.Background(), d.trackedUTXOs()); err != nil {
+		t.Fatal(err)
+	}
+	if len(sub.subscribed) != 2 {
+		t.Fatalf("expected 2 subscriptions, got %d", len(sub.subscribed))
+	}
+
+	// the output on the other script gets spent
+	spend, raw := spendingTx(t, [32]byte{2}, 0)
+	txid1 := [32]byte{1}
+	client := &fakeElectrum{
+		unspent: map[string][]*electrum.ListUnspentResult{
+			scripthash: {{Hash: hex.EncodeToString(txid1[:]), Position: 0, Height: 5, Value: 10_000}},
+		},
+		history: map[string][]*electrum.GetMempoolResult{otherScripthash: {{Hash: spend, Height: 7}}},
+		txs:     map[string]string{spend: raw},
+	}
+	d.watcher.pending[otherScripthash] = struct{}{}
+	if err = d.checkUTXOs(client, d.trackedUTXOs()); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.watcher.track(context.Background(), d.trackedUTXOs()); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := sub.subscribed[otherScripthash]; ok || len(sub.subscribed) != 1 {
+		t.Errorf("expected only the scripthash with an unspent output to stay subscribed, got %d", len(sub.subscribed))
+	}
+	if _, ok := d.watcher.pending[otherScripthash]; ok {
+		t.Errorf("expected the pending notification of the dropped scripthash to be forgotten")
+	}
+}
@@ -0,0 +1,132 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+)
+
+type EventType string
+
+const (
+	EventBlockScanned     EventType = "block_scanned"
+	EventUTXOFound        EventType = "utxo_found"
+	EventUTXOStateChanged EventType = "utxo_state_changed"
+	EventRescanStarted    EventType = "rescan_started"
+	EventRescanFinished   EventType = "rescan_finished"
+	EventBalanceChanged   EventType = "balance_changed"
+	EventSyncError        EventType = "sync_error"
+	// EventOracleDisagreement is published once when scanning halts because the oracle servers disagree
+	EventOracleDisagreement EventType = "oracle_disagreement"
+)
+
+// Event is published by the daemon whenever the wallet or the sync changes
+type Event struct {
+	Type EventType `json:"type"`
+	Time int64     `json:"time"` // unix timestamp
+	Data any       `json:"data"`
+}
+
+type BlockScannedData struct {
+	Height    uint64 `json:"height"`
+	BlockHash string `json:"block_hash"`
+	Added     int    `json:"added"` // utxos added or promoted by the block
+	Spent     int    `json:"spent"` // utxos marked as spent by the block
+}
+
+// UTXOEventData carries the utxo without its tweaks, see wallet.OwnedUTXO.Redacted
+type UTXOEventData struct {
+	UTXO          *wallet.OwnedUTXO `json:"utxo"`
+	PreviousState *wallet.UTXOState `json:"previous_state,omitempty"` // only for utxo_state_changed
+}
+
+type RescanData struct {
+	From   uint64   `json:"from"`
+	To     uint64   `json:"to,omitempty"`
+	Labels []uint32 `json:"labels,omitempty"` // empty if all labels are checked
+	Err    string   `json:"err,omitempty"`    // only for rescan_finished
+}
+
+type SyncErrorData struct {
+	Err string `json:"err"`
+}
+
+// EventBus fans out the events of the daemon to all subscribers.
+// Publishing never blocks the scan, events for subscribers which don't keep up are dropped.
+// All methods are safe to call on a nil bus.
+type EventBus struct {
+	mu          sync.Mutex
+	subscribers map[chan Event]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{subscribers: make(map[chan Event]struct{})}
+}
+
+// Subscribe returns a channel receiving all events published from now on.
+// unsubscribe has to be called once the subscriber is done, it closes the channel.
+func (b *EventBus) Subscribe(buffer int) (events <-chan Event, unsubscribe func()) {
+	ch := make(chan Event, buffer)
+	if b == nil {
+		close(ch)
+		return ch, func() {}
+	}
+
+	b.mu.Lock()
+	b.subscribers[ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	return ch, func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers, ch)
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+}
+
+func (b *EventBus) Publish(eventType EventType, data any) {
+	if b == nil {
+		return
+	}
+	event := Event{Type: eventType, Time: time.Now().Unix(), Data: data}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for ch := range b.subscribers {
+		select {
+		case ch <- event:
+		default:
+			logging.L.Debug().Str("type", string(eventType)).Msg("subscriber too slow, dropped event")
+		}
+	}
+}
+
+// publishUTXOs publishes an event for every utxo of keys, previousStates is only set for state changes
+func (d *Daemon) publishUTXOs(eventType EventType, keys [][36]byte, previousStates map[[36]byte]wallet.UTXOState) {
+	for _, key := range keys {
+		utxo := d.Wallet.GetUTXO(key)
+		if utxo == nil {
+			continue
+		}
+		data := UTXOEventData{UTXO: utxo.Redacted()}
+		if previous, ok := previousStates[key]; ok {
+			data.PreviousState = &previous
+		}
+		d.Events.Publish(eventType, data)
+	}
+}
+
+// publishBalance publishes the balance if it changed since it was last published
+func (d *Daemon) publishBalance() {
+	balance := d.Wallet.GetBalance()
+	if d.lastBalance != nil && *d.lastBalance == balance {
+		return
+	}
+	d.lastBalance = &balance
+	d.Events.Publish(EventBalanceChanged, balance)
+}
@@ -0,0 +1,88 @@
+package daemon
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/setavenger/blindbit-scan/pkg/networking"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+)
+
+func TestEventBusDropsForSlowSubscribers(t *testing.T) {
+	bus := NewEventBus()
+	events, unsubscribe := bus.Subscribe(1)
+
+	bus.Publish(EventBlockScanned, nil)
+	bus.Publish(EventSyncError, nil) // buffer is full, dropped
+
+	if event := <-events; event.Type != EventBlockScanned {
+		t.Errorf("expected %s, got %s", EventBlockScanned, event.Type)
+	}
+
+	unsubscribe()
+	unsubscribe()
+	bus.Publish(EventBlockScanned, nil)
+	if _, ok := <-events; ok {
+		t.Errorf("expected the channel to be closed")
+	}
+
+	var nilBus *EventBus
+	nilBus.Publish(EventBlockScanned, nil)
+}
+
+func TestSyncToTipPublishesEvents(t *testing.T) {
+	backend := networking.NewFixtureBackend()
+	d := newTestDaemon(t, backend)
+
+	setPaymentBlock(t, backend, d.Wallet, nil)
+	backend.SetBlock(7, &networking.FixtureBlock{
+		BlockHash:      sha256.Sum256([]byte("block-7")),
+		SpentOutpoints: []networking.Outpoint{{Txid: [32]byte{1}, Vout: 0}},
+	})
+
+	events, unsubscribe := d.Events.Subscribe(100)
+	defer unsubscribe()
+
+	if err := d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+	unsubscribe()
+
+	var blocks int
+	var types []EventType
+	for event := range events {
+		if event.Type == EventBlockScanned {
+			blocks++
+			continue
+		}
+		types = append(types, event.Type)
+
+		switch data := event.Data.(type) {
+		case UTXOEventData:
+			if data.UTXO.PrivKeyTweak != [32]byte{} {
+				t.Errorf("expected the utxo to be redacted")
+			}
+			if event.Type == EventUTXOStateChanged && (data.PreviousState == nil || *data.PreviousState != wallet.StateUnspent) {
+				t.Errorf("expected previous state unspent")
+			}
+		case wallet.Balance:
+		default:
+			t.Errorf("unexpected data %T for %s", event.Data, event.Type)
+		}
+	}
+
+	// heights 2 to 8, the wallet starts with a scan height of 1
+	if blocks != 7 {
+		t.Errorf("expected 7 block_scanned events, got %d", blocks)
+	}
+	expected := []EventType{EventUTXOFound, EventBalanceChanged, EventUTXOStateChanged, EventBalanceChanged}
+	if len(types) != len(expected) {
+		t.Fatalf("expected events %v, got %v", expected, types)
+	}
+	for i := range expected {
+		if types[i] != expected[i] {
+			t.Errorf("expected events %v, got %v", expected, types)
+			break
+		}
+	}
+}
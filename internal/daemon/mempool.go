@@ -0,0 +1,102 @@
+package daemon
+
+import (
+	"time"
+
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/pkg/database"
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/metrics"
+	"github.com/setavenger/blindbit-scan/pkg/networking"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+)
+
+// ScanMempool scans the unconfirmed transactions served by the backend for outputs which belong to the wallet.
+// Found outputs are added as unconfirmed and promoted once the block which confirms them is scanned.
+// Unconfirmed outputs whose transaction left the mempool (evicted, replaced or double-spent) are dropped.
+// This only happens if the wallet is synced to the tip, otherwise the transaction might have been mined.
+func (d *Daemon) ScanMempool() error {
+	backend, ok := d.Backend.(networking.MempoolBackend)
+	if !ok {
+		logging.L.Warn().Msg("index backend does not support mempool scanning")
+		return nil
+	}
+
+	txs, err := backend.GetMempoolTransactions()
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	labelsToCheck := d.labelsToCheck(scanOptions{})
+	firstSeen := uint64(time.Now().Unix())
+
+	inMempool := make(map[[32]byte]struct{}, len(txs))
+	var ownedUTXOs []*wallet.OwnedUTXO
+	for _, tx := range txs {
+		inMempool[tx.Txid] = struct{}{}
+
+		// every transaction only has to be checked once while it is in the mempool
+		if _, ok = d.mempoolScanned[tx.Txid]; ok {
+			continue
+		}
+		if config.DustLimit > 0 && tx.HighestValue < config.DustLimit {
+			continue
+		}
+
+		found, err := d.scanTransactionOutputs(tx.Tweak, tx.Outputs, labelsToCheck)
+		if err != nil {
+			logging.L.Err(err).Msg("")
+			return err
+		}
+		for _, utxo := range found {
+			utxo.State = wallet.StateUnconfirmed
+			utxo.Timestamp = firstSeen
+		}
+		ownedUTXOs = append(ownedUTXOs, found...)
+	}
+
+	// forget transactions which left the mempool, keeps the set bounded by the mempool size
+	d.mempoolScanned = inMempool
+
+	// the chain tip has to be checked after the mempool was fetched.
+	// A transaction mined in between is then either still in the fetched mempool or above the scanned height.
+	chainTip, err := d.getChainTip()
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	d.walletMu.Lock()
+	defer d.walletMu.Unlock()
+
+	added, err := d.Wallet.AddUTXOs(ownedUTXOs)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	metrics.UTXOsFound.WithLabelValues("mempool").Add(float64(len(added.New)))
+
+	var removed [][36]byte
+	if d.Wallet.LastScanHeight >= chainTip {
+		removed = d.Wallet.RemoveUnconfirmedUTXOs(inMempool)
+	}
+
+	if len(added.New) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	logging.L.Info().Int("added", len(added.New)).Int("removed", len(removed)).Msg("mempool scanned")
+
+	update := &database.WalletUpdate{RemovedUTXOs: removed}
+	update.PutKeys(d.Wallet, added.New...)
+	err = d.Store.Commit(d.Wallet, update)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	d.publishUTXOs(EventUTXOFound, added.New, nil)
+	d.publishBalance()
+	return nil
+}
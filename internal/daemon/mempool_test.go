@@ -0,0 +1,72 @@
+package daemon
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/setavenger/blindbit-scan/pkg/networking"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+)
+
+func TestScanMempool(t *testing.T) {
+	backend := networking.NewFixtureBackend()
+	d := newTestDaemon(t, backend)
+	backend.SetChainTip(4)
+
+	if err := d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+
+	tweak1, output1 := testPayment(t, d.Wallet, "sender-1", nil)
+	tweak2, output2 := testPayment(t, d.Wallet, "sender-2", nil)
+
+	backend.SetMempool([]*networking.MempoolTransaction{
+		{Txid: [32]byte{1}, Tweak: tweak1, HighestValue: 10_000, Outputs: []*networking.UTXOServed{testUTXO(1, 0, 10_000, output1, [32]byte{})}},
+		{Txid: [32]byte{2}, Tweak: tweak2, HighestValue: 20_000, Outputs: []*networking.UTXOServed{testUTXO(2, 0, 20_000, output2, [32]byte{})}},
+	})
+
+	if err := d.ScanMempool(); err != nil {
+		t.Fatal(err)
+	}
+	if unconfirmed := d.Wallet.GetUTXOsByStates(wallet.StateUnconfirmed); len(unconfirmed) != 2 {
+		t.Fatalf("expected 2 unconfirmed utxos, got %d", len(unconfirmed))
+	}
+	if d.Wallet.FreeBalance() != 0 {
+		t.Errorf("unconfirmed utxos should not count towards the balance")
+	}
+
+	// the first transaction confirms, the second one is replaced
+	hash5 := sha256.Sum256([]byte("block-5"))
+	backend.SetBlock(5, &networking.FixtureBlock{
+		BlockHash: hash5,
+		Tweaks:    []networking.IndexedTweak{{Tweak: tweak1, HighestValue: 10_000}},
+		UTXOs:     []*networking.UTXOServed{testUTXO(1, 0, 10_000, output1, hash5)},
+	})
+	backend.SetMempool(nil)
+
+	// not synced to the new tip yet, nothing may be dropped
+	if err := d.ScanMempool(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Wallet.UTXOs) != 2 {
+		t.Fatalf("expected unconfirmed utxos to be kept until the wallet is synced, got %d utxos", len(d.Wallet.UTXOs))
+	}
+
+	if err := d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.ScanMempool(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.Wallet.UTXOs) != 1 {
+		t.Fatalf("expected the replaced utxo to be dropped, got %d utxos", len(d.Wallet.UTXOs))
+	}
+	utxo := d.Wallet.UTXOs[0]
+	if utxo.Txid != [32]byte{1} || utxo.State != wallet.StateUnspent {
+		t.Errorf("expected the confirmed utxo to be promoted to unspent, got %x in state %s", utxo.Txid, utxo.State)
+	}
+	if d.Wallet.FreeBalance() != 10_000 {
+		t.Errorf("expected balance of 10000, got %d", d.Wallet.FreeBalance())
+	}
+}
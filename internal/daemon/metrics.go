@@ -0,0 +1,75 @@
+package daemon
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+)
+
+var (
+	scanHeightDesc = prometheus.NewDesc(
+		"blindbit_scan_height", "Last height scanned by the wallet.", nil, nil,
+	)
+	chainTipDesc = prometheus.NewDesc(
+		"blindbit_chain_tip", "Last known chain tip of the backend.", nil, nil,
+	)
+	chainTipLagDesc = prometheus.NewDesc(
+		"blindbit_chain_tip_lag_blocks", "Blocks the scan height is behind the chain tip.", nil, nil,
+	)
+	blocksPerSecondDesc = prometheus.NewDesc(
+		"blindbit_scan_blocks_per_second", "Scan rate of the current sync or rescan, 0 if idle.", nil, nil,
+	)
+	balanceDesc = prometheus.NewDesc(
+		"blindbit_wallet_balance_sats", "Balance of the wallet per utxo state, spent utxos are not counted.", []string{"state"}, nil,
+	)
+	utxosDesc = prometheus.NewDesc(
+		"blindbit_wallet_utxos", "Number of utxos in the wallet per state.", []string{"state"}, nil,
+	)
+)
+
+// MetricsCollector exposes the sync status and the wallet of the daemon.
+// The values are read on every scrape, so nothing has to be updated by the scan loop.
+type MetricsCollector struct {
+	d *Daemon
+}
+
+func NewMetricsCollector(d *Daemon) *MetricsCollector {
+	return &MetricsCollector{d: d}
+}
+
+func (c *MetricsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- scanHeightDesc
+	ch <- chainTipDesc
+	ch <- chainTipLagDesc
+	ch <- blocksPerSecondDesc
+	ch <- balanceDesc
+	ch <- utxosDesc
+}
+
+func (c *MetricsCollector) Collect(ch chan<- prometheus.Metric) {
+	status := c.d.Status()
+	ch <- prometheus.MustNewConstMetric(scanHeightDesc, prometheus.GaugeValue, float64(status.ScanHeight))
+	ch <- prometheus.MustNewConstMetric(chainTipDesc, prometheus.GaugeValue, float64(status.ChainTip))
+	ch <- prometheus.MustNewConstMetric(chainTipLagDesc, prometheus.GaugeValue, float64(status.BlocksBehind))
+	ch <- prometheus.MustNewConstMetric(blocksPerSecondDesc, prometheus.GaugeValue, status.BlocksPerSecond)
+
+	c.d.walletMu.RLock()
+	defer c.d.walletMu.RUnlock()
+	if c.d.Wallet == nil {
+		return
+	}
+
+	balance := c.d.Wallet.GetBalance()
+	ch <- prometheus.MustNewConstMetric(balanceDesc, prometheus.GaugeValue, float64(balance.Confirmed), wallet.StateUnspent.String())
+	ch <- prometheus.MustNewConstMetric(balanceDesc, prometheus.GaugeValue, float64(balance.Unconfirmed), wallet.StateUnconfirmed.String())
+	ch <- prometheus.MustNewConstMetric(balanceDesc, prometheus.GaugeValue, float64(balance.UnconfirmedSpent), wallet.StateUnconfirmedSpent.String())
+
+	counts := make(map[wallet.UTXOState]int)
+	for _, utxo := range c.d.Wallet.UTXOs {
+		counts[utxo.State]++
+	}
+	for _, state := range []wallet.UTXOState{
+		wallet.StateUnconfirmed, wallet.StateUnspent, wallet.StateUnconfirmedSpent, wallet.StateSpent,
+	} {
+		ch <- prometheus.MustNewConstMetric(utxosDesc, prometheus.GaugeValue, float64(counts[state]), state.String())
+	}
+}
@@ -0,0 +1,56 @@
+package daemon
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/setavenger/blindbit-scan/pkg/metrics"
+	"github.com/setavenger/blindbit-scan/pkg/networking"
+)
+
+func TestMetrics(t *testing.T) {
+	backend := networking.NewFixtureBackend()
+	d := newTestDaemon(t, backend)
+
+	setPaymentBlock(t, backend, d.Wallet, nil)
+
+	// the counters are global, only the difference is checked
+	blocksBefore := testutil.ToFloat64(metrics.BlocksScanned)
+	foundBefore := testutil.ToFloat64(metrics.UTXOsFound.WithLabelValues("block"))
+	hitsBefore := testutil.ToFloat64(metrics.FilterHits.WithLabelValues(string(networking.NewUTXOFilterType)))
+
+	if err := d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if blocks := testutil.ToFloat64(metrics.BlocksScanned) - blocksBefore; blocks != 7 {
+		t.Errorf("expected 7 scanned blocks, got %v", blocks)
+	}
+	if found := testutil.ToFloat64(metrics.UTXOsFound.WithLabelValues("block")) - foundBefore; found != 1 {
+		t.Errorf("expected 1 found utxo, got %v", found)
+	}
+	if hits := testutil.ToFloat64(metrics.FilterHits.WithLabelValues(string(networking.NewUTXOFilterType))) - hitsBefore; hits != 1 {
+		t.Errorf("expected 1 filter hit, got %v", hits)
+	}
+
+	expected := `
+# HELP blindbit_chain_tip_lag_blocks Blocks the scan height is behind the chain tip.
+# TYPE blindbit_chain_tip_lag_blocks gauge
+blindbit_chain_tip_lag_blocks 0
+# HELP blindbit_scan_height Last height scanned by the wallet.
+# TYPE blindbit_scan_height gauge
+blindbit_scan_height 8
+# HELP blindbit_wallet_balance_sats Balance of the wallet per utxo state, spent utxos are not counted.
+# TYPE blindbit_wallet_balance_sats gauge
+blindbit_wallet_balance_sats{state="unconfirmed"} 0
+blindbit_wallet_balance_sats{state="unconfirmed_spent"} 0
+blindbit_wallet_balance_sats{state="unspent"} 10000
+`
+	err := testutil.CollectAndCompare(NewMetricsCollector(d), strings.NewReader(expected),
+		"blindbit_chain_tip_lag_blocks", "blindbit_scan_height", "blindbit_wallet_balance_sats",
+	)
+	if err != nil {
+		t.Error(err)
+	}
+}
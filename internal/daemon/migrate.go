@@ -0,0 +1,55 @@
+package daemon
+
+import (
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+)
+
+// MigrateWallet brings wallets written by older versions up to wallet.WalletVersion
+func (d *Daemon) MigrateWallet() error {
+	if d.Wallet.Version >= wallet.WalletVersion {
+		return nil
+	}
+
+	if d.Wallet.Version < 1 {
+		err := d.migrateBlockData()
+		if err != nil {
+			logging.L.Err(err).Msg("")
+			return err
+		}
+	}
+
+	d.walletMu.Lock()
+	d.Wallet.Version = wallet.WalletVersion
+	d.walletMu.Unlock()
+	return d.SaveWalletToDB()
+}
+
+// migrateBlockData fills in block height, block hash and spent height of existing utxos.
+// Utxos don't carry enough information to look up their block, so everything is rescanned from birth height.
+// The full tweak index is needed to find the blocks of utxos which are spent by now.
+func (d *Daemon) migrateBlockData() error {
+	var missing, spent int
+	for _, utxo := range d.Wallet.UTXOs {
+		if utxo.State == wallet.StateUnconfirmed || utxo.BlockHeight != 0 {
+			continue
+		}
+		missing++
+		if utxo.State == wallet.StateSpent {
+			spent++
+		}
+	}
+	if missing == 0 {
+		return nil
+	}
+
+	logging.L.Info().
+		Int("utxos", missing).
+		Uint64("from", d.Wallet.BirthHeight).
+		Msg("migrating wallet, rescanning to fill in the block data of utxos")
+
+	return d.ForceSyncFrom(RescanRequest{
+		Height:     d.Wallet.BirthHeight,
+		TweakIndex: spent > 0,
+	})
+}
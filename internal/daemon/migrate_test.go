@@ -0,0 +1,65 @@
+package daemon
+
+import (
+	"crypto/sha256"
+	"testing"
+
+	"github.com/setavenger/blindbit-scan/pkg/networking"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+)
+
+func TestMigrateWalletFillsInBlockData(t *testing.T) {
+	backend := networking.NewFixtureBackend()
+	d := newTestDaemon(t, backend)
+
+	_, output1 := testPayment(t, d.Wallet, "sender-1", nil)
+	tweak2, output2 := testPayment(t, d.Wallet, "sender-2", nil)
+
+	// block 5 also holds a second payment which is spent in block 6
+	block := setPaymentBlock(t, backend, d.Wallet, nil)
+	hash5 := block.BlockHash
+	spentUTXO := testUTXO(2, 0, 20_000, output2, hash5)
+	spentUTXO.Spent = true
+	block.Tweaks = append(block.Tweaks, networking.IndexedTweak{Tweak: tweak2, HighestValue: 20_000, Spent: true})
+	block.UTXOs = append(block.UTXOs, spentUTXO)
+	backend.SetBlock(6, &networking.FixtureBlock{
+		BlockHash:      sha256.Sum256([]byte("block-6")),
+		SpentOutpoints: []networking.Outpoint{{Txid: [32]byte{2}, Vout: 0}},
+	})
+
+	// a wallet written by an older version, the utxos were found but without any block data
+	d.Wallet.Version = 0
+	d.Wallet.LastScanHeight = 8
+	_, err := d.Wallet.AddUTXOs([]*wallet.OwnedUTXO{
+		{Txid: [32]byte{1}, Vout: 0, Amount: 10_000, PubKey: output1, State: wallet.StateUnspent},
+		{Txid: [32]byte{2}, Vout: 0, Amount: 20_000, PubKey: output2, State: wallet.StateSpent},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err = d.MigrateWallet(); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Wallet.Version != wallet.WalletVersion {
+		t.Errorf("expected wallet version %d, got %d", wallet.WalletVersion, d.Wallet.Version)
+	}
+	if len(d.Wallet.UTXOs) != 2 {
+		t.Fatalf("expected 2 utxos, got %d", len(d.Wallet.UTXOs))
+	}
+	for _, utxo := range d.Wallet.UTXOs {
+		if utxo.BlockHeight != 5 || utxo.BlockHash != hash5 {
+			t.Errorf("expected block data of block 5 for %x, got height %d", utxo.Txid[:1], utxo.BlockHeight)
+		}
+	}
+	if spent := d.Wallet.GetUTXOsByStates(wallet.StateSpent); len(spent) != 1 || spent[0].SpentHeight != 6 {
+		t.Errorf("expected the spent utxo to get spent height 6")
+	}
+	// the utxo was spent before, filling in the height is no state change
+	for _, block := range d.Wallet.ScannedBlocks {
+		if block.Height == 6 && (len(block.Spent) != 0 || len(block.SpentHeightFilled) != 1) {
+			t.Errorf("expected only the spent height to be filled in at 6, got %d state changes", len(block.Spent))
+		}
+	}
+}
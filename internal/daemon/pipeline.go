@@ -0,0 +1,235 @@
+package daemon
+
+import (
+	"context"
+	"encoding/hex"
+	"sync"
+
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/pkg/database"
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/metrics"
+	"github.com/setavenger/blindbit-scan/pkg/networking"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+	"github.com/setavenger/go-bip352"
+)
+
+// scanOptions control how the heights of a single sync or rescan are scanned
+type scanOptions struct {
+	tweakIndex bool            // use the full tweak index instead of the cut-through tweaks
+	labels     []*bip352.Label // only check these labels instead of all labels of the wallet
+
+	// also match spent utxos without a spent height to fill it in, e.g. utxos of older wallets.
+	// Only done for rescans, syncing to the tip would check them for every new block.
+	fillSpentHeights bool
+}
+
+// blockScanResult holds everything that was fetched and computed for a single height.
+// Results are produced concurrently by the workers and applied to the wallet in height order.
+type blockScanResult struct {
+	height      uint64
+	spentFilter *networking.Filter
+	ownedUTXOs  []*wallet.OwnedUTXO
+	err         error
+}
+
+// scanHeight fetches and scans a single height.
+// It only reads the keys and labels of the wallet and can therefore run concurrently.
+// The spent outpoints filter is only fetched here,
+// matching it has to wait until all previous heights were committed.
+func (d *Daemon) scanHeight(blockHeight uint64, opts scanOptions) *blockScanResult {
+	result := &blockScanResult{height: blockHeight}
+
+	result.spentFilter, result.err = d.Backend.GetFilter(blockHeight, networking.SpentOutpointsFilterType)
+	if result.err != nil {
+		return result
+	}
+
+	result.ownedUTXOs, result.err = d.syncBlock(blockHeight, result.spentFilter.BlockHash, opts)
+	return result
+}
+
+// syncRange scans all heights from startHeight up to and including endHeight.
+// Up to config.ScanConcurrency heights are fetched and scanned in parallel.
+// The results are committed strictly in height order.
+func (d *Daemon) syncRange(startHeight, endHeight uint64, opts scanOptions) error {
+	// keep a reference, d.ctx is swapped out when the daemon is cancelled
+	ctx := d.ctx
+
+	d.status.startRange(startHeight, endHeight)
+
+	workers := config.ScanConcurrency
+	if workers < 1 {
+		workers = 1
+	}
+
+	pipelineCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// window bounds how far the workers can run ahead of the next height that has to be committed
+	window := make(chan struct{}, 2*workers)
+	heights := make(chan uint64)
+	results := make(chan *blockScanResult, workers)
+
+	go func() {
+		defer close(heights)
+		for i := startHeight; i < endHeight+1; i++ {
+			select {
+			case window <- struct{}{}:
+			case <-pipelineCtx.Done():
+				return
+			}
+			select {
+			case heights <- i:
+			case <-pipelineCtx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for height := range heights {
+				select {
+				case results <- d.scanHeight(height, opts):
+				case <-pipelineCtx.Done():
+					return
+				}
+			}
+		}()
+	}
+
+	go func() {
+		wg.Wait()
+		close(results)
+	}()
+
+	pending := make(map[uint64]*blockScanResult, 2*workers)
+	next := startHeight
+	for result := range results {
+		pending[result.height] = result
+		for {
+			nextResult, ok := pending[next]
+			if !ok {
+				break
+			}
+			delete(pending, next)
+
+			// otherwise the scan height will be overriden between key reset and cleaning up of sync loop
+			if ctx.Err() != nil {
+				logging.L.Info().Msg("aborted sync")
+				return ctx.Err()
+			}
+
+			err := d.commitBlock(nextResult, endHeight, opts)
+			if err != nil {
+				return err
+			}
+			d.status.blockCommitted()
+			<-window
+			next++
+		}
+	}
+
+	if next < endHeight+1 {
+		// workers only stop early if the context was cancelled
+		logging.L.Info().Msg("aborted sync")
+		return ctx.Err()
+	}
+
+	return nil
+}
+
+// commitBlock applies the result of a scanned height to the wallet.
+// Has to be called in height order. chainTip is the height the sync goes up to.
+func (d *Daemon) commitBlock(result *blockScanResult, chainTip uint64, opts scanOptions) error {
+	if result.err != nil {
+		logging.L.Err(result.err).Uint64("height", result.height).Msg("")
+		return result.err
+	}
+
+	// possible logging here to indicate to the user
+	logging.L.Info().Uint64("height", result.height).Msg("syncing")
+
+	d.walletMu.Lock()
+	defer d.walletMu.Unlock()
+
+	scannedBlock := &wallet.ScannedBlock{
+		Height:    result.height,
+		BlockHash: result.spentFilter.BlockHash,
+	}
+
+	var err error
+	scannedBlock.Spent, scannedBlock.SpentHeightFilled, err = d.markSpentUTXOs(result.height, result.spentFilter, opts.fillSpentHeights) // this can probably be omitted if electrum is used
+	if err != nil {
+		logging.L.Err(err).Uint64("height", result.height).Msg("error marking utxos")
+		return err
+	}
+
+	if result.ownedUTXOs != nil {
+		// queued before the utxos are added, otherwise a failed attempt marks the payments as known.
+		// If the daemon stops before the block is committed the block is scanned again, queued payments are not repeated.
+		err = d.notifyWebhooks(d.newPayments(result.ownedUTXOs), chainTip)
+		if err != nil {
+			logging.L.Err(err).Uint64("height", result.height).Msg("could not queue webhooks")
+			return err
+		}
+
+		added, err := d.Wallet.AddUTXOs(result.ownedUTXOs)
+		if err != nil {
+			logging.L.Err(err).Msg("")
+			return err
+		}
+		scannedBlock.Added, scannedBlock.Confirmed, scannedBlock.Backfilled = added.New, added.Confirmed, added.Backfilled
+		logging.L.Info().Msg("Added UTXOs to wallet")
+		metrics.UTXOsFound.WithLabelValues("block").Add(float64(len(scannedBlock.Added)))
+	}
+	d.Wallet.RecordScannedBlock(scannedBlock, config.ReorgWindow)
+	d.Wallet.LastScanHeight = result.height
+
+	update := &database.WalletUpdate{}
+	update.PutKeys(d.Wallet, scannedBlock.Added...)
+	update.PutKeys(d.Wallet, scannedBlock.Confirmed...)
+	update.PutKeys(d.Wallet, scannedBlock.Backfilled...)
+	update.PutKeys(d.Wallet, scannedBlock.SpentHeightFilled...)
+	for key := range scannedBlock.Spent {
+		update.PutKeys(d.Wallet, key)
+	}
+
+	// blocks without changes are only written every now and then to save the scan height
+	if !update.IsEmpty() || result.height%100 == 0 {
+		err = d.Store.Commit(d.Wallet, update)
+		if err != nil {
+			logging.L.Err(err).Uint64("height", result.height).Msg("")
+			return err
+		}
+	}
+
+	metrics.BlocksScanned.Inc()
+	d.publishScannedBlock(scannedBlock)
+	return nil
+}
+
+func (d *Daemon) publishScannedBlock(block *wallet.ScannedBlock) {
+	d.Events.Publish(EventBlockScanned, BlockScannedData{
+		Height:    block.Height,
+		BlockHash: hex.EncodeToString(block.BlockHash[:]),
+		Added:     len(block.Added) + len(block.Confirmed),
+		Spent:     len(block.Spent),
+	})
+	if len(block.Added) == 0 && len(block.Confirmed) == 0 && len(block.Spent) == 0 {
+		return
+	}
+
+	d.publishUTXOs(EventUTXOFound, block.Added, nil)
+	d.publishUTXOs(EventUTXOFound, block.Confirmed, nil)
+	spent := make([][36]byte, 0, len(block.Spent))
+	for key := range block.Spent {
+		spent = append(spent, key)
+	}
+	d.publishUTXOs(EventUTXOStateChanged, spent, block.Spent)
+	d.publishBalance()
+}
@@ -0,0 +1,78 @@
+package daemon
+
+import (
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/networking"
+)
+
+// getBlockHash returns the hash of the block the indexing server currently has at blockHeight.
+// The spent outpoints filter is used as it is small and always available.
+func (d *Daemon) getBlockHash(blockHeight uint64) ([32]byte, error) {
+	filter, err := d.Backend.GetFilter(blockHeight, networking.SpentOutpointsFilterType)
+	if err != nil {
+		logging.L.Err(err).Uint64("height", blockHeight).Msg("")
+		return [32]byte{}, err
+	}
+	return filter.BlockHash, nil
+}
+
+// checkForReorg compares the recently scanned blocks against the blocks the indexing server currently has.
+// If the chain changed, all wallet changes above the fork point are rolled back
+// and the scan height is reset such that the next sync scans the new blocks.
+func (d *Daemon) checkForReorg() error {
+	blocks := d.Wallet.ScannedBlocks
+	if len(blocks) == 0 {
+		return nil
+	}
+
+	tip := blocks[len(blocks)-1]
+	forkHeight := blocks[0].Height - 1
+	var foundForkPoint bool
+
+	// walk back from the tip until we find a block that is still part of the chain
+	for i := len(blocks) - 1; i >= 0; i-- {
+		blockHash, err := d.getBlockHash(blocks[i].Height)
+		if err != nil {
+			return err
+		}
+		if blockHash == blocks[i].BlockHash {
+			forkHeight = blocks[i].Height
+			foundForkPoint = true
+			break
+		}
+		logging.L.Warn().
+			Uint64("height", blocks[i].Height).
+			Hex("scanned", blocks[i].BlockHash[:]).
+			Hex("current", blockHash[:]).
+			Msg("block was reorged out")
+	}
+
+	if foundForkPoint && forkHeight == tip.Height {
+		return nil
+	}
+
+	if !foundForkPoint {
+		logging.L.Warn().
+			Uint64("from", blocks[0].Height).
+			Uint64("to", tip.Height).
+			Msg("reorg is deeper than the tracked window, rolling back the entire window")
+	}
+
+	d.walletMu.Lock()
+	defer d.walletMu.Unlock()
+
+	rolledBack := d.Wallet.RollbackTo(forkHeight)
+	logging.L.Warn().
+		Uint64("fork_height", forkHeight).
+		Int("blocks", rolledBack).
+		Msg("rolled back wallet to fork point")
+
+	err := d.Store.SaveWallet(d.Wallet)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	d.publishBalance()
+	return nil
+}
@@ -2,43 +2,55 @@ package daemon
 
 import (
 	"bytes"
-	"context"
-	"crypto/sha256"
-	"encoding/binary"
+	"errors"
 	"fmt"
 	"log"
+	"sync/atomic"
 	"time"
 
 	"github.com/btcsuite/btcd/btcutil/gcs"
 	"github.com/btcsuite/btcd/btcutil/gcs/builder"
 	"github.com/btcsuite/btcd/chaincfg/chainhash"
 	"github.com/setavenger/blindbit-scan/internal/config"
-	"github.com/setavenger/blindbit-scan/pkg/database"
 	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/metrics"
 	"github.com/setavenger/blindbit-scan/pkg/networking"
 	"github.com/setavenger/blindbit-scan/pkg/utils" // todo move blindbitd/src to a pkg for all blindbit programs
 	"github.com/setavenger/blindbit-scan/pkg/wallet"
 	"github.com/setavenger/go-bip352"
 )
 
+// FilterStats keeps track of how the new utxos filter performs.
+// The counters are updated concurrently by the scan workers.
+type FilterStats struct {
+	Checked        atomic.Uint64 // number of blocks where the filter was checked
+	Hits           atomic.Uint64 // number of blocks where the filter matched
+	FalsePositives atomic.Uint64 // number of matches where none of our outputs was in the block
+}
+
 type TweakScriptMap struct {
 	Tweak        [33]byte
 	ScriptPubKey [32]byte
 }
 
-func (d *Daemon) syncBlock(blockHeight uint64) ([]*wallet.OwnedUTXO, error) {
-	tweaks, err := d.ClientBlindBit.GetTweaks(blockHeight, config.DustLimit)
+// syncBlock scans the block at blockHeight for outputs which belong to the wallet.
+// blockHash is the hash the height is expected to have,
+// if the indexing server serves data for a different block utils.ErrBlockHashMismatch is returned.
+func (d *Daemon) syncBlock(blockHeight uint64, blockHash [32]byte, opts scanOptions) ([]*wallet.OwnedUTXO, error) {
+	var tweaks [][33]byte
+	var err error
+	if opts.tweakIndex {
+		tweaks, err = d.Backend.GetTweakIndex(blockHeight, config.DustLimit)
+	} else {
+		tweaks, err = d.Backend.GetTweaks(blockHeight, config.DustLimit)
+	}
 	if err != nil {
 		logging.L.Err(err).Msg("")
 		return nil, err
 	}
+	metrics.TweaksPerBlock.Observe(float64(len(tweaks)))
 
-	labelsToCheck := make([]*bip352.Label, len(d.Wallet.Labels))
-	i := 0
-	for _, l := range d.Wallet.Labels {
-		labelsToCheck[i] = l
-		i++
-	}
+	labelsToCheck := d.labelsToCheck(opts)
 
 	// Map Tweaks to ScriptPubKey
 	tweakToScriptMap := make(map[[32]byte]TweakScriptMap)
@@ -98,25 +110,40 @@ func (d *Daemon) syncBlock(blockHeight uint64) ([]*wallet.OwnedUTXO, error) {
 		return nil, nil
 	}
 
-	// todo filter whether we should get the outputs in the first place
+	// only download the outputs of the block if any of the precomputed outputs (incl. label variants) can be in there
+	potentialOutputs := make([][]byte, 0, len(tweakToScriptMap))
+	for scriptPub32 := range tweakToScriptMap {
+		output := scriptPub32
+		potentialOutputs = append(potentialOutputs, output[:])
+	}
+
+	filterData, err := d.Backend.GetFilter(blockHeight, networking.NewUTXOFilterType)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	if filterData.BlockHash != blockHash {
+		err = fmt.Errorf("%w: new utxos filter for height %d", utils.ErrBlockHashMismatch, blockHeight)
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
 
-	// 	filterData, err := d.ClientBlindBit.GetFilter(blockHeight, networking.NewUTXOFilterType)
-	// 	if err != nil {
-	// 		logging.L.Err(err).Msg("")
-	// 		return nil, err
-	// 	}
-	//
-	// 	isMatch, err := matchFilter(filterData.Data, filterData.BlockHash, potentialOutputs)
-	// 	if err != nil {
-	// 		logging.L.Err(err).Msg("")
-	// 		return nil, err
-	// 	}
-	// 	if !isMatch {
-	// 		return nil, nil
-	// 	}
+	isMatch, err := matchFilter(filterData.Data, filterData.BlockHash, potentialOutputs)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+	d.NewUTXOFilterStats.Checked.Add(1)
+	metrics.FilterChecks.WithLabelValues(string(networking.NewUTXOFilterType)).Inc()
+	if !isMatch {
+		return nil, nil
+	}
+	d.NewUTXOFilterStats.Hits.Add(1)
+	metrics.FilterHits.WithLabelValues(string(networking.NewUTXOFilterType)).Inc()
 
 	// Retrieve and Group Block Outputs by ScriptPubKey
-	utxos, err := d.ClientBlindBit.GetUTXOs(blockHeight)
+	utxos, err := d.Backend.GetUTXOs(blockHeight)
 	if err != nil {
 		logging.L.Err(err).Msg("")
 		return nil, err
@@ -125,6 +152,11 @@ func (d *Daemon) syncBlock(blockHeight uint64) ([]*wallet.OwnedUTXO, error) {
 	txidGroups := make(map[[32]byte][]*networking.UTXOServed) // txid -> utxos with that txid
 	helperMapping := make(map[[32]byte][32]byte)              // helper mapping: output to txid
 	for _, utxo := range utxos {
+		if utxo.BlockHash != blockHash {
+			err = fmt.Errorf("%w: utxos for height %d", utils.ErrBlockHashMismatch, blockHeight)
+			logging.L.Err(err).Msg("")
+			return nil, err
+		}
 		txidGroups[utxo.Txid] = append(txidGroups[utxo.Txid], utxo)
 		helperMapping[bip352.ConvertToFixedLength32(utxo.ScriptPubKey[2:])] = utxo.Txid
 	}
@@ -143,48 +175,91 @@ func (d *Daemon) syncBlock(blockHeight uint64) ([]*wallet.OwnedUTXO, error) {
 		}
 	}
 
+	if len(tweaksOutputsToCheckMap) == 0 {
+		// the filter matched but none of the precomputed outputs is actually in the block
+		falsePositives := d.NewUTXOFilterStats.FalsePositives.Add(1)
+		metrics.FilterFalsePositives.WithLabelValues(string(networking.NewUTXOFilterType)).Inc()
+		logging.L.Debug().
+			Uint64("height", blockHeight).
+			Uint64("false_positives", falsePositives).
+			Uint64("hits", d.NewUTXOFilterStats.Hits.Load()).
+			Msg("new utxos filter false positive")
+		return nil, nil
+	}
+
 	// Scan Only Relevant Groups
 	var ownedUTXOs []*wallet.OwnedUTXO
 
 	for tweak, relevantUTXOs := range tweaksOutputsToCheckMap {
-		var txOutputs [][32]byte
-		for _, utxo := range relevantUTXOs {
-			fixedLengthOutput := bip352.ConvertToFixedLength32(utxo.ScriptPubKey[2:])
-			txOutputs = append(txOutputs, fixedLengthOutput)
-		}
-
-		foundOutputsPerTweak, err := bip352.ReceiverScanTransaction(
-			d.Wallet.SecretKeyScan,
-			d.Wallet.PubKeySpend,
-			labelsToCheck,
-			txOutputs,
-			tweak,
-			nil,
-		)
+		found, err := d.scanTransactionOutputs(tweak, relevantUTXOs, labelsToCheck)
 		if err != nil {
 			logging.L.Err(err).Msg("")
 			return nil, err
 		}
+		for _, utxo := range found {
+			utxo.BlockHeight = blockHeight
+			utxo.BlockHash = blockHash
+		}
+		ownedUTXOs = append(ownedUTXOs, found...)
+	}
+
+	return ownedUTXOs, nil
+}
 
-		for _, foundOutput := range foundOutputsPerTweak {
-			for _, utxo := range relevantUTXOs {
-				if bytes.Equal(foundOutput.Output[:], utxo.ScriptPubKey[2:]) {
-					state := wallet.StateUnspent
-					if utxo.Spent {
-						state = wallet.StateSpent
-					}
-					ownedUTXOs = append(ownedUTXOs, &wallet.OwnedUTXO{
-						Txid:         utxo.Txid,
-						Vout:         utxo.Vout,
-						Amount:       utxo.Amount,
-						PrivKeyTweak: foundOutput.SecKeyTweak,
-						PubKey:       foundOutput.Output,
-						Timestamp:    utxo.Timestamp,
-						State:        state,
-						Label:        foundOutput.Label,
-					})
-					break
+func (d *Daemon) labelsToCheck(opts scanOptions) []*bip352.Label {
+	if len(opts.labels) > 0 {
+		return opts.labels
+	}
+	return d.Wallet.GetLabels()
+}
+
+// scanTransactionOutputs checks the taproot outputs of a single transaction for outputs which belong to the wallet
+func (d *Daemon) scanTransactionOutputs(
+	tweak [33]byte,
+	outputs []*networking.UTXOServed,
+	labelsToCheck []*bip352.Label,
+) (
+	[]*wallet.OwnedUTXO,
+	error,
+) {
+	var txOutputs [][32]byte
+	for _, utxo := range outputs {
+		fixedLengthOutput := bip352.ConvertToFixedLength32(utxo.ScriptPubKey[2:])
+		txOutputs = append(txOutputs, fixedLengthOutput)
+	}
+
+	foundOutputsPerTweak, err := bip352.ReceiverScanTransaction(
+		d.Wallet.SecretKeyScan,
+		d.Wallet.PubKeySpend,
+		labelsToCheck,
+		txOutputs,
+		tweak,
+		nil,
+	)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	var ownedUTXOs []*wallet.OwnedUTXO
+	for _, foundOutput := range foundOutputsPerTweak {
+		for _, utxo := range outputs {
+			if bytes.Equal(foundOutput.Output[:], utxo.ScriptPubKey[2:]) {
+				state := wallet.StateUnspent
+				if utxo.Spent {
+					state = wallet.StateSpent
 				}
+				ownedUTXOs = append(ownedUTXOs, &wallet.OwnedUTXO{
+					Txid:         utxo.Txid,
+					Vout:         utxo.Vout,
+					Amount:       utxo.Amount,
+					PrivKeyTweak: foundOutput.SecKeyTweak,
+					PubKey:       foundOutput.Output,
+					Timestamp:    utxo.Timestamp,
+					State:        state,
+					Label:        foundOutput.Label,
+				})
+				break
 			}
 		}
 	}
@@ -192,11 +267,41 @@ func (d *Daemon) syncBlock(blockHeight uint64) ([]*wallet.OwnedUTXO, error) {
 	return ownedUTXOs, nil
 }
 
+// SyncToTip scans all heights above the scan height up to chainTip, the chain tip of the backend if 0.
+// Errors are published as sync_error events.
 func (d *Daemon) SyncToTip(chainTip uint64) error {
-	var abort bool
+	if err := d.Halted(); err != nil {
+		return err
+	}
+	d.status.start(SyncModeSyncing)
+	err := d.syncToTip(chainTip)
+	d.status.finish(err)
+	if err != nil {
+		d.Events.Publish(EventSyncError, SyncErrorData{Err: err.Error()})
+		d.haltOnDisagreement(err)
+	}
+	return err
+}
+
+// Halted returns the error scanning halted with, nil if it did not.
+// Scanning halts for good if the oracle servers disagree, continuing with either answer could lose payments.
+func (d *Daemon) Halted() error {
+	return d.status.halted()
+}
+
+func (d *Daemon) haltOnDisagreement(err error) {
+	if !errors.Is(err, networking.ErrOracleDisagreement) {
+		return
+	}
+	d.status.halt(err)
+	logging.L.Error().Err(err).Msg("oracle servers disagree, scanning halted until the daemon is restarted")
+	d.Events.Publish(EventOracleDisagreement, SyncErrorData{Err: err.Error()})
+}
+
+func (d *Daemon) syncToTip(chainTip uint64) error {
 	var err error
 	if chainTip == 0 {
-		chainTip, err = d.ClientBlindBit.GetChainTip()
+		chainTip, err = d.getChainTip()
 		if err != nil {
 			logging.L.Err(err).Msg("")
 			return err
@@ -205,6 +310,12 @@ func (d *Daemon) SyncToTip(chainTip uint64) error {
 
 	logging.L.Debug().Msgf("Trying to sync to height: %d", chainTip)
 
+	err = d.checkForReorg()
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
 	// todo find fixed points for mainnet/signet/testnet where startHeight can start from. Avoid scanning through non SP merged blocks
 	var startHeight = d.Wallet.BirthHeight
 	if d.Wallet.LastScanHeight >= startHeight {
@@ -221,69 +332,13 @@ func (d *Daemon) SyncToTip(chainTip uint64) error {
 		startHeight = 1
 	}
 
-	go func() {
-		<-d.ctx.Done()
-		abort = true
-	}()
-
-	for i := startHeight; i < chainTip+1; i++ {
-		if abort {
-			err = d.ctx.Err()
-			logging.L.Info().Msg("aborted sync")
-			return err
-		}
-
-		err = d.MarkSpentUTXOs(i) // this can probably be omitted if electrum is used
-		if err != nil {
-			logging.L.Err(err).Uint64("height", i).Msg("error marking utxos")
-			return err
-		}
-		// possible logging here to indicate to the user
-		logging.L.Info().Uint64("height", i).Msg("syncing")
-		var ownedUTXOs []*wallet.OwnedUTXO
-		ownedUTXOs, err = d.syncBlock(i)
-		if err != nil {
-			logging.L.Err(err).Uint64("height", i).Msg("")
-			return err
-		}
-		if ownedUTXOs == nil {
-			if !abort {
-				// otherwise the scan height will be overriden between key reset and cleaning up of sync loop
-				// todo: make more robust, unnecessary trick
-				d.Wallet.LastScanHeight = i
-			}
-
-			if i%100 == 0 {
-				// do some writes anyways to save the last state of the scan height
-				err = database.WriteWalletToDB(config.PathDbWallet, d.Wallet)
-				if err != nil {
-					logging.L.Err(err).Uint64("height", i).Msg("")
-					return err
-				}
-			}
-			continue
-		}
-		err = d.Wallet.AddUTXOs(ownedUTXOs)
-		if err != nil {
-			logging.L.Err(err).Msg("")
-			return err
-		}
-		logging.L.Info().Msg("Added UTXOs to wallet")
-		if !abort {
-			// otherwise the scan height will be overriden between key reset and cleaning up of sync loop
-			// todo: make more robust, unnecessary trick
-			d.Wallet.LastScanHeight = i
-		}
-
-		// todo: database should be an interface to allow other forms of storing data.
-		err = database.WriteWalletToDB(config.PathDbWallet, d.Wallet)
-		if err != nil {
-			logging.L.Err(err).Msg("")
-			return err
-		}
+	err = d.syncRange(startHeight, chainTip, scanOptions{tweakIndex: config.UseTweakIndex})
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
 	}
 
-	err = d.CheckUnspentUTXOs()
+	err = d.watchUTXOs()
 	if err != nil {
 		logging.L.Err(err).Msg("")
 		return err
@@ -295,6 +350,20 @@ func (d *Daemon) ContinuousScan() (err error) {
 	logging.L.Info().Msg("starting continous scan")
 
 	ticker := time.NewTicker(config.AutomaticScanInterval)
+
+	// stays nil and never fires if mempool scanning is disabled
+	var mempoolTick <-chan time.Time
+	if config.ScanMempool {
+		mempoolTicker := time.NewTicker(config.MempoolScanInterval)
+		defer mempoolTicker.Stop()
+		mempoolTick = mempoolTicker.C
+	}
+	// the owned outputs are subscribed to after every sync, stays nil without electrum
+	var scripthashWake <-chan struct{}
+	if config.UseElectrum {
+		d.watcher = newScripthashWatcher(d.ctx, d.ClientElectrum)
+		scripthashWake = d.watcher.wake
+	}
 	t1 := make(chan struct{}, 1)
 	t1 <- struct{}{}
 
@@ -310,6 +379,10 @@ func (d *Daemon) ContinuousScan() (err error) {
 			logging.L.Info().Msg("aborted continous scan")
 			return err
 		}
+		if err = d.Halted(); err != nil {
+			logging.L.Error().Err(err).Msg("aborted continous scan, scanning halted")
+			return err
+		}
 
 		select {
 		case <-t1:
@@ -319,8 +392,14 @@ func (d *Daemon) ContinuousScan() (err error) {
 				logging.L.Err(err).Msg("could not sync to tip")
 				// return err
 			}
+			// the migration can rescan from birth height, new payments are found before that
+			err = d.MigrateWallet()
+			if err != nil {
+				logging.L.Err(err).Msg("could not migrate wallet")
+			}
 			logging.L.Info().Uint64("balance", d.Wallet.FreeBalance()).Msg("")
 		case newBlock := <-d.NewBlockChan:
+			d.status.recordElectrum(nil)
 			<-time.After(5 * time.Second) // delay, indexing server does not index immediately after a block is found
 			oldBalance := d.Wallet.FreeBalance()
 			err := d.SyncToTip(uint64(newBlock.Height))
@@ -332,9 +411,9 @@ func (d *Daemon) ContinuousScan() (err error) {
 			if oldBalance != newBalance {
 				logging.L.Info().Uint64("balance", newBalance).Msg("update")
 			}
-		case height := <-d.TriggerRescanChan:
+		case req := <-d.TriggerRescanChan:
 			oldBalance := d.Wallet.FreeBalance()
-			err := d.ForceSyncFrom(height)
+			err := d.ForceSyncFrom(req)
 			if err != nil {
 				logging.L.Err(err).Msg("could not sync to tip")
 				return err
@@ -346,7 +425,7 @@ func (d *Daemon) ContinuousScan() (err error) {
 		case <-ticker.C:
 			// todo is this needed if NewBlockChan is very robust?
 			// check every 5 minutes anyway
-			chainTip, err := d.ClientBlindBit.GetChainTip()
+			chainTip, err := d.getChainTip()
 			if err != nil {
 				logging.L.Err(err).Msg("could not get chain tip")
 				// return err
@@ -361,56 +440,45 @@ func (d *Daemon) ContinuousScan() (err error) {
 				logging.L.Err(err).Msg("could not get chain tip")
 				// return err
 			}
-		case <-time.NewTicker(1 * time.Minute).C:
-			if !config.UseElectrum {
-				continue
+		case <-mempoolTick:
+			err := d.ScanMempool()
+			if err != nil {
+				logging.L.Err(err).Msg("could not scan mempool")
 			}
-			// exclusively to check for spent UTXOs
-			err := d.CheckUnspentUTXOs()
+		case <-scripthashWake:
+			err := d.handleScripthashNotifications()
 			if err != nil {
 				logging.L.Err(err).Msg("could not check UTXO states")
-				return err
 			}
 		}
 	}
 }
 
-// CheckUnspentUTXOs
-// checks against electrum whether unspent owned UTXOs are now unspent
-func (d *Daemon) CheckUnspentUTXOs() error {
-	if !config.UseElectrum {
-		// we don't use Electrum if set to false
-		logging.L.Warn().Msg("electrum is not configured")
-		return nil
-	}
-	// todo this probably breaks if more than one UTXO are locked to a script
-	//  this should never happen if the protocol is followed but still might occur
-	for _, utxo := range d.Wallet.GetUTXOsByStates(wallet.StateUnspent, wallet.StateUnconfirmedSpent) {
-		balance, err := d.ClientElectrum.GetBalance(context.Background(), utils.ConvertPubKeyToScriptHash(utxo.PubKey))
-		if err != nil {
-			logging.L.Err(err).Msg("")
-			return err
-		}
-		if balance.Confirmed == 0.0 && balance.Unconfirmed == 0.0 {
-			utxo.State = wallet.StateSpent
-			continue
-		}
-		if balance.Unconfirmed < 0 {
-			utxo.State = wallet.StateUnconfirmedSpent
-			continue
-		}
-	}
-	return nil
-}
-
 func (d *Daemon) MarkSpentUTXOs(blockHeight uint64) error {
 	// move SpentOutpointsIndex to types
-	filter, err := d.ClientBlindBit.GetFilter(blockHeight, networking.SpentOutpointsFilterType)
+	filter, err := d.Backend.GetFilter(blockHeight, networking.SpentOutpointsFilterType)
 	if err != nil {
 		logging.L.Err(err).Msg("")
 		return err
 	}
-	hashes := d.generateLocalOutpointHashes([32]byte(filter.BlockHash))
+	_, _, err = d.markSpentUTXOs(blockHeight, filter, false)
+	return err
+}
+
+// markSpentUTXOs checks the already fetched spent outpoints filter against the current wallet state
+// and only pulls the full spent index for the height if the filter matches.
+// Returns the keys of the utxos that were marked as spent mapped to their previous state
+// and the keys of already spent utxos whose spent height was filled in, see scanOptions.fillSpentHeights.
+func (d *Daemon) markSpentUTXOs(
+	blockHeight uint64,
+	filter *networking.Filter,
+	fillSpentHeights bool,
+) (
+	map[[36]byte]wallet.UTXOState,
+	[][36]byte,
+	error,
+) {
+	hashes := d.generateLocalOutpointHashes([32]byte(filter.BlockHash), fillSpentHeights)
 
 	// convert to byte slice
 	var hashesForFilter [][]byte
@@ -423,127 +491,125 @@ func (d *Daemon) MarkSpentUTXOs(blockHeight uint64) error {
 	isMatch, err := matchFilter(filter.Data, filter.BlockHash, hashesForFilter)
 	if err != nil {
 		logging.L.Err(err).Msg("")
-		return err
+		return nil, nil, err
 	}
+	metrics.FilterChecks.WithLabelValues(string(networking.SpentOutpointsFilterType)).Inc()
 
 	if !isMatch {
-		return nil
+		return nil, nil, nil
 	}
+	metrics.FilterHits.WithLabelValues(string(networking.SpentOutpointsFilterType)).Inc()
 
-	index, err := d.ClientBlindBit.GetSpentOutpointsIndex(blockHeight)
+	index, err := d.Backend.GetSpentOutpointsIndex(blockHeight)
 	if err != nil {
 		logging.L.Err(err).Msg("")
-		return err
+		return nil, nil, err
+	}
+
+	if index.BlockHash != filter.BlockHash {
+		err = fmt.Errorf("%w: spent index for height %d", utils.ErrBlockHashMismatch, blockHeight)
+		logging.L.Err(err).Msg("")
+		return nil, nil, err
 	}
 
+	changes := make(map[[36]byte]wallet.UTXOState)
+	var filled [][36]byte
 	for _, hash := range index.Data {
 		if utxoPtr, ok := hashes[hash]; ok {
-			utxoPtr.State = wallet.StateSpent
+			key, err := utxoPtr.GetKey()
+			if err != nil {
+				logging.L.Err(err).Msg("")
+				return nil, nil, err
+			}
+			if utxoPtr.State == wallet.StateSpent {
+				filled = append(filled, key)
+			} else {
+				changes[key] = utxoPtr.State
+				utxoPtr.State = wallet.StateSpent
+			}
+			utxoPtr.SpentHeight = blockHeight
 		}
 	}
 
-	return nil
+	if len(changes) == 0 && len(filled) == 0 {
+		metrics.FilterFalsePositives.WithLabelValues(string(networking.SpentOutpointsFilterType)).Inc()
+	}
+	metrics.UTXOsSpent.Add(float64(len(changes)))
+
+	return changes, filled, nil
 }
 
-func (d *Daemon) ForceSyncFrom(fromHeight uint64) error {
-	chainTip, err := d.ClientBlindBit.GetChainTip()
+// ForceSyncFrom rescans from the requested height up to the chain tip.
+// The rescan is published as rescan_started and rescan_finished events.
+func (d *Daemon) ForceSyncFrom(req RescanRequest) error {
+	data := RescanData{From: req.Height}
+	for _, label := range req.Labels {
+		data.Labels = append(data.Labels, label.M)
+	}
+	if err := d.Halted(); err != nil {
+		return err
+	}
+	d.Events.Publish(EventRescanStarted, data)
+
+	d.status.start(SyncModeRescanning)
+	var err error
+	data.To, err = d.forceSyncFrom(req)
+	d.status.finish(err)
+	if err != nil {
+		data.Err = err.Error()
+		d.Events.Publish(EventSyncError, SyncErrorData{Err: err.Error()})
+		d.haltOnDisagreement(err)
+	}
+	d.Events.Publish(EventRescanFinished, data)
+	return err
+}
+
+// forceSyncFrom returns the chain tip the rescan went up to
+func (d *Daemon) forceSyncFrom(req RescanRequest) (uint64, error) {
+	fromHeight := req.Height
+
+	chainTip, err := d.getChainTip()
 	if err != nil {
 		logging.L.Err(err).Msg("")
-		return err
+		return 0, err
 	}
 
-	logging.L.Info().Msgf("ForceSyncFrom: %d to %d\n", fromHeight, chainTip)
+	logging.L.Info().Int("labels", len(req.Labels)).Msgf("ForceSyncFrom: %d to %d\n", fromHeight, chainTip)
+
+	err = d.checkForReorg()
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return chainTip, err
+	}
 
 	// don't check genesis block
 	if fromHeight == 0 {
 		fromHeight = 1
 	}
 
-	var abort bool
-	go func() {
-		<-d.ctx.Done()
-		abort = true
-	}()
-
-	for i := fromHeight; i < chainTip+1; i++ {
-		if abort {
-			err = d.ctx.Err()
-			logging.L.Info().Msg("aborted sync")
-			return err
-		}
-		logging.L.Info().Uint64("height", i).Msg("syncing")
-		err = d.MarkSpentUTXOs(i) // this can probably be omitted if electrum is used
-		if err != nil {
-			logging.L.Err(err).Uint64("height", i).Msg("error marking utxos")
-			return err
-		}
-
-		// possible logging here to indicate to the user
-		var ownedUTXOs []*wallet.OwnedUTXO
-		ownedUTXOs, err = d.syncBlock(i)
-		if err != nil {
-			logging.L.Err(err).Msg("")
-			return err
-		}
-		if ownedUTXOs == nil {
-			if !abort {
-				// otherwise the scan height will be overriden between key reset and cleaning up of sync loop
-				// todo: make more robust, unnecessary trick
-				d.Wallet.LastScanHeight = i
-			}
-			if i%100 == 0 {
-				// do some writes anyways to save the last state of the scan height
-				err = database.WriteWalletToDB(config.PathDbWallet, d.Wallet)
-				if err != nil {
-					logging.L.Err(err).Msg("")
-					return err
-				}
-			}
-			continue
-		}
-		err = d.Wallet.AddUTXOs(ownedUTXOs)
-		if err != nil {
-			logging.L.Err(err).Msg("")
-			return err
-		}
-		if !abort {
-			// otherwise the scan height will be overriden between key reset and cleaning up of sync loop
-			// todo: make more robust, unnecessary trick
-			d.Wallet.LastScanHeight = i
-		}
-		err = database.WriteWalletToDB(config.PathDbWallet, d.Wallet)
-		if err != nil {
-			logging.L.Err(err).Msg("")
-			return err
-		}
+	err = d.syncRange(fromHeight, chainTip, scanOptions{tweakIndex: req.TweakIndex, labels: req.Labels, fillSpentHeights: true})
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return chainTip, err
 	}
 
-	err = d.CheckUnspentUTXOs()
+	err = d.watchUTXOs()
 	if err != nil {
 		logging.L.Err(err).Msg("")
-		return err
+		return chainTip, err
 	}
 	log.Println("Rescan complete")
 	log.Println("Balance:", d.Wallet.FreeBalance())
-	return err
+	return chainTip, err
 }
 
-func (d *Daemon) generateLocalOutpointHashes(blockHash [32]byte) map[[8]byte]*wallet.OwnedUTXO {
+func (d *Daemon) generateLocalOutpointHashes(blockHash [32]byte, fillSpentHeights bool) map[[8]byte]*wallet.OwnedUTXO {
 	outputs := make(map[[8]byte]*wallet.OwnedUTXO, len(d.Wallet.UTXOs))
-	blockHashLE := bip352.ReverseBytesCopy(blockHash[:])
 	for _, utxo := range d.Wallet.UTXOs {
-		if utxo.State == wallet.StateSpent {
+		if utxo.State == wallet.StateSpent && (utxo.SpentHeight != 0 || !fillSpentHeights) {
 			continue
 		}
-		var buf bytes.Buffer
-
-		buf.Write(bip352.ReverseBytesCopy(utxo.Txid[:]))
-		binary.Write(&buf, binary.LittleEndian, utxo.Vout)
-
-		hashed := sha256.Sum256(append(buf.Bytes(), blockHashLE...))
-		var shortHash [8]byte
-		copy(shortHash[:], hashed[:])
-		outputs[shortHash] = utxo
+		outputs[networking.ComputeSpentOutpointHash(utxo.Txid, utxo.Vout, blockHash)] = utxo
 	}
 	return outputs
 }
@@ -0,0 +1,388 @@
+package daemon
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/pkg/database"
+	"github.com/setavenger/blindbit-scan/pkg/networking"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+	"github.com/setavenger/go-bip352"
+)
+
+var (
+	testScanSecret  = sha256.Sum256([]byte("scan"))
+	testSpendSecret = sha256.Sum256([]byte("spend"))
+)
+
+func newTestDaemon(t *testing.T, backend networking.IndexBackend) *Daemon {
+	t.Helper()
+
+	config.ChainParams = &chaincfg.SigNetParams
+	config.PathDbWallet = t.TempDir() + "/wallet"
+	config.ScanConcurrency = 3
+	config.ReorgWindow = 10
+	config.DustLimit = 0
+	config.UseElectrum = false
+	config.UseTweakIndex = false
+
+	_, spendPub := btcec.PrivKeyFromBytes(testSpendSecret[:])
+	w, err := wallet.SetupWallet(1, 1, testScanSecret, bip352.ConvertToFixedLength33(spendPub.SerializeCompressed()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d, err := NewDaemon(w, database.NewJSONWalletStore(config.PathDbWallet), backend, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+// testPayment creates the tweak a sender with senderSecret publishes
+// and the x-only output that pays to the test wallet with label m (nil for no label)
+func testPayment(t *testing.T, w *wallet.Wallet, senderSecret string, m *uint32) ([33]byte, [32]byte) {
+	t.Helper()
+
+	secret := sha256.Sum256([]byte(senderSecret))
+	_, pub := btcec.PrivKeyFromBytes(secret[:])
+	tweak := bip352.ConvertToFixedLength33(pub.SerializeCompressed())
+
+	sharedSecret, err := bip352.CreateSharedSecret(tweak, w.SecretKeyScan, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output, err := bip352.CreateOutputPubKey(sharedSecret, w.PubKeySpend, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m == nil {
+		return tweak, output
+	}
+
+	for _, label := range w.Labels {
+		if label.M != *m {
+			continue
+		}
+		labelled, err := bip352.AddPublicKeys(bip352.ConvertToFixedLength33(append([]byte{0x02}, output[:]...)), label.PubKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tweak, bip352.ConvertToFixedLength32(labelled[1:])
+	}
+	t.Fatalf("label %d not found", *m)
+	return [33]byte{}, [32]byte{}
+}
+
+func testUTXO(txid byte, vout uint32, amount uint64, output [32]byte, blockHash [32]byte) *networking.UTXOServed {
+	var scriptPubKey [34]byte
+	scriptPubKey[0], scriptPubKey[1] = 0x51, 0x20
+	copy(scriptPubKey[2:], output[:])
+	return &networking.UTXOServed{
+		Txid:         [32]byte{txid},
+		Vout:         vout,
+		Amount:       amount,
+		ScriptPubKey: scriptPubKey,
+		BlockHash:    blockHash,
+	}
+}
+
+// setPaymentBlock serves block 5 with a payment of 10_000 sats to the test wallet (label m, nil for none)
+// as outpoint {1}:0 and sets the chain tip to 8. The returned block is the one served.
+func setPaymentBlock(t *testing.T, backend *networking.FixtureBackend, w *wallet.Wallet, m *uint32) *networking.FixtureBlock {
+	t.Helper()
+
+	tweak, output := testPayment(t, w, "sender-1", m)
+	hash5 := sha256.Sum256([]byte("block-5"))
+	block := &networking.FixtureBlock{
+		BlockHash: hash5,
+		Tweaks:    []networking.IndexedTweak{{Tweak: tweak, HighestValue: 10_000}},
+		UTXOs:     []*networking.UTXOServed{testUTXO(1, 0, 10_000, output, hash5)},
+	}
+	backend.SetBlock(5, block)
+	backend.SetChainTip(8)
+	return block
+}
+
+func TestSyncToTipFindsAndSpendsOutputs(t *testing.T) {
+	backend := networking.NewFixtureBackend()
+	d := newTestDaemon(t, backend)
+
+	var labelM uint32 = 1
+	tweak1, output1 := testPayment(t, d.Wallet, "sender-1", nil)
+	tweak2, output2 := testPayment(t, d.Wallet, "sender-2", &labelM)
+
+	hash5 := sha256.Sum256([]byte("block-5"))
+	backend.SetBlock(5, &networking.FixtureBlock{
+		BlockHash: hash5,
+		Tweaks: []networking.IndexedTweak{
+			{Tweak: tweak1, HighestValue: 10_000},
+			{Tweak: tweak2, HighestValue: 20_000},
+		},
+		UTXOs: []*networking.UTXOServed{
+			testUTXO(1, 0, 10_000, output1, hash5),
+			testUTXO(1, 1, 5_000, sha256.Sum256([]byte("someone else")), hash5),
+			testUTXO(2, 0, 20_000, output2, hash5),
+		},
+	})
+	backend.SetBlock(7, &networking.FixtureBlock{
+		BlockHash:      sha256.Sum256([]byte("block-7")),
+		SpentOutpoints: []networking.Outpoint{{Txid: [32]byte{1}, Vout: 0}},
+	})
+	backend.SetChainTip(9)
+
+	if err := d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if d.Wallet.LastScanHeight != 9 {
+		t.Errorf("expected last scan height 9, got %d", d.Wallet.LastScanHeight)
+	}
+	if len(d.Wallet.UTXOs) != 2 {
+		t.Fatalf("expected 2 utxos, got %d", len(d.Wallet.UTXOs))
+	}
+
+	for _, utxo := range d.Wallet.UTXOs {
+		switch utxo.Txid {
+		case [32]byte{1}:
+			if utxo.State != wallet.StateSpent || utxo.SpentHeight != 7 {
+				t.Errorf("expected utxo spent in block 7 to be spent, got %s at height %d", utxo.State, utxo.SpentHeight)
+			}
+			if utxo.BlockHeight != 5 || utxo.BlockHash != hash5 {
+				t.Errorf("expected block data of block 5, got height %d", utxo.BlockHeight)
+			}
+			if utxo.Label != nil {
+				t.Errorf("expected no label, got m=%d", utxo.Label.M)
+			}
+		case [32]byte{2}:
+			if utxo.State != wallet.StateUnspent {
+				t.Errorf("expected labelled utxo to be unspent, got %s", utxo.State)
+			}
+			if utxo.Label == nil || utxo.Label.M != labelM {
+				t.Errorf("expected label m=%d", labelM)
+			}
+		default:
+			t.Errorf("unexpected utxo %x:%d", utxo.Txid, utxo.Vout)
+		}
+	}
+
+	if d.Wallet.FreeBalance() != 20_000 {
+		t.Errorf("expected balance of 20000, got %d", d.Wallet.FreeBalance())
+	}
+}
+
+func TestSyncToTipRollsBackReorg(t *testing.T) {
+	backend := networking.NewFixtureBackend()
+	d := newTestDaemon(t, backend)
+
+	tweak, output := testPayment(t, d.Wallet, "sender-1", nil)
+
+	hash5 := sha256.Sum256([]byte("block-5"))
+	backend.SetBlock(5, &networking.FixtureBlock{
+		BlockHash: hash5,
+		Tweaks:    []networking.IndexedTweak{{Tweak: tweak, HighestValue: 10_000}},
+		UTXOs:     []*networking.UTXOServed{testUTXO(1, 0, 10_000, output, hash5)},
+	})
+	backend.SetChainTip(8)
+
+	if err := d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Wallet.UTXOs) != 1 {
+		t.Fatalf("expected 1 utxo before the reorg, got %d", len(d.Wallet.UTXOs))
+	}
+
+	// blocks 5 to 9 are replaced, the payment did not make it into the new chain
+	backend.SetChainTip(4)
+	for height := uint64(5); height <= 9; height++ {
+		backend.SetBlock(height, &networking.FixtureBlock{
+			BlockHash: sha256.Sum256([]byte{'r', byte(height)}),
+		})
+	}
+
+	if err := d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.Wallet.UTXOs) != 0 {
+		t.Errorf("expected orphaned utxo to be removed, got %d utxos", len(d.Wallet.UTXOs))
+	}
+	if d.Wallet.LastScanHeight != 9 {
+		t.Errorf("expected last scan height 9, got %d", d.Wallet.LastScanHeight)
+	}
+	scanned := d.Wallet.ScannedBlocks[len(d.Wallet.ScannedBlocks)-1]
+	if scanned.Height != 9 || scanned.BlockHash != sha256.Sum256([]byte{'r', 9}) {
+		t.Errorf("expected height 9 to be recorded against the new chain")
+	}
+}
+
+func TestRescanWithTweakIndexFindsSpentOutputs(t *testing.T) {
+	backend := networking.NewFixtureBackend()
+	d := newTestDaemon(t, backend)
+
+	tweak, output := testPayment(t, d.Wallet, "sender-1", nil)
+
+	// the output was spent after it was received, cut-through removed its tweak
+	hash5 := sha256.Sum256([]byte("block-5"))
+	utxo := testUTXO(1, 0, 10_000, output, hash5)
+	utxo.Spent = true
+	backend.SetBlock(5, &networking.FixtureBlock{
+		BlockHash: hash5,
+		Tweaks:    []networking.IndexedTweak{{Tweak: tweak, HighestValue: 10_000, Spent: true}},
+		UTXOs:     []*networking.UTXOServed{utxo},
+	})
+	backend.SetChainTip(8)
+
+	if err := d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Wallet.UTXOs) != 0 {
+		t.Fatalf("cut-through tweaks should not find the spent output, got %d utxos", len(d.Wallet.UTXOs))
+	}
+
+	if err := d.ForceSyncFrom(RescanRequest{Height: 1, TweakIndex: true}); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Wallet.UTXOs) != 1 {
+		t.Fatalf("expected the tweak index to find the spent output, got %d utxos", len(d.Wallet.UTXOs))
+	}
+	found := d.Wallet.UTXOs[0]
+	if found.State != wallet.StateSpent || found.Amount != 10_000 {
+		t.Errorf("expected spent utxo with amount 10000, got %s with %d", found.State, found.Amount)
+	}
+}
+
+func TestLabelRescanFindsNewLabel(t *testing.T) {
+	backend := networking.NewFixtureBackend()
+	d := newTestDaemon(t, backend)
+
+	// the payment goes to label 2 which is only added after the first sync
+	tweak, output := testPayment(t, d.Wallet, "sender-1", nil)
+	futureLabel, err := bip352.CreateLabel(d.Wallet.SecretKeyScan, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	labelled, err := bip352.AddPublicKeys(bip352.ConvertToFixedLength33(append([]byte{0x02}, output[:]...)), futureLabel.PubKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	output = bip352.ConvertToFixedLength32(labelled[1:])
+
+	hash5 := sha256.Sum256([]byte("block-5"))
+	backend.SetBlock(5, &networking.FixtureBlock{
+		BlockHash: hash5,
+		Tweaks:    []networking.IndexedTweak{{Tweak: tweak, HighestValue: 10_000}},
+		UTXOs:     []*networking.UTXOServed{testUTXO(1, 0, 10_000, output, hash5)},
+	})
+	backend.SetChainTip(8)
+
+	if err = d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Wallet.UTXOs) != 0 {
+		t.Fatalf("payment to an unknown label should not be found, got %d utxos", len(d.Wallet.UTXOs))
+	}
+
+	label, err := d.Wallet.AddLabel("donations")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Wallet.LabelNames[label.M] != "donations" || label.M != 2 {
+		t.Fatalf("expected label 2 named donations, got %d named %q", label.M, d.Wallet.LabelNames[label.M])
+	}
+
+	err = d.ForceSyncFrom(RescanRequest{Height: d.Wallet.BirthHeight, Labels: []*bip352.Label{label}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(d.Wallet.UTXOs) != 1 || d.Wallet.UTXOs[0].Label == nil || d.Wallet.UTXOs[0].Label.M != label.M {
+		t.Fatalf("expected the payment to label %d to be found, got %d utxos", label.M, len(d.Wallet.UTXOs))
+	}
+}
+
+func TestRequestRescanMergesWaitingRescans(t *testing.T) {
+	d := newTestDaemon(t, networking.NewFixtureBackend())
+	label1 := &bip352.Label{M: 1}
+	label2 := &bip352.Label{M: 2}
+
+	// nothing receives, none of the requests may block
+	d.RequestRescan(RescanRequest{Height: 500, Labels: []*bip352.Label{label1}})
+	d.RequestRescan(RescanRequest{Height: 300, Labels: []*bip352.Label{label2}})
+	d.RequestRescan(RescanRequest{Height: 400, TweakIndex: true, Labels: []*bip352.Label{label1}})
+
+	req := <-d.TriggerRescanChan
+	if req.Height != 300 || !req.TweakIndex || len(req.Labels) != 2 {
+		t.Fatalf("expected one rescan from 300 with the tweak index for 2 labels, got %+v", req)
+	}
+
+	d.RequestRescan(RescanRequest{Height: 300, Labels: []*bip352.Label{label1}})
+	d.RequestRescan(RescanRequest{Height: 600})
+	req = <-d.TriggerRescanChan
+	if req.Height != 300 || len(req.Labels) != 0 {
+		t.Fatalf("a rescan of all labels should cover the label rescan, got %+v", req)
+	}
+	select {
+	case req = <-d.TriggerRescanChan:
+		t.Fatalf("expected a single rescan, got another one %+v", req)
+	default:
+	}
+}
+
+// run with -race, labels are changed and the wallet is read by the API while the scan loop commits blocks
+func TestLabelChangesDuringSync(t *testing.T) {
+	backend := networking.NewFixtureBackend()
+	d := newTestDaemon(t, backend)
+
+	for height := uint64(2); height <= 20; height++ {
+		tweak, output := testPayment(t, d.Wallet, fmt.Sprintf("sender-%d", height), nil)
+		hash := sha256.Sum256([]byte(fmt.Sprintf("block-%d", height)))
+		backend.SetBlock(height, &networking.FixtureBlock{
+			BlockHash: hash,
+			Tweaks:    []networking.IndexedTweak{{Tweak: tweak, HighestValue: 10_000}},
+			UTXOs:     []*networking.UTXOServed{testUTXO(byte(height), 0, 10_000, output, hash)},
+		})
+	}
+	backend.SetChainTip(20)
+
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- d.SyncToTip(0)
+	}()
+
+	for i := 0; i < 10; i++ {
+		label, err := d.AddLabel(fmt.Sprintf("label-%d", i))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = d.SetLabelName(label.M, fmt.Sprintf("renamed-%d", i)); err != nil {
+			t.Fatal(err)
+		}
+		d.Wallet.GetLabels()
+		d.ReadWallet(func(w *wallet.Wallet) {
+			w.GetBalanceBreakdown()
+			w.ListTransactions(wallet.TxFilter{})
+			if _, err := w.QueryUTXOs(wallet.UTXOQuery{}); err != nil {
+				t.Error(err)
+			}
+		})
+		d.Status()
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+
+	if len(d.Wallet.UTXOs) != 19 || len(d.Wallet.GetLabels()) != 12 {
+		t.Fatalf("expected 19 utxos and 12 labels, got %d and %d", len(d.Wallet.UTXOs), len(d.Wallet.GetLabels()))
+	}
+	loaded, err := d.Store.LoadWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.LabelName(11) != "renamed-9" {
+		t.Errorf("expected the stored wallet to have the renamed label, got %q", loaded.LabelName(11))
+	}
+}
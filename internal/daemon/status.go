@@ -0,0 +1,205 @@
+package daemon
+
+import (
+	"sync"
+	"time"
+
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+)
+
+type SyncMode string
+
+const (
+	SyncModeIdle       SyncMode = "idle"
+	SyncModeSyncing    SyncMode = "syncing"    // catching up to the chain tip
+	SyncModeRescanning SyncMode = "rescanning" // forced rescan, e.g. via /rescan
+	SyncModeHalted     SyncMode = "halted"     // the oracle servers disagreed, see Daemon.halt
+)
+
+// ConnectionStatus is derived from the calls the daemon makes anyway, nothing is probed for it
+type ConnectionStatus struct {
+	Connected bool   `json:"connected"`
+	LastSeen  int64  `json:"last_seen,omitempty"` // last successful call
+	Err       string `json:"err,omitempty"`       // error of the last call if it failed
+}
+
+type SyncErrorStatus struct {
+	Err  string `json:"err"`
+	Time int64  `json:"time"`
+}
+
+// Status describes what the daemon is doing and how far it is behind the chain tip
+type Status struct {
+	Mode            SyncMode          `json:"mode"`
+	ScanHeight      uint64            `json:"scan_height"`
+	ChainTip        uint64            `json:"chain_tip"` // last known chain tip of the backend
+	BlocksBehind    uint64            `json:"blocks_behind"`
+	TargetHeight    uint64            `json:"target_height,omitempty"`     // height the current sync or rescan goes up to
+	BlocksPerSecond float64           `json:"blocks_per_second,omitempty"` // of the current sync or rescan
+	ETASeconds      int64             `json:"eta_seconds,omitempty"`
+	LastSync        int64             `json:"last_sync,omitempty"` // end of the last successful sync or rescan
+	LastError       *SyncErrorStatus  `json:"last_error,omitempty"`
+	Oracle          ConnectionStatus  `json:"oracle"`
+	Electrum        *ConnectionStatus `json:"electrum,omitempty"` // only if electrum is used
+}
+
+// syncStatus is updated by the scan loop and read by the servers
+type syncStatus struct {
+	mu sync.Mutex
+
+	mode         SyncMode
+	rangeStart   uint64
+	targetHeight uint64
+	rangeStarted time.Time
+	committed    uint64 // heights committed in the current range
+	chainTip     uint64
+	lastSync     time.Time
+	lastError    *SyncErrorStatus
+	haltErr      error // set once scanning halted, the daemon has to be restarted
+	oracle       ConnectionStatus
+	electrum     ConnectionStatus
+}
+
+func (s *syncStatus) start(mode SyncMode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mode = mode
+	s.targetHeight = 0
+	s.committed = 0
+}
+
+func (s *syncStatus) finish(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.haltErr == nil {
+		s.mode = SyncModeIdle
+	}
+	s.targetHeight = 0
+	if err != nil {
+		s.lastError = &SyncErrorStatus{Err: err.Error(), Time: time.Now().Unix()}
+		return
+	}
+	s.lastSync = time.Now()
+}
+
+func (s *syncStatus) startRange(startHeight, endHeight uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.rangeStart = startHeight
+	s.targetHeight = endHeight
+	s.rangeStarted = time.Now()
+	s.committed = 0
+	s.chainTip = max(s.chainTip, endHeight)
+}
+
+func (s *syncStatus) halt(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.mode = SyncModeHalted
+	s.haltErr = err
+}
+
+func (s *syncStatus) halted() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.haltErr
+}
+
+func (s *syncStatus) blockCommitted() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.committed++
+}
+
+func (s *syncStatus) recordChainTip(chainTip uint64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	// the tip can go down in a reorg
+	s.chainTip = chainTip
+}
+
+func (s *syncStatus) recordOracle(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recordConnection(&s.oracle, err)
+}
+
+func (s *syncStatus) recordElectrum(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	recordConnection(&s.electrum, err)
+}
+
+func recordConnection(status *ConnectionStatus, err error) {
+	status.Connected = err == nil
+	if err != nil {
+		status.Err = err.Error()
+		return
+	}
+	status.Err = ""
+	status.LastSeen = time.Now().Unix()
+}
+
+// Status returns a snapshot of the current sync status
+func (d *Daemon) Status() Status {
+	var scanHeight uint64
+	d.ReadWallet(func(w *wallet.Wallet) {
+		if w != nil {
+			scanHeight = w.LastScanHeight
+		}
+	})
+
+	s := &d.status
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status := Status{
+		Mode:       s.mode,
+		ScanHeight: scanHeight,
+		ChainTip:   s.chainTip,
+		LastError:  s.lastError,
+		Oracle:     s.oracle,
+	}
+	if status.Mode == "" {
+		status.Mode = SyncModeIdle
+	}
+	if s.chainTip > scanHeight {
+		status.BlocksBehind = s.chainTip - scanHeight
+	}
+	if !s.lastSync.IsZero() {
+		status.LastSync = s.lastSync.Unix()
+	}
+	if config.UseElectrum {
+		electrum := s.electrum
+		if d.ClientElectrum != nil && d.ClientElectrum.IsShutdown() {
+			electrum.Connected = false
+		}
+		status.Electrum = &electrum
+	}
+
+	if (status.Mode == SyncModeSyncing || status.Mode == SyncModeRescanning) && s.targetHeight > 0 {
+		status.TargetHeight = s.targetHeight
+		elapsed := time.Since(s.rangeStarted).Seconds()
+		if s.committed > 0 && elapsed > 0 {
+			status.BlocksPerSecond = float64(s.committed) / elapsed
+			current := s.rangeStart + s.committed - 1
+			if s.targetHeight > current {
+				status.ETASeconds = int64(float64(s.targetHeight-current) / status.BlocksPerSecond)
+			}
+		}
+	}
+
+	return status
+}
+
+// getChainTip fetches the chain tip from the backend and records the result for the status
+func (d *Daemon) getChainTip() (uint64, error) {
+	chainTip, err := d.Backend.GetChainTip()
+	d.status.recordOracle(err)
+	if err != nil {
+		return 0, err
+	}
+	d.status.recordChainTip(chainTip)
+	return chainTip, nil
+}
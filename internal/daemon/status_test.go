@@ -0,0 +1,91 @@
+package daemon
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/setavenger/blindbit-scan/pkg/networking"
+)
+
+func TestStatusAfterSync(t *testing.T) {
+	backend := networking.NewFixtureBackend()
+	backend.SetChainTip(8)
+	d := newTestDaemon(t, backend)
+
+	if status := d.Status(); status.Mode != SyncModeIdle || status.LastSync != 0 {
+		t.Errorf("expected an idle daemon which never synced, got %+v", status)
+	}
+
+	if err := d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+	status := d.Status()
+	if status.Mode != SyncModeIdle || status.ScanHeight != 8 || status.ChainTip != 8 || status.BlocksBehind != 0 {
+		t.Errorf("unexpected status after sync %+v", status)
+	}
+	if status.LastSync == 0 || status.LastError != nil || !status.Oracle.Connected {
+		t.Errorf("expected a successful sync, got %+v", status)
+	}
+	if status.Electrum != nil {
+		t.Errorf("expected no electrum status without electrum")
+	}
+
+	// the backend does not serve heights above its tip
+	if err := d.SyncToTip(12); err == nil {
+		t.Fatal("expected the sync to fail")
+	}
+	status = d.Status()
+	if status.Mode != SyncModeIdle || status.LastError == nil || status.LastError.Time < status.LastSync {
+		t.Errorf("expected the error to be recorded, got %+v", status)
+	}
+	if status.ChainTip != 12 || status.BlocksBehind != 4 {
+		t.Errorf("expected to be 4 blocks behind 12, got %+v", status)
+	}
+}
+
+func TestHaltOnOracleDisagreement(t *testing.T) {
+	honest := networking.NewFixtureBackend()
+	lying := networking.NewFixtureBackend()
+	backend := networking.NewMultiBackend([]networking.OracleServer{
+		{Name: "honest", Backend: honest},
+		{Name: "lying", Backend: lying},
+	}, true)
+	backend.RecheckDelay = time.Millisecond
+	d := newTestDaemon(t, backend)
+
+	// the lying server omits the tweak of the payment
+	block := setPaymentBlock(t, honest, d.Wallet, nil)
+	lying.SetBlock(5, &networking.FixtureBlock{BlockHash: block.BlockHash, UTXOs: block.UTXOs})
+	lying.SetChainTip(8)
+
+	events, unsubscribe := d.Events.Subscribe(100)
+	defer unsubscribe()
+
+	err := d.SyncToTip(0)
+	if !errors.Is(err, networking.ErrOracleDisagreement) {
+		t.Fatalf("expected a disagreement, got %v", err)
+	}
+	if status := d.Status(); status.Mode != SyncModeHalted || status.ScanHeight != 4 {
+		t.Errorf("expected the scan to halt before height 5, got %+v", status)
+	}
+
+	var alerted bool
+	for len(events) > 0 {
+		if event := <-events; event.Type == EventOracleDisagreement {
+			alerted = true
+		}
+	}
+	if !alerted {
+		t.Error("expected an oracle_disagreement event")
+	}
+
+	// nothing is scanned anymore, even if the servers agree again
+	lying.SetBlock(5, block)
+	if err = d.SyncToTip(0); !errors.Is(err, networking.ErrOracleDisagreement) {
+		t.Errorf("expected the daemon to stay halted, got %v", err)
+	}
+	if err = d.ForceSyncFrom(RescanRequest{Height: 1}); !errors.Is(err, networking.ErrOracleDisagreement) {
+		t.Errorf("expected rescans to be refused, got %v", err)
+	}
+}
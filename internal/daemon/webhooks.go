@@ -0,0 +1,66 @@
+package daemon
+
+import (
+	"encoding/hex"
+
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+	"github.com/setavenger/blindbit-scan/pkg/webhook"
+)
+
+// newPayments returns the utxos which are not known to the wallet yet or were only seen in the mempool.
+// Rescans find known utxos again, those must not trigger webhooks a second time.
+func (d *Daemon) newPayments(utxos []*wallet.OwnedUTXO) []*wallet.OwnedUTXO {
+	if d.Webhooks == nil {
+		return nil
+	}
+	var payments []*wallet.OwnedUTXO
+	for _, utxo := range utxos {
+		key, err := utxo.GetKey()
+		if err != nil {
+			continue
+		}
+		if existing := d.Wallet.GetUTXO(key); existing == nil || existing.State == wallet.StateUnconfirmed {
+			payments = append(payments, utxo)
+		}
+	}
+	return payments
+}
+
+func (d *Daemon) notifyWebhooks(payments []*wallet.OwnedUTXO, chainTip uint64) error {
+	if len(payments) == 0 {
+		return nil
+	}
+
+	defaultAddress, err := d.Wallet.GenerateAddress()
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	for _, utxo := range payments {
+		payment := webhook.Payment{
+			Txid:        hex.EncodeToString(utxo.Txid[:]),
+			Vout:        utxo.Vout,
+			Amount:      utxo.Amount,
+			Address:     defaultAddress,
+			BlockHeight: utxo.BlockHeight,
+			BlockHash:   hex.EncodeToString(utxo.BlockHash[:]),
+		}
+		if utxo.Label != nil {
+			m := utxo.Label.M
+			payment.Label = &m
+			payment.Address = utxo.Label.Address
+		}
+		if chainTip >= utxo.BlockHeight {
+			payment.Confirmations = chainTip - utxo.BlockHeight + 1
+		}
+
+		err = d.Webhooks.Enqueue(payment)
+		if err != nil {
+			logging.L.Err(err).Msg("")
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package daemon
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/pkg/networking"
+	"github.com/setavenger/blindbit-scan/pkg/webhook"
+)
+
+func TestWebhooksOnlyForNewPayments(t *testing.T) {
+	received := make(chan webhook.Payload, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhook.Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		received <- payload
+	}))
+	defer receiver.Close()
+
+	backend := networking.NewFixtureBackend()
+	d := newTestDaemon(t, backend)
+
+	var err error
+	d.Webhooks, err = webhook.NewDispatcher(
+		[]config.WebhookEndpoint{{URL: receiver.URL, Secret: "secret"}},
+		t.TempDir()+"/webhooks", 5,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Webhooks.Run(ctx)
+		close(done)
+	}()
+	// the queue is written until Run returns
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	var labelM uint32 = 1
+	setPaymentBlock(t, backend, d.Wallet, &labelM)
+
+	if err = d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-received:
+		payment := payload.Payment
+		if payment.Amount != 10_000 || payment.BlockHeight != 5 || payment.Confirmations != 4 {
+			t.Errorf("unexpected payment %+v", payment)
+		}
+		if payment.Label == nil || *payment.Label != labelM || payment.Address != d.Wallet.GetLabel(labelM).Address {
+			t.Errorf("expected the payment to be received on label %d", labelM)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+
+	// a rescan finds the same output again
+	if err = d.ForceSyncFrom(RescanRequest{Height: 1}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case payload := <-received:
+		t.Errorf("unexpected second delivery %s", payload.ID)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestWebhookQueuedAfterFailedWrite(t *testing.T) {
+	received := make(chan webhook.Payload, 10)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var payload webhook.Payload
+		if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		received <- payload
+	}))
+	defer receiver.Close()
+
+	backend := networking.NewFixtureBackend()
+	d := newTestDaemon(t, backend)
+	setPaymentBlock(t, backend, d.Wallet, nil)
+
+	dir := t.TempDir() + "/queue"
+	endpoints := []config.WebhookEndpoint{{URL: receiver.URL, Secret: "secret"}}
+	var err error
+	d.Webhooks, err = webhook.NewDispatcher(endpoints, dir+"/webhooks", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the queue can't be written while its directory is a file
+	if err = os.WriteFile(dir, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = d.SyncToTip(0); err == nil {
+		t.Fatal("expected the sync to fail")
+	}
+	if d.Wallet.LastScanHeight != 4 || len(d.Wallet.UTXOs) != 0 {
+		t.Fatalf("expected block 5 to be left out, got height %d and %d utxos", d.Wallet.LastScanHeight, len(d.Wallet.UTXOs))
+	}
+
+	if err = os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Mkdir(dir, 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.SyncToTip(0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the payment has to be in the stored queue, a restarted dispatcher delivers it
+	restarted, err := webhook.NewDispatcher(endpoints, dir+"/webhooks", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		restarted.Run(ctx)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	select {
+	case payload := <-received:
+		if payload.Payment.Amount != 10_000 || payload.Payment.BlockHeight != 5 {
+			t.Errorf("unexpected payment %+v", payload.Payment)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+}
@@ -3,6 +3,7 @@ package nwcserver
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 
 	"github.com/setavenger/blindbit-scan/internal/config"
 	"github.com/setavenger/blindbit-scan/internal/daemon"
@@ -21,12 +22,17 @@ func NewNwcServer(d *daemon.Daemon) *NwcServer {
 
 func (s *NwcServer) GetInfoHandler() nwc.Nip47ControllerHandlerFunc {
 	return func(ctx context.Context, nr nwc.Nip47Request) (data []byte, err error) {
+		var height uint64
+		s.Daemon.ReadWallet(func(w *wallet.Wallet) {
+			height = w.LastScanHeight
+		})
 		rawData := nwc.GetInfoResponseBody{
 			Alias:       "", //todo: is this relevant?
 			PubKey:      "", // todo: find a way to pass this along. maybe via the, currently unsused, context
 			Network:     config.ChainParams.Name,
-			BlockHeight: int(s.Daemon.Wallet.LastScanHeight),
-			Methods:     []string{"get_info", "get_balance", "list_utxos"},
+			BlockHeight: int(height),
+			Methods:     []string{"get_info", "get_balance", "list_utxos", "list_transactions"},
+			Status:      s.Daemon.Status(),
 		}
 		var resultData []byte
 		resultData, err = json.Marshal(rawData)
@@ -51,8 +57,12 @@ func (s *NwcServer) GetInfoHandler() nwc.Nip47ControllerHandlerFunc {
 
 func (s *NwcServer) GetBalanceHandler() nwc.Nip47ControllerHandlerFunc {
 	return func(ctx context.Context, nr nwc.Nip47Request) (data []byte, err error) {
+		var balance uint64
+		s.Daemon.ReadWallet(func(w *wallet.Wallet) {
+			balance = w.FreeBalance()
+		})
 		rawData := nwc.GetBalanceResponseBody{
-			Balance: int64(s.Daemon.Wallet.FreeBalance()) * 1000, // muliply by 1000 nwc is in mSats
+			Balance: int64(balance) * 1000, // muliply by 1000 nwc is in mSats
 		}
 		var resultData []byte
 		resultData, err = json.Marshal(rawData)
@@ -77,10 +87,37 @@ func (s *NwcServer) GetBalanceHandler() nwc.Nip47ControllerHandlerFunc {
 
 func (s *NwcServer) ListUtxosHandler() nwc.Nip47ControllerHandlerFunc {
 	return func(ctx context.Context, nr nwc.Nip47Request) (data []byte, err error) {
-		utxos := make(wallet.UtxoCollection, len(s.Daemon.Wallet.UTXOs))
-		copy(utxos, s.Daemon.Wallet.UTXOs)
+		var params nwc.ListUtxosRequestBody
+		if len(nr.Params) > 0 {
+			err = json.Unmarshal(nr.Params, &params)
+			if err != nil {
+				logging.L.Err(err).Msg("could not decode params")
+				return
+			}
+		}
+
+		// tweaks are only handed out to apps which were granted spend data
+		app := nwc.AppFromContext(ctx)
+		params.IncludeTweaks = app != nil && app.HasPermission(nwc.PermissionSpendData)
+
+		var page *wallet.UTXOPage
+		s.Daemon.ReadWallet(func(w *wallet.Wallet) {
+			page, err = w.QueryUTXOs(params)
+		})
+		if err != nil {
+			logging.L.Err(err).Msg("")
+			return
+		}
+		if params.IncludeTweaks {
+			logging.L.Warn().
+				Str("via", "nwc").
+				Str("app", app.ClientPub).
+				Int("utxos", len(page.UTXOs)).
+				Msg("disclosed private key tweaks")
+		}
 		rawData := nwc.ListUtxosResponseBody{
-			Utxos: utxos,
+			Utxos:      page.UTXOs,
+			NextCursor: page.NextCursor,
 		}
 		var resultData []byte
 		resultData, err = json.Marshal(rawData)
@@ -102,3 +139,70 @@ func (s *NwcServer) ListUtxosHandler() nwc.Nip47ControllerHandlerFunc {
 		return
 	}
 }
+
+func (s *NwcServer) ListTransactionsHandler() nwc.Nip47ControllerHandlerFunc {
+	return func(ctx context.Context, nr nwc.Nip47Request) (data []byte, err error) {
+		var params nwc.ListTransactionsRequestBody
+		if len(nr.Params) > 0 {
+			err = json.Unmarshal(nr.Params, &params)
+			if err != nil {
+				logging.L.Err(err).Msg("could not decode params")
+				return
+			}
+		}
+		switch wallet.TxDirection(params.Type) {
+		case "", wallet.TxIncoming, wallet.TxOutgoing:
+		default:
+			err = fmt.Errorf("invalid type: (%s)", params.Type)
+			logging.L.Err(err).Msg("")
+			return
+		}
+
+		var txs []*wallet.Transaction
+		s.Daemon.ReadWallet(func(w *wallet.Wallet) {
+			txs, _ = w.ListTransactions(wallet.TxFilter{
+				Direction: wallet.TxDirection(params.Type),
+				From:      params.From,
+				Until:     params.Until,
+				Offset:    params.Offset,
+				Limit:     params.Limit,
+			})
+		})
+
+		rawData := nwc.ListTransactionsResponseBody{
+			Transactions: make([]nwc.Transaction, 0, len(txs)),
+		}
+		for _, tx := range txs {
+			nwcTx := nwc.Transaction{
+				Type:      string(tx.Direction),
+				Amount:    int64(tx.Amount) * 1000, // nwc is in mSats
+				CreatedAt: int64(tx.Timestamp),
+				Metadata:  tx,
+			}
+			if tx.Confirmed {
+				settledAt := int64(tx.Timestamp)
+				nwcTx.SettledAt = &settledAt
+			}
+			rawData.Transactions = append(rawData.Transactions, nwcTx)
+		}
+
+		var resultData []byte
+		resultData, err = json.Marshal(rawData)
+		if err != nil {
+			logging.L.Err(err).Msg("could not marshal raw data")
+			return
+		}
+		resp := nwc.Nip47Response{
+			ResultType: nwc.LIST_TRANSACTIONS_METHOD,
+			Error:      nwc.ErrorBody{},
+			Result:     resultData,
+		}
+
+		data, err = json.Marshal(resp)
+		if err != nil {
+			logging.L.Err(err).Msg("could not marshal Nip47Response")
+			return
+		}
+		return
+	}
+}
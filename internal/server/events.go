@@ -0,0 +1,51 @@
+package server
+
+import (
+	"io"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/setavenger/blindbit-scan/internal/daemon"
+)
+
+// keepAliveInterval keeps proxies from closing idle event streams
+const keepAliveInterval = 15 * time.Second
+
+// GetEvents streams the events of the daemon as server-sent events.
+// The optional query param types (comma separated or repeated) only streams the given event types.
+func (s *Server) GetEvents(c *gin.Context) {
+	types := make(map[daemon.EventType]struct{})
+	for _, value := range splitQueryList(c.QueryArray("types")) {
+		types[daemon.EventType(value)] = struct{}{}
+	}
+
+	events, unsubscribe := s.Daemon.Events.Subscribe(64)
+	defer unsubscribe()
+
+	keepAlive := time.NewTicker(keepAliveInterval)
+	defer keepAlive.Stop()
+
+	c.Header("Cache-Control", "no-cache")
+	c.Header("X-Accel-Buffering", "no")
+
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case <-c.Request.Context().Done():
+			return false
+		case event, ok := <-events:
+			if !ok {
+				return false
+			}
+			if _, ok = types[event.Type]; len(types) > 0 && !ok {
+				return true
+			}
+			c.SSEvent(string(event.Type), event)
+		case <-keepAlive.C:
+			_, err := io.WriteString(w, ": keep-alive\n\n")
+			if err != nil {
+				return false
+			}
+		}
+		return true
+	})
+}
@@ -3,29 +3,222 @@ package server
 import (
 	"bytes"
 	"encoding/hex"
+	"errors"
 	"fmt"
 	"net/http"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/internal/daemon"
 	"github.com/setavenger/blindbit-scan/pkg/database"
 	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/networking/nwc"
+	"github.com/setavenger/blindbit-scan/pkg/utils"
 	"github.com/setavenger/blindbit-scan/pkg/wallet"
 	"github.com/setavenger/go-bip352"
 	"github.com/spf13/viper"
 )
 
 func (s *Server) GetCurrentHeight(c *gin.Context) {
-	c.JSON(http.StatusOK, gin.H{"height": s.Daemon.Wallet.LastScanHeight})
+	var height uint64
+	s.Daemon.ReadWallet(func(w *wallet.Wallet) {
+		height = w.LastScanHeight
+	})
+	c.JSON(http.StatusOK, gin.H{"height": height})
 }
 
+// GetStatus returns what the daemon is doing, how far behind the tip it is and whether the last sync failed
+func (s *Server) GetStatus(c *gin.Context) {
+	c.JSON(http.StatusOK, s.Daemon.Status())
+}
+
+// GetUtxos returns the utxos matching the query params, see parseUTXOQuery.
+// The body stays a plain array, the cursor for the next page is passed in the X-Next-Cursor header.
+// The private key and label tweaks are left out, see GetUtxosSpendData.
 func (s *Server) GetUtxos(c *gin.Context) {
-	utxos := s.Daemon.Wallet.UTXOs
-	if utxos == nil {
-		utxos = []*wallet.OwnedUTXO{}
+	s.getUtxos(c, false)
+}
+
+// GetUtxosSpendData is GetUtxos including the tweaks needed to spend the utxos.
+// Every call is logged as a disclosure.
+func (s *Server) GetUtxosSpendData(c *gin.Context) {
+	s.getUtxos(c, true)
+}
+
+func (s *Server) getUtxos(c *gin.Context, includeTweaks bool) {
+	query, err := parseUTXOQuery(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+	query.IncludeTweaks = includeTweaks
+
+	var page *wallet.UTXOPage
+	s.Daemon.ReadWallet(func(w *wallet.Wallet) {
+		page, err = w.QueryUTXOs(query)
+	})
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+
+	if includeTweaks {
+		logging.L.Warn().
+			Str("via", "http").
+			Str("user", c.GetString(gin.AuthUserKey)).
+			Str("remote", c.ClientIP()).
+			Int("utxos", len(page.UTXOs)).
+			Msg("disclosed private key tweaks")
+	}
+
+	if page.NextCursor != "" {
+		c.Header("X-Next-Cursor", page.NextCursor)
+	}
+	c.JSON(http.StatusOK, page.UTXOs)
+}
+
+// parseUTXOQuery reads the query params of GET /utxos.
+// Lists (utxo_state, label) can be comma separated or repeated, label=none selects unlabeled utxos.
+func parseUTXOQuery(c *gin.Context) (wallet.UTXOQuery, error) {
+	query := wallet.UTXOQuery{
+		Txid:   c.Query("txid"),
+		Sort:   c.Query("sort"),
+		Order:  c.Query("order"),
+		Cursor: c.Query("cursor"),
+	}
+
+	for _, value := range splitQueryList(c.QueryArray("utxo_state")) {
+		state, err := wallet.ParseUTXOState(value)
+		if err != nil {
+			return query, err
+		}
+		query.States = append(query.States, state)
+	}
+
+	for _, value := range splitQueryList(c.QueryArray("label")) {
+		if value == "none" {
+			query.Unlabeled = true
+			continue
+		}
+		m, err := strconv.ParseUint(value, 10, 32)
+		if err != nil {
+			return query, fmt.Errorf("invalid label: (%s)", value)
+		}
+		query.Labels = append(query.Labels, uint32(m))
+	}
+
+	uintParams := map[string]*uint64{
+		"min_amount": &query.MinAmount,
+		"max_amount": &query.MaxAmount,
+		"min_height": &query.MinHeight,
+		"max_height": &query.MaxHeight,
+		"from":       &query.From,
+		"until":      &query.Until,
+	}
+	for param, target := range uintParams {
+		if value := c.Query(param); value != "" {
+			var err error
+			*target, err = strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				return query, fmt.Errorf("invalid %s: (%s)", param, value)
+			}
+		}
+	}
+
+	if value := c.Query("limit"); value != "" {
+		var err error
+		query.Limit, err = strconv.Atoi(value)
+		if err != nil || query.Limit < 0 {
+			return query, fmt.Errorf("invalid limit: (%s)", value)
+		}
+	}
+
+	return query, nil
+}
+
+func splitQueryList(values []string) []string {
+	var items []string
+	for _, value := range values {
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
 	}
-	c.JSON(http.StatusOK, utxos)
+	return items
+}
+
+// GetBalance returns the balance per state, split into unlabeled outputs and outputs per label
+func (s *Server) GetBalance(c *gin.Context) {
+	var balance *wallet.BalanceBreakdown
+	s.Daemon.ReadWallet(func(w *wallet.Wallet) {
+		balance = w.GetBalanceBreakdown()
+	})
+	c.JSON(http.StatusOK, balance)
+}
+
+type TransactionsResp struct {
+	Total        int                   `json:"total"`
+	Offset       int                   `json:"offset"`
+	Limit        int                   `json:"limit"`
+	Transactions []*wallet.Transaction `json:"transactions"`
+}
+
+// GetTransactions returns a page of the transaction history, newest first.
+// Query params: type (incoming, outgoing), from and until (unix timestamps), offset and limit (default 50)
+func (s *Server) GetTransactions(c *gin.Context) {
+	filter := wallet.TxFilter{
+		Direction: wallet.TxDirection(c.Query("type")),
+		Limit:     50,
+	}
+	switch filter.Direction {
+	case "", wallet.TxIncoming, wallet.TxOutgoing:
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"err": fmt.Sprintf("invalid type: (%s)", filter.Direction)})
+		c.Abort()
+		return
+	}
+
+	var err error
+	for param, target := range map[string]*uint64{"from": &filter.From, "until": &filter.Until} {
+		if value := c.Query(param); value != "" {
+			*target, err = strconv.ParseUint(value, 10, 64)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"err": fmt.Sprintf("invalid %s: %s", param, err)})
+				c.Abort()
+				return
+			}
+		}
+	}
+	for param, target := range map[string]*int{"offset": &filter.Offset, "limit": &filter.Limit} {
+		if value := c.Query(param); value != "" {
+			*target, err = strconv.Atoi(value)
+			if err != nil || *target < 0 {
+				c.JSON(http.StatusBadRequest, gin.H{"err": fmt.Sprintf("invalid %s: (%s)", param, value)})
+				c.Abort()
+				return
+			}
+		}
+	}
+
+	var txs []*wallet.Transaction
+	var total int
+	s.Daemon.ReadWallet(func(w *wallet.Wallet) {
+		txs, total = w.ListTransactions(filter)
+	})
+	c.JSON(http.StatusOK, TransactionsResp{
+		Total:        total,
+		Offset:       filter.Offset,
+		Limit:        filter.Limit,
+		Transactions: txs,
+	})
 }
 
 func (s *Server) GetAddress(c *gin.Context) {
@@ -39,7 +232,8 @@ func (s *Server) GetAddress(c *gin.Context) {
 }
 
 type RescanReq struct {
-	Height uint64 `json:"height"`
+	Height     uint64 `json:"height"`
+	TweakIndex *bool  `json:"tweak_index,omitempty"` // defaults to the scan.tweak_index setting
 }
 
 func (s *Server) PostRescan(c *gin.Context) {
@@ -58,8 +252,22 @@ func (s *Server) PostRescan(c *gin.Context) {
 		return
 	}
 
-	s.Daemon.TriggerRescanChan <- requestBody.Height
-	c.JSON(http.StatusOK, gin.H{"height": requestBody.Height})
+	if err = s.Daemon.Halted(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+
+	rescanReq := daemon.RescanRequest{
+		Height:     requestBody.Height,
+		TweakIndex: config.UseTweakIndex,
+	}
+	if requestBody.TweakIndex != nil {
+		rescanReq.TweakIndex = *requestBody.TweakIndex
+	}
+
+	s.Daemon.RequestRescan(rescanReq)
+	c.JSON(http.StatusAccepted, gin.H{"height": rescanReq.Height, "tweak_index": rescanReq.TweakIndex})
 }
 
 type SetupReq struct {
@@ -88,7 +296,12 @@ func (s *Server) PutSilentPaymentKeys(c *gin.Context) {
 		c.Abort()
 		return
 	}
-	viper.Set("wallet.scan_secret_key", keys.ScanSecret)
+	if config.StorageEncrypt {
+		// the scan secret is only kept in the encrypted wallet, also clears one written before encryption was enabled
+		viper.Set("wallet.scan_secret_key", "")
+	} else {
+		viper.Set("wallet.scan_secret_key", keys.ScanSecret)
+	}
 
 	spendPub, err := hex.DecodeString(keys.SpendPublic)
 	if err != nil {
@@ -115,12 +328,20 @@ func (s *Server) PutSilentPaymentKeys(c *gin.Context) {
 		c.Abort()
 		return
 	}
+	err = os.Chmod(config.PathConfig, 0600)
+	if err != nil {
+		logging.L.Warn().Err(err).Msg("could not restrict permissions of the config file")
+	}
 
-	go func() {
-		if s.Daemon.Wallet == nil || bytes.Equal(s.Daemon.Wallet.SecretKeyScan[:], make([]byte, 32)) || bytes.Equal(s.Daemon.Wallet.PubKeySpend[:], make([]byte, 33)) {
+	var waitingForKeys bool
+	s.Daemon.ReadWallet(func(w *wallet.Wallet) {
+		waitingForKeys = w == nil || bytes.Equal(w.SecretKeyScan[:], make([]byte, 32)) || bytes.Equal(w.PubKeySpend[:], make([]byte, 33))
+	})
+	if waitingForKeys {
+		go func() {
 			config.KeysReadyChan <- struct{}{}
-		}
-	}()
+		}()
+	}
 
 	var newWallet *wallet.Wallet
 
@@ -134,15 +355,13 @@ func (s *Server) PutSilentPaymentKeys(c *gin.Context) {
 	}
 
 	// reset system
-	err = s.Daemon.ResetDaemonAndWallet()
+	err = s.Daemon.ResetDaemonAndWallet(newWallet)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"err": err.Error()})
 		c.Abort()
 		return
 	}
 
-	s.Daemon.Wallet = newWallet
-
 	// logging.L.Debug().Any("wallet", s.Daemon.Wallet).Msg("")
 
 	go func() {
@@ -165,8 +384,34 @@ func (s *Server) PutSilentPaymentKeys(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{"address": address})
 }
 
+type NwcConnectionReq struct {
+	Permissions []string `json:"permissions"` // e.g. spend_data, read only if empty
+}
+
+// NewNwcConnection creates a new nwc app, the body with permissions is optional
 func (s *Server) NewNwcConnection(c *gin.Context) {
-	nwcURI, err := s.Nip47Controller.NewConnectionUri()
+	var requestBody NwcConnectionReq
+	if c.Request.ContentLength != 0 {
+		err := c.ShouldBindJSON(&requestBody)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+			c.Abort()
+			return
+		}
+	}
+	err := nwc.ValidatePermissions(requestBody.Permissions)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+	if slices.Contains(requestBody.Permissions, nwc.PermissionSpendData) && !spendAuthorised(c) {
+		c.JSON(http.StatusForbidden, gin.H{"err": "granting spend_data needs the spend credentials"})
+		c.Abort()
+		return
+	}
+
+	nwcURI, err := s.Nip47Controller.NewConnectionUri(requestBody.Permissions...)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"err": err.Error()})
 		c.Abort()
@@ -182,3 +427,156 @@ func (s *Server) NewNwcConnection(c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"uri": nwcURI})
 }
+
+type LabelResp struct {
+	M       uint32 `json:"m"`
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+	PubKey  string `json:"pub_key"`
+	Change  bool   `json:"change,omitempty"` // m = 0 is reserved for change and should not be handed out
+}
+
+func newLabelResp(w *wallet.Wallet, label *bip352.Label) LabelResp {
+	return LabelResp{
+		M:       label.M,
+		Name:    w.LabelName(label.M),
+		Address: label.Address,
+		PubKey:  hex.EncodeToString(label.PubKey[:]),
+		Change:  label.M == 0,
+	}
+}
+
+func (s *Server) GetLabels(c *gin.Context) {
+	labels := s.Daemon.Wallet.GetLabels()
+	resp := make([]LabelResp, 0, len(labels))
+	for _, label := range labels {
+		resp = append(resp, newLabelResp(s.Daemon.Wallet, label))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+type NewLabelReq struct {
+	Name   string `json:"name"`
+	Rescan bool   `json:"rescan"` // directly trigger a targeted rescan of the new label from birth height
+}
+
+func (s *Server) PostLabel(c *gin.Context) {
+	var requestBody NewLabelReq
+	err := c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+
+	if requestBody.Rescan {
+		if err = s.Daemon.Halted(); err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"err": err.Error()})
+			c.Abort()
+			return
+		}
+	}
+
+	label, err := s.Daemon.AddLabel(requestBody.Name)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+
+	status := http.StatusOK
+	if requestBody.Rescan {
+		s.Daemon.RequestRescan(daemon.RescanRequest{
+			Height:     s.Daemon.Wallet.BirthHeight,
+			TweakIndex: config.UseTweakIndex,
+			Labels:     []*bip352.Label{label},
+		})
+		status = http.StatusAccepted
+	}
+
+	c.JSON(status, newLabelResp(s.Daemon.Wallet, label))
+}
+
+type LabelNameReq struct {
+	Name string `json:"name"`
+}
+
+func (s *Server) PutLabelName(c *gin.Context) {
+	m, err := strconv.ParseUint(c.Param("m"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+
+	var requestBody LabelNameReq
+	err = c.ShouldBindJSON(&requestBody)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+
+	err = s.Daemon.SetLabelName(uint32(m), requestBody.Name)
+	if errors.Is(err, utils.ErrLabelNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+
+	c.JSON(http.StatusOK, newLabelResp(s.Daemon.Wallet, s.Daemon.Wallet.GetLabel(uint32(m))))
+}
+
+// PostLabelRescan rescans only for the label m, by default from birth height
+func (s *Server) PostLabelRescan(c *gin.Context) {
+	m, err := strconv.ParseUint(c.Param("m"), 10, 32)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+
+	label := s.Daemon.Wallet.GetLabel(uint32(m))
+	if label == nil {
+		c.JSON(http.StatusNotFound, gin.H{"err": utils.ErrLabelNotFound.Error()})
+		c.Abort()
+		return
+	}
+
+	if err = s.Daemon.Halted(); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"err": err.Error()})
+		c.Abort()
+		return
+	}
+
+	// the body is optional
+	var requestBody RescanReq
+	if c.Request.ContentLength != 0 {
+		err = c.ShouldBindJSON(&requestBody)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"err": err.Error()})
+			c.Abort()
+			return
+		}
+	}
+
+	rescanReq := daemon.RescanRequest{
+		Height:     requestBody.Height,
+		TweakIndex: config.UseTweakIndex,
+		Labels:     []*bip352.Label{label},
+	}
+	if rescanReq.Height < 1 {
+		rescanReq.Height = s.Daemon.Wallet.BirthHeight
+	}
+	if requestBody.TweakIndex != nil {
+		rescanReq.TweakIndex = *requestBody.TweakIndex
+	}
+
+	s.Daemon.RequestRescan(rescanReq)
+	c.JSON(http.StatusAccepted, gin.H{"m": label.M, "height": rescanReq.Height, "tweak_index": rescanReq.TweakIndex})
+}
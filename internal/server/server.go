@@ -8,6 +8,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/setavenger/blindbit-scan/internal/config"
 	"github.com/setavenger/blindbit-scan/internal/daemon"
+	"github.com/setavenger/blindbit-scan/pkg/metrics"
 	"github.com/setavenger/blindbit-scan/pkg/networking/nwc"
 )
 
@@ -35,17 +36,28 @@ func (s *Server) RunServer() error {
 
 	router.Use(cors.New(cors.Config{
 		AllowOrigins:     []string{"*"},
-		AllowMethods:     []string{"GET", "PUT"},
+		AllowMethods:     []string{"GET", "PUT", "POST"},
 		AllowHeaders:     []string{"Content-Type", "Authorization"},
 		AllowCredentials: true,
 	}))
 
-	router.Use(gin.BasicAuth(gin.Accounts{
+	accounts := gin.Accounts{
 		config.AuthUser: config.AuthPass,
-	}))
+	}
+	if config.AuthSpendUser != "" {
+		accounts[config.AuthSpendUser] = config.AuthSpendPass
+	}
+	router.Use(gin.BasicAuth(accounts))
 
 	router.PUT("/new-keys", s.PutSilentPaymentKeys)
 
+	// values of the wallet are only collected once it is set up
+	if err := metrics.Registry.Register(daemon.NewMetricsCollector(s.Daemon)); err != nil {
+		slog.Error(err.Error())
+		return err
+	}
+	router.GET("/metrics", gin.WrapH(metrics.Handler()))
+
 	// BlindBit adaptation of Nostr Wallet Connect
 	router.POST("/new-nwc-connection", s.NewNwcConnection)
 
@@ -61,14 +73,37 @@ func (s *Server) RunServer() error {
 	})
 
 	walletReadyGroup.GET("/height", s.GetCurrentHeight)
+	walletReadyGroup.GET("/status", s.GetStatus)
 	walletReadyGroup.GET("/utxos", s.GetUtxos)
+	walletReadyGroup.GET("/utxos/spend-data", requireSpendAuth, s.GetUtxosSpendData)
+	walletReadyGroup.GET("/balance", s.GetBalance)
+	walletReadyGroup.GET("/transactions", s.GetTransactions)
 	walletReadyGroup.GET("/address", s.GetAddress)
+	walletReadyGroup.GET("/events", s.GetEvents)
 
 	walletReadyGroup.POST("/rescan", s.PostRescan)
 
+	walletReadyGroup.GET("/labels", s.GetLabels)
+	walletReadyGroup.POST("/labels", s.PostLabel)
+	walletReadyGroup.PUT("/labels/:m", s.PutLabelName)
+	walletReadyGroup.POST("/labels/:m/rescan", s.PostLabelRescan)
+
 	if err := router.Run(config.ExposeHttpHost); err != nil {
 		slog.Error(err.Error())
 		return err
 	}
 	return nil
 }
+
+// requireSpendAuth rejects all requests which are not authenticated as auth.spend_user
+func requireSpendAuth(c *gin.Context) {
+	if !spendAuthorised(c) {
+		c.JSON(http.StatusForbidden, gin.H{"err": "needs the spend credentials"})
+		c.Abort()
+	}
+}
+
+// spendAuthorised tells whether the request may receive the private key tweaks
+func spendAuthorised(c *gin.Context) bool {
+	return config.AuthSpendUser != "" && c.GetString(gin.AuthUserKey) == config.AuthSpendUser
+}
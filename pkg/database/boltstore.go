@@ -0,0 +1,530 @@
+package database
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/setavenger/blindbit-scan/internal"
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	bucketMeta  = []byte("meta")
+	bucketUTXOs = []byte("utxos")
+
+	keyWallet        = []byte("wallet") // keys, labels and birth height, everything that is not written incrementally
+	keyLastScan      = []byte("last_scan")
+	keyScannedBlocks = []byte("scanned_blocks")
+	keySalt          = []byte("salt") // only present in encrypted stores, stored in plaintext
+)
+
+// BoltWalletStore keeps the wallet in a bbolt key-value store.
+// Utxos are stored individually under their key, a commit only writes the changed utxos
+// plus the scan height and the (small) window of scanned blocks.
+//
+// If encryption is enabled all values are sealed and the utxo keys are replaced by an HMAC of the outpoint,
+// so the outpoints can't be read from the keys either. The salt stays the same for the lifetime of the store.
+type BoltWalletStore struct {
+	db *bolt.DB
+
+	enc      *Encryptor
+	indexKey []byte
+}
+
+// NewBoltWalletStore opens or creates the store at path.
+// If the store is still empty and a wallet exists at legacyJSONPath it is imported once.
+func NewBoltWalletStore(path, legacyJSONPath string) (*BoltWalletStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		logging.L.Err(err).Str("path", path).Msg("could not open wallet store")
+		return nil, err
+	}
+
+	s := &BoltWalletStore{db: db}
+
+	err = s.setupEncryption()
+	if err != nil {
+		logging.L.Err(err).Str("path", path).Msg("could not setup wallet store encryption")
+		_ = db.Close()
+		return nil, err
+	}
+
+	if legacyJSONPath == "" || !fileOrSnapshotExists(legacyJSONPath) {
+		return s, nil
+	}
+
+	_, err = s.LoadWallet()
+	if !errors.Is(err, ErrNoWalletData) {
+		return s, nil
+	}
+
+	w, err := NewJSONWalletStore(legacyJSONPath).LoadWallet()
+	if err != nil {
+		logging.L.Err(err).Msg("could not import json wallet")
+		_ = db.Close()
+		return nil, err
+	}
+	err = s.SaveWallet(w)
+	if err != nil {
+		_ = db.Close()
+		return nil, err
+	}
+	logging.L.Info().Str("from", legacyJSONPath).Int("utxos", len(w.UTXOs)).Msg("imported json wallet")
+
+	return s, nil
+}
+
+// boltStoreEncrypted is true if the store at path has a salt, it must not be opened by anything else yet
+func boltStoreEncrypted(path string) bool {
+	if !internal.CheckIfFileExists(path) {
+		return false
+	}
+	db, err := bolt.Open(path, 0600, &bolt.Options{ReadOnly: true, Timeout: time.Second})
+	if err != nil {
+		logging.L.Warn().Err(err).Str("path", path).Msg("could not check wallet store encryption")
+		return false
+	}
+	defer db.Close()
+
+	var encrypted bool
+	_ = db.View(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket(bucketMeta); meta != nil {
+			encrypted = meta.Get(keySalt) != nil
+		}
+		return nil
+	})
+	return encrypted
+}
+
+// setupEncryption uses the salt of the store, a plaintext store is encrypted if encryption got enabled
+func (s *BoltWalletStore) setupEncryption() error {
+	var salt []byte
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket(bucketMeta); meta != nil {
+			salt = bytes.Clone(meta.Get(keySalt))
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	if encryptor == nil {
+		if salt != nil {
+			return ErrEncryptedData
+		}
+		return nil
+	}
+
+	if salt != nil {
+		if len(salt) != saltLength {
+			return ErrUnsupportedFormat
+		}
+		s.setEncryptor(encryptor.withSalt([saltLength]byte(salt)))
+		return nil
+	}
+
+	w, err := s.LoadWallet()
+	if err != nil && !errors.Is(err, ErrNoWalletData) {
+		return err
+	}
+
+	if w != nil {
+		err = s.encryptStore(w)
+		if err != nil {
+			return err
+		}
+		s.setEncryptor(encryptor)
+		logging.L.Info().Int("utxos", len(w.UTXOs)).Msg("encrypted wallet store")
+		return nil
+	}
+
+	s.setEncryptor(encryptor)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+		if err != nil {
+			return err
+		}
+		return meta.Put(keySalt, s.enc.params.salt[:])
+	})
+}
+
+// encryptStore writes w encrypted into a fresh file which replaces the plaintext store once it loads again.
+// Values overwritten in place would stay readable in the free pages of the old file.
+func (s *BoltWalletStore) encryptStore(w *wallet.Wallet) error {
+	path := s.db.Path()
+	tmpPath := path + ".encrypting"
+	err := os.Remove(tmpPath)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	defer func() {
+		// only still there if something failed before the rename
+		_ = os.Remove(tmpPath)
+	}()
+
+	err = writeEncryptedStore(tmpPath, w)
+	if err != nil {
+		return err
+	}
+
+	err = s.db.Close()
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, path)
+	if err == nil {
+		err = syncDir(filepath.Dir(path))
+	}
+	// the store has to be usable again in any case, either with the old or with the new file
+	db, openErr := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if openErr != nil {
+		return openErr
+	}
+	s.db = db
+	return err
+}
+
+// writeEncryptedStore creates an encrypted store at path holding w and checks that it loads again
+func writeEncryptedStore(path string, w *wallet.Wallet) error {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	encrypted := &BoltWalletStore{db: db}
+	encrypted.setEncryptor(encryptor)
+	err = db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucket(bucketMeta)
+		if err != nil {
+			return err
+		}
+		err = meta.Put(keySalt, encrypted.enc.params.salt[:])
+		if err != nil {
+			return err
+		}
+		return encrypted.saveWallet(tx, w)
+	})
+	if closeErr := db.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	// read back from disk with the salt stored in the file
+	db, err = bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	encrypted = &BoltWalletStore{db: db}
+	err = encrypted.setupEncryption()
+	if err != nil {
+		return err
+	}
+	loaded, err := encrypted.LoadWallet()
+	if err != nil {
+		return err
+	}
+	if loaded.SecretKeyScan != w.SecretKeyScan || len(loaded.UTXOs) != len(w.UTXOs) || loaded.LastScanHeight != w.LastScanHeight {
+		return fmt.Errorf("encrypted wallet store does not match the plaintext: (%s)", path)
+	}
+	return nil
+}
+
+func (s *BoltWalletStore) setEncryptor(enc *Encryptor) {
+	s.enc = enc
+	s.indexKey = enc.indexKey()
+}
+
+// seal encrypts value if the store is encrypted
+func (s *BoltWalletStore) seal(value []byte) ([]byte, error) {
+	if s.enc == nil {
+		return value, nil
+	}
+	return s.enc.Seal(value)
+}
+
+// open decrypts value, plaintext values are only accepted from unencrypted stores
+func (s *BoltWalletStore) open(value []byte) ([]byte, error) {
+	if s.enc == nil {
+		if IsEncrypted(value) {
+			return nil, ErrEncryptedData
+		}
+		return value, nil
+	}
+	return s.enc.Open(value)
+}
+
+// utxoKey is the key a utxo is stored under
+func (s *BoltWalletStore) utxoKey(key [36]byte) []byte {
+	if s.indexKey == nil {
+		return key[:]
+	}
+	mac := hmac.New(sha256.New, s.indexKey)
+	mac.Write(key[:])
+	return mac.Sum(nil)
+}
+
+func (s *BoltWalletStore) LoadWallet() (*wallet.Wallet, error) {
+	var w wallet.Wallet
+	err := s.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(bucketMeta)
+		if meta == nil {
+			return ErrNoWalletData
+		}
+		walletData := meta.Get(keyWallet)
+		if walletData == nil {
+			return ErrNoWalletData
+		}
+
+		walletData, err := s.open(walletData)
+		if err != nil {
+			return err
+		}
+		err = json.Unmarshal(walletData, &w)
+		if err != nil {
+			return err
+		}
+
+		if lastScan := meta.Get(keyLastScan); lastScan != nil {
+			lastScan, err = s.open(lastScan)
+			if err != nil {
+				return err
+			}
+			if len(lastScan) == 8 {
+				w.LastScanHeight = binary.BigEndian.Uint64(lastScan)
+			}
+		}
+		if scannedBlocks := meta.Get(keyScannedBlocks); scannedBlocks != nil {
+			scannedBlocks, err = s.open(scannedBlocks)
+			if err != nil {
+				return err
+			}
+			err = json.Unmarshal(scannedBlocks, &w.ScannedBlocks)
+			if err != nil {
+				return err
+			}
+		}
+
+		// utxos are loaded in key order
+		w.UTXOs = nil
+		w.UTXOMapping = wallet.UTXOMapping{}
+		utxos := tx.Bucket(bucketUTXOs)
+		if utxos == nil {
+			return nil
+		}
+		return utxos.ForEach(func(_, v []byte) error {
+			v, err := s.open(v)
+			if err != nil {
+				return err
+			}
+			var utxo wallet.OwnedUTXO
+			err = json.Unmarshal(v, &utxo)
+			if err != nil {
+				return err
+			}
+			key, err := utxo.GetKey()
+			if err != nil {
+				return err
+			}
+			w.UTXOs = append(w.UTXOs, &utxo)
+			w.UTXOMapping[key] = struct{}{}
+			return nil
+		})
+	})
+	if err != nil {
+		if !errors.Is(err, ErrNoWalletData) {
+			logging.L.Err(err).Msg("")
+		}
+		return nil, err
+	}
+
+	return &w, nil
+}
+
+func (s *BoltWalletStore) SaveWallet(w *wallet.Wallet) error {
+	if w == nil {
+		logging.L.Warn().Msg("wallet was nil")
+		return nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		return s.saveWallet(tx, w)
+	})
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	return nil
+}
+
+// saveWallet replaces all stored wallet data within tx
+func (s *BoltWalletStore) saveWallet(tx *bolt.Tx, w *wallet.Wallet) error {
+	err := tx.DeleteBucket(bucketUTXOs)
+	if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+		return err
+	}
+
+	err = s.putWalletHeader(tx, w)
+	if err != nil {
+		return err
+	}
+
+	update := &WalletUpdate{UTXOs: w.UTXOs}
+	return s.commit(tx, w, update)
+}
+
+func (s *BoltWalletStore) putWalletHeader(tx *bolt.Tx, w *wallet.Wallet) error {
+	meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+	if err != nil {
+		return err
+	}
+	walletData, err := marshalWalletHeader(w)
+	if err != nil {
+		return err
+	}
+	walletData, err = s.seal(walletData)
+	if err != nil {
+		return err
+	}
+	return meta.Put(keyWallet, walletData)
+}
+
+func (s *BoltWalletStore) Commit(w *wallet.Wallet, update *WalletUpdate) error {
+	if w == nil {
+		logging.L.Warn().Msg("wallet was nil")
+		return nil
+	}
+
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket(bucketMeta); meta == nil || meta.Get(keyWallet) == nil {
+			// nothing stored yet, the incremental changes need a base to apply to
+			err := s.putWalletHeader(tx, w)
+			if err != nil {
+				return err
+			}
+			update = &WalletUpdate{UTXOs: w.UTXOs}
+		}
+		return s.commit(tx, w, update)
+	})
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	return nil
+}
+
+// commit writes the utxo changes and the scan progress within tx
+func (s *BoltWalletStore) commit(tx *bolt.Tx, w *wallet.Wallet, update *WalletUpdate) error {
+	meta, err := tx.CreateBucketIfNotExists(bucketMeta)
+	if err != nil {
+		return err
+	}
+	utxos, err := tx.CreateBucketIfNotExists(bucketUTXOs)
+	if err != nil {
+		return err
+	}
+
+	if update != nil {
+		for _, utxo := range update.UTXOs {
+			key, err := utxo.GetKey()
+			if err != nil {
+				return err
+			}
+			data, err := json.Marshal(utxo)
+			if err != nil {
+				return err
+			}
+			data, err = s.seal(data)
+			if err != nil {
+				return err
+			}
+			err = utxos.Put(s.utxoKey(key), data)
+			if err != nil {
+				return err
+			}
+		}
+		for _, key := range update.RemovedUTXOs {
+			err = utxos.Delete(s.utxoKey(key))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	lastScan := binary.BigEndian.AppendUint64(nil, w.LastScanHeight)
+	lastScan, err = s.seal(lastScan)
+	if err != nil {
+		return err
+	}
+	err = meta.Put(keyLastScan, lastScan)
+	if err != nil {
+		return err
+	}
+
+	scannedBlocks, err := json.Marshal(w.ScannedBlocks)
+	if err != nil {
+		return err
+	}
+	scannedBlocks, err = s.seal(scannedBlocks)
+	if err != nil {
+		return err
+	}
+	return meta.Put(keyScannedBlocks, scannedBlocks)
+}
+
+func (s *BoltWalletStore) Reset() error {
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range [][]byte{bucketMeta, bucketUTXOs} {
+			err := tx.DeleteBucket(bucket)
+			if err != nil && !errors.Is(err, bolt.ErrBucketNotFound) {
+				return err
+			}
+		}
+		if s.enc == nil {
+			return nil
+		}
+		// keep the salt, utxo keys written from now on are derived from it
+		meta, err := tx.CreateBucket(bucketMeta)
+		if err != nil {
+			return err
+		}
+		return meta.Put(keySalt, s.enc.params.salt[:])
+	})
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	return nil
+}
+
+func (s *BoltWalletStore) Close() error {
+	return s.db.Close()
+}
+
+// walletHeader is the wallet without the parts which are stored incrementally.
+// The shadowing fields take precedence over the embedded ones and are always omitted.
+type walletHeader struct {
+	*wallet.Wallet
+	UTXOs         wallet.UtxoCollection `json:"utxos,omitempty"`
+	UTXOMapping   wallet.UTXOMapping    `json:"utxo_mapping,omitempty"`
+	ScannedBlocks wallet.ScannedBlocks  `json:"scanned_blocks,omitempty"`
+}
+
+// marshalWalletHeader serialises the parts of the wallet which are not stored incrementally
+func marshalWalletHeader(w *wallet.Wallet) ([]byte, error) {
+	return json.Marshal(&walletHeader{Wallet: w})
+}
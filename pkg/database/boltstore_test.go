@@ -0,0 +1,117 @@
+package database
+
+import (
+	"crypto/sha256"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/chaincfg"
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+	"github.com/setavenger/go-bip352"
+)
+
+func newTestWallet(t *testing.T) *wallet.Wallet {
+	t.Helper()
+	config.ChainParams = &chaincfg.SigNetParams
+
+	scanSecret := sha256.Sum256([]byte("scan"))
+	spendSecret := sha256.Sum256([]byte("spend"))
+	_, spendPub := btcec.PrivKeyFromBytes(spendSecret[:])
+	w, err := wallet.SetupWallet(100, 1, scanSecret, bip352.ConvertToFixedLength33(spendPub.SerializeCompressed()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return w
+}
+
+func TestBoltWalletStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewBoltWalletStore(filepath.Join(dir, "wallet.db"), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	if _, err = store.LoadWallet(); !errors.Is(err, ErrNoWalletData) {
+		t.Fatalf("expected ErrNoWalletData for an empty store, got %v", err)
+	}
+
+	w := newTestWallet(t)
+	first := &wallet.OwnedUTXO{Txid: [32]byte{1}, Vout: 0, Amount: 1000, State: wallet.StateUnspent}
+	second := &wallet.OwnedUTXO{Txid: [32]byte{2}, Vout: 1, Amount: 2000, State: wallet.StateUnconfirmed}
+	added, err := w.AddUTXOs([]*wallet.OwnedUTXO{first, second})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.LastScanHeight = 120
+	w.RecordScannedBlock(&wallet.ScannedBlock{Height: 120, BlockHash: [32]byte{120}, Added: added.New}, 10)
+
+	if err = store.Commit(w, &WalletUpdate{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// incremental changes: first gets spent, second is dropped
+	first.State = wallet.StateSpent
+	removed := w.RemoveUnconfirmedUTXOs(nil)
+	w.LastScanHeight = 121
+	update := &WalletUpdate{UTXOs: []*wallet.OwnedUTXO{first}, RemovedUTXOs: removed}
+	if err = store.Commit(w, update); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded, err := store.LoadWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.LastScanHeight != 121 || loaded.SecretKeyScan != w.SecretKeyScan || len(loaded.Labels) != len(w.Labels) {
+		t.Errorf("wallet header was not restored")
+	}
+	if len(loaded.UTXOs) != 1 || loaded.UTXOs[0].State != wallet.StateSpent || loaded.UTXOs[0].Amount != 1000 {
+		t.Fatalf("expected the spent utxo only, got %d utxos", len(loaded.UTXOs))
+	}
+	key, _ := first.GetKey()
+	if _, ok := loaded.UTXOMapping[key]; !ok || len(loaded.UTXOMapping) != 1 {
+		t.Errorf("utxo mapping was not rebuilt")
+	}
+	if len(loaded.ScannedBlocks) != 1 || loaded.ScannedBlocks[0].BlockHash != [32]byte{120} {
+		t.Errorf("scanned blocks were not restored")
+	}
+
+	if err = store.Reset(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.LoadWallet(); !errors.Is(err, ErrNoWalletData) {
+		t.Fatalf("expected ErrNoWalletData after reset, got %v", err)
+	}
+}
+
+func TestBoltWalletStoreImportsJSONWallet(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "wallet")
+
+	w := newTestWallet(t)
+	if _, err := w.AddUTXOs([]*wallet.OwnedUTXO{{Txid: [32]byte{1}, Amount: 1000, State: wallet.StateUnspent}}); err != nil {
+		t.Fatal(err)
+	}
+	w.LastScanHeight = 150
+	if err := NewJSONWalletStore(jsonPath).SaveWallet(w); err != nil {
+		t.Fatal(err)
+	}
+
+	store, err := NewBoltWalletStore(filepath.Join(dir, "wallet.db"), jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	loaded, err := store.LoadWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.LastScanHeight != 150 || len(loaded.UTXOs) != 1 {
+		t.Errorf("json wallet was not imported, scan height %d with %d utxos", loaded.LastScanHeight, len(loaded.UTXOs))
+	}
+}
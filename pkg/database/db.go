@@ -2,10 +2,7 @@ package database
 
 import (
 	"context"
-	"os"
 
-	"github.com/setavenger/blindbit-scan/internal"
-	"github.com/setavenger/blindbit-scan/internal/config"
 	"github.com/setavenger/blindbit-scan/pkg/logging"
 	"github.com/setavenger/blindbit-scan/pkg/networking/nwc"
 	"github.com/setavenger/blindbit-scan/pkg/wallet"
@@ -22,7 +19,13 @@ func WriteToDB(path string, dataStruct Serialiser) error {
 		logging.L.Err(err).Msg("")
 		return err
 	}
-	err = os.WriteFile(path, data, 0644)
+	data, err = sealForDisk(data)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	// the files hold secret keys, only the owner may read them
+	err = writeFileAtomic(path, data, 0600)
 	if err != nil {
 		logging.L.Err(err).Msg("")
 		return err
@@ -31,17 +34,27 @@ func WriteToDB(path string, dataStruct Serialiser) error {
 	return nil
 }
 
+// ReadFromDB falls back to the latest snapshot which can be deserialised if the file at path is broken.
+// If encryption is enabled the file and snapshots which are still plaintext are encrypted right away.
 func ReadFromDB(path string, dataStruct Serialiser) error {
-	data, err := os.ReadFile(path)
+	err := readFileWithFallback(path, func(data []byte) error {
+		data, err := openFromDisk(data)
+		if err != nil {
+			return err
+		}
+		return dataStruct.DeSerialise(data)
+	})
 	if err != nil {
 		logging.L.Err(err).Msg("")
 		return err
 	}
 
-	err = dataStruct.DeSerialise(data)
-	if err != nil {
-		logging.L.Err(err).Msg("")
-		return err
+	if encryptor != nil {
+		err = encryptPlaintextFiles(path)
+		if err != nil {
+			logging.L.Err(err).Msg("")
+			return err
+		}
 	}
 
 	return nil
@@ -60,23 +73,6 @@ func WriteNip47ControllerToDB(p string, c *nwc.Nip47Controller) error {
 	return WriteToDB(p, c)
 }
 
-func TryLoadWalletFromDisk(path string) (*wallet.Wallet, error) {
-	if internal.CheckIfFileExists(path) {
-		var w wallet.Wallet
-		err := ReadFromDB(path, &w)
-		return &w, err
-	}
-
-	logging.L.Trace().Str("path", path).Msg("No wallet data on disk")
-
-	return wallet.SetupWallet(
-		config.BirthHeight,
-		config.LabelCount,
-		config.ScanSecretKey,
-		config.SpendPubKey,
-	)
-}
-
 func TryLoadingControllerFromDisk(
 	ctx context.Context,
 	path string,
@@ -84,7 +80,7 @@ func TryLoadingControllerFromDisk(
 	c *nwc.Nip47Controller,
 	err error,
 ) {
-	if internal.CheckIfFileExists(path) {
+	if fileOrSnapshotExists(path) {
 		var apps nwc.Apps
 		err = ReadFromDB(path, &apps)
 		if err != nil {
@@ -0,0 +1,212 @@
+package database
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// Encrypted data is stored as
+//
+//	magic (6) | version (1) | salt (16) | argon2 time (4) | argon2 memory KiB (4) | argon2 threads (1) | nonce (24) | ciphertext
+//
+// The header up to the nonce is authenticated as additional data.
+var encryptionMagic = []byte("BBSENC")
+
+const (
+	encryptionVersion = 1
+	saltLength        = 16
+	headerLength      = 6 + 1 + saltLength + 4 + 4 + 1
+)
+
+var (
+	ErrEncryptedData     = errors.New("data is encrypted but no passphrase was provided")
+	ErrWrongPassphrase   = errors.New("could not decrypt data, wrong passphrase or corrupted data")
+	ErrUnsupportedFormat = errors.New("unsupported encryption format")
+)
+
+// kdfParams are the argon2id parameters, stored with every ciphertext so they can be raised later
+type kdfParams struct {
+	salt    [saltLength]byte
+	time    uint32
+	memory  uint32
+	threads uint8
+}
+
+// defaults as recommended in RFC 9106
+var defaultKdfParams = kdfParams{time: 3, memory: 64 * 1024, threads: 4}
+
+// Encryptor seals data with XChaCha20-Poly1305 using a key derived from a passphrase with argon2id.
+// Derived keys are cached per salt, key derivation only happens once per salt and not on every write.
+type Encryptor struct {
+	passphrase []byte
+	params     kdfParams // used for sealing
+
+	mu   sync.Mutex
+	keys map[kdfParams][]byte
+}
+
+// NewEncryptor uses a fresh random salt for everything it seals
+func NewEncryptor(passphrase []byte) (*Encryptor, error) {
+	params := defaultKdfParams
+	_, err := rand.Read(params.salt[:])
+	if err != nil {
+		return nil, err
+	}
+	return &Encryptor{
+		passphrase: bytes.Clone(passphrase),
+		params:     params,
+		keys:       map[kdfParams][]byte{},
+	}, nil
+}
+
+// withSalt returns an encryptor sharing the passphrase and key cache which seals with the given salt.
+// Needed where a stable key is required across restarts, e.g. for the bbolt index keys.
+func (e *Encryptor) withSalt(salt [saltLength]byte) *Encryptor {
+	params := e.params
+	params.salt = salt
+	return &Encryptor{
+		passphrase: e.passphrase,
+		params:     params,
+		keys:       e.keys,
+	}
+}
+
+func (e *Encryptor) key(params kdfParams) []byte {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if key, ok := e.keys[params]; ok {
+		return key
+	}
+	key := argon2.IDKey(e.passphrase, params.salt[:], params.time, params.memory, params.threads, chacha20poly1305.KeySize)
+	e.keys[params] = key
+	return key
+}
+
+// Seal encrypts plaintext, the result carries everything apart from the passphrase needed to decrypt it
+func (e *Encryptor) Seal(plaintext []byte) ([]byte, error) {
+	aead, err := chacha20poly1305.NewX(e.key(e.params))
+	if err != nil {
+		return nil, err
+	}
+
+	header := make([]byte, headerLength, headerLength+aead.NonceSize()+len(plaintext)+aead.Overhead())
+	copy(header, encryptionMagic)
+	header[6] = encryptionVersion
+	copy(header[7:], e.params.salt[:])
+	binary.BigEndian.PutUint32(header[23:], e.params.time)
+	binary.BigEndian.PutUint32(header[27:], e.params.memory)
+	header[31] = e.params.threads
+
+	nonce := make([]byte, aead.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	out := append(header, nonce...)
+	return aead.Seal(out, nonce, plaintext, header), nil
+}
+
+// Open decrypts data produced by Seal
+func (e *Encryptor) Open(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return nil, ErrUnsupportedFormat
+	}
+	if data[6] != encryptionVersion {
+		return nil, ErrUnsupportedFormat
+	}
+
+	var params kdfParams
+	copy(params.salt[:], data[7:23])
+	params.time = binary.BigEndian.Uint32(data[23:])
+	params.memory = binary.BigEndian.Uint32(data[27:])
+	params.threads = data[31]
+	if params.time == 0 || params.threads == 0 {
+		return nil, ErrUnsupportedFormat
+	}
+
+	aead, err := chacha20poly1305.NewX(e.key(params))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) < headerLength+aead.NonceSize()+aead.Overhead() {
+		return nil, ErrUnsupportedFormat
+	}
+
+	nonce := data[headerLength : headerLength+aead.NonceSize()]
+	plaintext, err := aead.Open(nil, nonce, data[headerLength+aead.NonceSize():], data[:headerLength])
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	return plaintext, nil
+}
+
+// indexKey derives a key for hiding lookup keys (e.g. outpoints) of the sealing salt
+func (e *Encryptor) indexKey() []byte {
+	mac := hmac.New(sha256.New, e.key(e.params))
+	mac.Write([]byte("blindbit-scan index key"))
+	return mac.Sum(nil)
+}
+
+// IsEncrypted is true if data starts with the header written by Seal
+func IsEncrypted(data []byte) bool {
+	return len(data) >= headerLength && bytes.Equal(data[:len(encryptionMagic)], encryptionMagic)
+}
+
+// encryptor is used for everything written by this package, nil if encryption is disabled
+var encryptor *Encryptor
+
+// EnableEncryption encrypts all data written from now on with passphrase.
+// Existing plaintext data can still be read and is encrypted when it is loaded.
+func EnableEncryption(passphrase []byte) error {
+	e, err := NewEncryptor(passphrase)
+	if err != nil {
+		return err
+	}
+	encryptor = e
+	return nil
+}
+
+// EncryptedDataExists is true if any of the databases was encrypted in an earlier run.
+// Otherwise the passphrase is new and nothing can tell a mistyped one apart.
+func EncryptedDataExists() bool {
+	for _, path := range []string{config.PathDbWallet, config.PathDbNWC, config.PathDbWebhooks} {
+		if fileOrSnapshotEncrypted(path) {
+			return true
+		}
+	}
+	return boltStoreEncrypted(config.PathDbWalletBolt)
+}
+
+// DisableEncryption is mainly used in tests
+func DisableEncryption() {
+	encryptor = nil
+}
+
+// sealForDisk encrypts data if encryption is enabled
+func sealForDisk(data []byte) ([]byte, error) {
+	if encryptor == nil {
+		return data, nil
+	}
+	return encryptor.Seal(data)
+}
+
+// openFromDisk decrypts data if it is encrypted, plaintext is passed through
+func openFromDisk(data []byte) ([]byte, error) {
+	if !IsEncrypted(data) {
+		return data, nil
+	}
+	if encryptor == nil {
+		return nil, ErrEncryptedData
+	}
+	return encryptor.Open(data)
+}
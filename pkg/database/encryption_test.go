@@ -0,0 +1,225 @@
+package database
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+)
+
+func enableTestEncryption(t *testing.T, passphrase string) {
+	t.Helper()
+	if err := EnableEncryption([]byte(passphrase)); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(DisableEncryption)
+}
+
+func TestEncryptorSealOpen(t *testing.T) {
+	e, err := NewEncryptor([]byte("correct horse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := e.Seal([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !IsEncrypted(sealed) || bytes.Contains(sealed, []byte("secret")) {
+		t.Fatalf("data was not sealed")
+	}
+
+	// a different encryptor with the same passphrase derives the key from the stored salt
+	other, _ := NewEncryptor([]byte("correct horse"))
+	if opened, err := other.Open(sealed); err != nil || string(opened) != "secret" {
+		t.Fatalf("could not open sealed data: %v", err)
+	}
+
+	wrong, _ := NewEncryptor([]byte("battery staple"))
+	if _, err = wrong.Open(sealed); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+
+	sealed[10] ^= 1 // the header is authenticated
+	if _, err = e.Open(sealed); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected tampered header to fail, got %v", err)
+	}
+}
+
+func TestReadFromDBEncryptsPlaintext(t *testing.T) {
+	config.StorageSnapshots = 3
+	path := filepath.Join(t.TempDir(), "wallet")
+
+	for version := 1; version <= 2; version++ {
+		if err := WriteToDB(path, &testRecord{Version: version}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Chmod(path, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	enableTestEncryption(t, "correct horse")
+
+	var record testRecord
+	if err := ReadFromDB(path, &record); err != nil || record.Version != 2 {
+		t.Fatalf("expected version 2, got %d (%v)", record.Version, err)
+	}
+	if !fileOrSnapshotEncrypted(path) {
+		t.Errorf("expected encrypted data to be detected")
+	}
+
+	// restart with the same passphrase
+	enableTestEncryption(t, "correct horse")
+	if err := ReadFromDB(path, &record); err != nil || record.Version != 2 {
+		t.Fatalf("expected version 2 after restart, got %d (%v)", record.Version, err)
+	}
+
+	// no plaintext copy or temporary file is left next to the data
+	files, err := filepath.Glob(path + "*")
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshots, err := snapshotPaths(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != len(snapshots)+1 || len(snapshots) != 1 {
+		t.Fatalf("expected the file and its snapshot, got %v", files)
+	}
+	for _, p := range files {
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsEncrypted(data) {
+			t.Errorf("%s is still plaintext", p)
+		}
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0600 {
+			t.Errorf("expected mode 0600 for %s, got %v", p, info.Mode().Perm())
+		}
+	}
+
+	DisableEncryption()
+	if err = ReadFromDB(path, &record); !errors.Is(err, ErrEncryptedData) {
+		t.Errorf("expected ErrEncryptedData without passphrase, got %v", err)
+	}
+}
+
+func TestReadFromDBWrongPassphraseDoesNotFallBack(t *testing.T) {
+	config.StorageSnapshots = 3
+	path := filepath.Join(t.TempDir(), "wallet")
+
+	// a plaintext snapshot next to the encrypted file, e.g. copied back by hand
+	if err := WriteToDB(path, &testRecord{Version: 1}); err != nil {
+		t.Fatal(err)
+	}
+	enableTestEncryption(t, "correct horse")
+	if err := WriteToDB(path, &testRecord{Version: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(snapshotPath(path, 1), []byte(`{"version":1}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	sealed, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	enableTestEncryption(t, "battery staple")
+	var record testRecord
+	if err = ReadFromDB(path, &record); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("expected ErrWrongPassphrase, got %v (version %d)", err, record.Version)
+	}
+	if data, err := os.ReadFile(path); err != nil || !bytes.Equal(data, sealed) {
+		t.Errorf("expected the encrypted file to be left alone (%v)", err)
+	}
+}
+
+func TestBoltWalletStoreEncryption(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "wallet.db")
+
+	w := newTestWallet(t)
+	utxo := &wallet.OwnedUTXO{Txid: [32]byte{0xab, 0xcd, 0xef, 0x12}, Vout: 3, Amount: 1000, State: wallet.StateUnspent}
+	if _, err := w.AddUTXOs([]*wallet.OwnedUTXO{utxo}); err != nil {
+		t.Fatal(err)
+	}
+	w.LastScanHeight = 130
+
+	// start out with a plaintext store which gets encrypted once a passphrase is set
+	store, err := NewBoltWalletStore(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = store.SaveWallet(w); err != nil {
+		t.Fatal(err)
+	}
+	store.Close()
+	// the scan secret is stored in the header as hex
+	secret := []byte(hex.EncodeToString(w.SecretKeyScan[:]))
+	if data, err := os.ReadFile(path); err != nil || !bytes.Contains(data, secret) {
+		t.Fatalf("expected the plaintext store to hold the scan secret (%v)", err)
+	}
+
+	enableTestEncryption(t, "correct horse")
+	store, err = NewBoltWalletStore(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	utxo.State = wallet.StateSpent
+	if err = store.Commit(w, &WalletUpdate{UTXOs: []*wallet.OwnedUTXO{utxo}}); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := store.LoadWallet()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded.SecretKeyScan != w.SecretKeyScan || len(loaded.UTXOs) != 1 || loaded.UTXOs[0].State != wallet.StateSpent {
+		t.Fatalf("wallet was not restored from the encrypted store")
+	}
+	store.Close()
+	if !boltStoreEncrypted(path) {
+		t.Errorf("expected the store to be detected as encrypted")
+	}
+	// the store was rewritten into a fresh file, no free pages with plaintext are left
+	if data, err := os.ReadFile(path); err != nil || bytes.Contains(data, secret) {
+		t.Errorf("expected no plaintext scan secret in the encrypted store (%v)", err)
+	}
+	if files, _ := filepath.Glob(path + ".*"); len(files) != 0 {
+		t.Errorf("expected no copies of the store, got %v", files)
+	}
+
+	// the same passphrase unlocks the store after a restart
+	enableTestEncryption(t, "correct horse")
+	store, err = NewBoltWalletStore(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if loaded, err = store.LoadWallet(); err != nil || len(loaded.UTXOs) != 1 {
+		t.Fatalf("could not load wallet after restart: %v", err)
+	}
+	store.Close()
+
+	enableTestEncryption(t, "battery staple")
+	store, err = NewBoltWalletStore(path, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = store.LoadWallet(); !errors.Is(err, ErrWrongPassphrase) {
+		t.Errorf("expected ErrWrongPassphrase, got %v", err)
+	}
+	store.Close()
+
+	DisableEncryption()
+	if _, err = NewBoltWalletStore(path, ""); !errors.Is(err, ErrEncryptedData) {
+		t.Errorf("expected ErrEncryptedData without passphrase, got %v", err)
+	}
+}
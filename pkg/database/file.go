@@ -0,0 +1,301 @@
+package database
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/setavenger/blindbit-scan/internal"
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+)
+
+// writeFileAtomic replaces the file at path such that it either holds the old or the new data, even on a crash.
+// The data is written to a temporary file in the same directory, synced and renamed over path.
+// The previous version is kept as snapshot path.1, older snapshots are rotated up to config.StorageSnapshots.
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(path)
+
+	tmpPath, err := writeTempFile(path, data, perm)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		// only still there if something failed before the rename
+		_ = os.Remove(tmpPath)
+	}()
+
+	restrictPermissions(path, perm)
+
+	err = rotateSnapshots(path, config.StorageSnapshots)
+	if err != nil {
+		return err
+	}
+
+	err = os.Rename(tmpPath, path)
+	if err != nil {
+		return err
+	}
+
+	return syncDir(dir)
+}
+
+// writeTempFile writes data to a synced temporary file next to path and returns its path
+func writeTempFile(path string, data []byte, perm os.FileMode) (string, error) {
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Chmod(perm)
+	}
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
+
+// rotateSnapshots moves path.n to path.n+1 and keeps the current file as path.1.
+// The current file is hard linked so that path exists at any point in time.
+func rotateSnapshots(path string, keep int) error {
+	snapshots, err := snapshotPaths(path)
+	if err != nil {
+		return err
+	}
+
+	// drop everything which falls out of the window, also covers a lowered setting
+	for n := len(snapshots); n >= keep && n > 0; n-- {
+		err = os.Remove(snapshots[n-1])
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+		snapshots = snapshots[:n-1]
+	}
+
+	if keep < 1 || !internal.CheckIfFileExists(path) {
+		return nil
+	}
+
+	for n := len(snapshots); n > 0; n-- {
+		err = os.Rename(snapshots[n-1], snapshotPath(path, n+1))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = os.Link(path, snapshotPath(path, 1))
+	if err != nil {
+		// not every filesystem supports hard links, path is missing until the new file is renamed into place
+		return os.Rename(path, snapshotPath(path, 1))
+	}
+	return nil
+}
+
+// readFileWithFallback reads path and hands the data to decode.
+// If the file is missing, can't be read or decode fails, the snapshots are tried from newest to oldest.
+// Data which can't be decrypted ends the search, an older snapshot would hide a mistyped passphrase.
+func readFileWithFallback(path string, decode func([]byte) error) error {
+	candidates := []string{path}
+	snapshots, err := snapshotPaths(path)
+	if err != nil {
+		return err
+	}
+	candidates = append(candidates, snapshots...)
+
+	var firstErr error
+	for i, candidate := range candidates {
+		data, err := os.ReadFile(candidate)
+		if err == nil {
+			err = decode(data)
+		}
+		if errors.Is(err, ErrWrongPassphrase) || errors.Is(err, ErrEncryptedData) {
+			logging.L.Err(err).Str("path", candidate).Msg("could not decrypt data")
+			return err
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			if !errors.Is(err, os.ErrNotExist) {
+				logging.L.Err(err).Str("path", candidate).Msg("could not load data")
+			}
+			continue
+		}
+
+		if i > 0 {
+			logging.L.Warn().
+				Str("path", path).
+				Str("snapshot", candidate).
+				Msg("loaded previous snapshot, changes after it are lost and will be rescanned")
+		}
+		return nil
+	}
+
+	return firstErr
+}
+
+// restrictPermissions applies perm to path and its snapshots, files written by older versions were world readable
+func restrictPermissions(path string, perm os.FileMode) {
+	snapshots, _ := snapshotPaths(path)
+	for _, p := range append([]string{path}, snapshots...) {
+		err := os.Chmod(p, perm)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			logging.L.Warn().Err(err).Str("path", p).Msg("could not restrict file permissions")
+		}
+	}
+}
+
+// encryptPlaintextFiles encrypts path and its snapshots which are still plaintext in place.
+// Every file is sealed into a temporary file which is read back and opened before it replaces the plaintext,
+// no plaintext copy is left behind.
+func encryptPlaintextFiles(path string) error {
+	snapshots, err := snapshotPaths(path)
+	if err != nil {
+		return err
+	}
+
+	var encrypted bool
+	for _, p := range append([]string{path}, snapshots...) {
+		plaintext, err := os.ReadFile(p)
+		if errors.Is(err, os.ErrNotExist) || (err == nil && IsEncrypted(plaintext)) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		err = replaceWithSealed(p, plaintext)
+		if err != nil {
+			logging.L.Err(err).Str("path", p).Msg("could not encrypt file")
+			return err
+		}
+		logging.L.Info().Str("path", p).Msg("encrypted plaintext file")
+		encrypted = true
+	}
+	if !encrypted {
+		return nil
+	}
+	return syncDir(filepath.Dir(path))
+}
+
+// replaceWithSealed atomically replaces the file at path with the sealed plaintext once the written file opens again
+func replaceWithSealed(path string, plaintext []byte) error {
+	sealed, err := encryptor.Seal(plaintext)
+	if err != nil {
+		return err
+	}
+	tmpPath, err := writeTempFile(path, sealed, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = os.Remove(tmpPath)
+	}()
+
+	written, err := os.ReadFile(tmpPath)
+	if err != nil {
+		return err
+	}
+	opened, err := encryptor.Open(written)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(opened, plaintext) {
+		return fmt.Errorf("encrypted file does not match the plaintext: (%s)", path)
+	}
+
+	return os.Rename(tmpPath, path)
+}
+
+// Exists is true if there is data at path which ReadFromDB can load
+func Exists(path string) bool {
+	return fileOrSnapshotExists(path)
+}
+
+// fileOrSnapshotExists is true if path or any of its snapshots exist
+func fileOrSnapshotExists(path string) bool {
+	if internal.CheckIfFileExists(path) {
+		return true
+	}
+	snapshots, err := snapshotPaths(path)
+	return err == nil && len(snapshots) > 0
+}
+
+// fileOrSnapshotEncrypted is true if path or any of its snapshots is encrypted
+func fileOrSnapshotEncrypted(path string) bool {
+	snapshots, _ := snapshotPaths(path)
+	for _, p := range append([]string{path}, snapshots...) {
+		data, err := os.ReadFile(p)
+		if err == nil && IsEncrypted(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// removeFileAndSnapshots removes path and all of its snapshots
+func removeFileAndSnapshots(path string) error {
+	snapshots, err := snapshotPaths(path)
+	if err != nil {
+		return err
+	}
+	for _, p := range append([]string{path}, snapshots...) {
+		err = os.Remove(p)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	return nil
+}
+
+func snapshotPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
+
+// snapshotPaths returns the existing snapshots of path ordered from newest (path.1) to oldest
+func snapshotPaths(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, err
+	}
+
+	numbers := make(map[string]int, len(matches))
+	var snapshots []string
+	for _, match := range matches {
+		n, err := strconv.Atoi(strings.TrimPrefix(match, path+"."))
+		if err != nil || n < 1 {
+			// e.g. wallet.db or leftover temp files
+			continue
+		}
+		numbers[match] = n
+		snapshots = append(snapshots, match)
+	}
+	sort.Slice(snapshots, func(i, j int) bool {
+		return numbers[snapshots[i]] < numbers[snapshots[j]]
+	})
+
+	return snapshots, nil
+}
+
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
+}
@@ -0,0 +1,106 @@
+package database
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/setavenger/blindbit-scan/internal/config"
+)
+
+type testRecord struct {
+	Version int `json:"version"`
+}
+
+func (r *testRecord) Serialise() ([]byte, error) {
+	return json.Marshal(r)
+}
+
+func (r *testRecord) DeSerialise(data []byte) error {
+	return json.Unmarshal(data, r)
+}
+
+func TestWriteToDBRotatesSnapshots(t *testing.T) {
+	config.StorageSnapshots = 2
+	path := filepath.Join(t.TempDir(), "wallet")
+
+	for version := 1; version <= 5; version++ {
+		if err := WriteToDB(path, &testRecord{Version: version}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	snapshots, err := snapshotPaths(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots) != 2 {
+		t.Fatalf("expected 2 snapshots, got %v", snapshots)
+	}
+	for i, expected := range []int{5, 4, 3} {
+		p := path
+		if i > 0 {
+			p = snapshots[i-1]
+		}
+		var record testRecord
+		data, err := os.ReadFile(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err = record.DeSerialise(data); err != nil || record.Version != expected {
+			t.Errorf("expected version %d in %s, got %d", expected, p, record.Version)
+		}
+	}
+
+	entries, err := os.ReadDir(filepath.Dir(path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 {
+		t.Errorf("expected no leftover temp files, got %d files", len(entries))
+	}
+}
+
+func TestReadFromDBFallsBackToSnapshot(t *testing.T) {
+	config.StorageSnapshots = 3
+	path := filepath.Join(t.TempDir(), "wallet")
+
+	for version := 1; version <= 3; version++ {
+		if err := WriteToDB(path, &testRecord{Version: version}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// simulate a write that was cut off
+	if err := os.WriteFile(path, []byte(`{"vers`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var record testRecord
+	if err := ReadFromDB(path, &record); err != nil {
+		t.Fatal(err)
+	}
+	if record.Version != 2 {
+		t.Errorf("expected fallback to version 2, got %d", record.Version)
+	}
+
+	// the current file can also be missing entirely
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	if !fileOrSnapshotExists(path) {
+		t.Fatalf("snapshots should count as existing data")
+	}
+	record = testRecord{}
+	if err := ReadFromDB(path, &record); err != nil || record.Version != 2 {
+		t.Errorf("expected fallback to version 2 for a missing file, got %d (%v)", record.Version, err)
+	}
+
+	if err := removeFileAndSnapshots(path); err != nil {
+		t.Fatal(err)
+	}
+	if fileOrSnapshotExists(path) {
+		t.Errorf("expected all snapshots to be removed")
+	}
+}
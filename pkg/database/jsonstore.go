@@ -0,0 +1,50 @@
+package database
+
+import (
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+)
+
+// JSONWalletStore keeps the wallet as a single JSON file.
+// Every commit rewrites the whole file atomically, updates are only used as a signal that something changed.
+type JSONWalletStore struct {
+	Path string
+}
+
+func NewJSONWalletStore(path string) *JSONWalletStore {
+	return &JSONWalletStore{Path: path}
+}
+
+func (s *JSONWalletStore) LoadWallet() (*wallet.Wallet, error) {
+	if !fileOrSnapshotExists(s.Path) {
+		return nil, ErrNoWalletData
+	}
+	var w wallet.Wallet
+	err := ReadFromDB(s.Path, &w)
+	if err != nil {
+		return nil, err
+	}
+	return &w, nil
+}
+
+func (s *JSONWalletStore) SaveWallet(w *wallet.Wallet) error {
+	return WriteWalletToDB(s.Path, w)
+}
+
+func (s *JSONWalletStore) Commit(w *wallet.Wallet, _ *WalletUpdate) error {
+	return WriteWalletToDB(s.Path, w)
+}
+
+// Reset also removes the snapshots, otherwise loading would fall back to them
+func (s *JSONWalletStore) Reset() error {
+	err := removeFileAndSnapshots(s.Path)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	return nil
+}
+
+func (s *JSONWalletStore) Close() error {
+	return nil
+}
@@ -0,0 +1,76 @@
+package database
+
+import (
+	"errors"
+
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/wallet"
+)
+
+var ErrNoWalletData = errors.New("no wallet data in store")
+
+// WalletStore persists the wallet.
+// Besides full writes it accepts incremental updates, which engines can apply without rewriting the entire wallet.
+type WalletStore interface {
+	// LoadWallet returns ErrNoWalletData if nothing has been stored yet
+	LoadWallet() (*wallet.Wallet, error)
+	// SaveWallet writes the complete wallet, used after resets, rollbacks and on shutdown
+	SaveWallet(w *wallet.Wallet) error
+	// Commit writes the changed utxos together with the scan height and the scanned blocks of the wallet in one transaction
+	Commit(w *wallet.Wallet, update *WalletUpdate) error
+	// Reset deletes all stored wallet data
+	Reset() error
+	Close() error
+}
+
+// WalletUpdate holds the changes to the wallet since the last commit
+type WalletUpdate struct {
+	UTXOs        []*wallet.OwnedUTXO // utxos which were added or changed their state
+	RemovedUTXOs [][36]byte
+}
+
+// PutKeys adds the utxos with the given keys to the update
+func (u *WalletUpdate) PutKeys(w *wallet.Wallet, keys ...[36]byte) {
+	for _, key := range keys {
+		if utxo := w.GetUTXO(key); utxo != nil {
+			u.UTXOs = append(u.UTXOs, utxo)
+		}
+	}
+}
+
+// IsEmpty is true if the update holds no utxo changes
+func (u *WalletUpdate) IsEmpty() bool {
+	return len(u.UTXOs) == 0 && len(u.RemovedUTXOs) == 0
+}
+
+// NewWalletStore opens the store for the configured storage engine
+func NewWalletStore() (WalletStore, error) {
+	switch config.StorageEngine {
+	case "bbolt":
+		return NewBoltWalletStore(config.PathDbWalletBolt, config.PathDbWallet)
+	default:
+		return NewJSONWalletStore(config.PathDbWallet), nil
+	}
+}
+
+// TryLoadWallet loads the wallet from the store or creates a new one based on the blindbit.toml config-file
+func TryLoadWallet(store WalletStore) (*wallet.Wallet, error) {
+	w, err := store.LoadWallet()
+	if err == nil {
+		return w, nil
+	}
+	if !errors.Is(err, ErrNoWalletData) {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	logging.L.Trace().Msg("No wallet data in store")
+
+	return wallet.SetupWallet(
+		config.BirthHeight,
+		config.LabelCount,
+		config.ScanSecretKey,
+		config.SpendPubKey,
+	)
+}
@@ -0,0 +1,123 @@
+package metrics
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "blindbit"
+
+// Registry holds all metrics exposed on /metrics
+var Registry = prometheus.NewRegistry()
+
+var (
+	// OracleRequestDuration is labelled with the endpoint without the height, e.g. filter/spent
+	OracleRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "oracle",
+		Name:      "request_duration_seconds",
+		Help:      "Latency of requests to the oracle per endpoint.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"endpoint"})
+
+	OracleRequestErrors = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "oracle",
+		Name:      "request_errors_total",
+		Help:      "Failed requests to the oracle per endpoint, including non-2xx responses.",
+	}, []string{"endpoint"})
+
+	BlocksScanned = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scan",
+		Name:      "blocks_scanned_total",
+		Help:      "Blocks scanned and committed to the wallet.",
+	})
+
+	TweaksPerBlock = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "scan",
+		Name:      "tweaks_per_block",
+		Help:      "Tweaks processed per scanned block.",
+		Buckets:   prometheus.ExponentialBuckets(1, 4, 8),
+	})
+
+	// the filter label is the filter type, new-utxos or spent
+	FilterChecks = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scan",
+		Name:      "filter_checks_total",
+		Help:      "Blocks where a filter was checked against the wallet.",
+	}, []string{"filter"})
+
+	FilterHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scan",
+		Name:      "filter_hits_total",
+		Help:      "Blocks where a filter matched and the full block data was downloaded.",
+	}, []string{"filter"})
+
+	FilterFalsePositives = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scan",
+		Name:      "filter_false_positives_total",
+		Help:      "Filter matches where none of the wallet's outputs was in the block.",
+	}, []string{"filter"})
+
+	// the source label is block or mempool
+	UTXOsFound = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scan",
+		Name:      "utxos_found_total",
+		Help:      "Utxos added to the wallet or confirmed.",
+	}, []string{"source"})
+
+	UTXOsSpent = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "scan",
+		Name:      "utxos_spent_total",
+		Help:      "Utxos marked as spent by the spent outpoints index.",
+	})
+
+	// the outcome label is ok, error, unauthorized, not_implemented or decrypt_error
+	NWCRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "nwc",
+		Name:      "requests_total",
+		Help:      "NWC requests per method and outcome.",
+	}, []string{"method", "outcome"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		OracleRequestDuration,
+		OracleRequestErrors,
+		BlocksScanned,
+		TweaksPerBlock,
+		FilterChecks,
+		FilterHits,
+		FilterFalsePositives,
+		UTXOsFound,
+		UTXOsSpent,
+		NWCRequests,
+	)
+}
+
+// ObserveOracleRequest records a single request to the oracle
+func ObserveOracleRequest(endpoint string, duration time.Duration, failed bool) {
+	OracleRequestDuration.WithLabelValues(endpoint).Observe(duration.Seconds())
+	if failed {
+		OracleRequestErrors.WithLabelValues(endpoint).Inc()
+	}
+}
+
+// Handler serves the metrics of Registry in the prometheus text format
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
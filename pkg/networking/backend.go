@@ -0,0 +1,46 @@
+package networking
+
+import "context"
+
+// IndexBackend provides the per block data needed for scanning.
+// ClientBlindBit is the default implementation which talks to a blindbit-oracle instance.
+type IndexBackend interface {
+	// GetTweaks returns the tweaks (A_sum * input_hash) of the eligible transactions in the block.
+	// Only transactions whose largest taproot output exceeds dustLimit are included, 0 disables the limit.
+	// Backends may apply cut-through and omit transactions whose taproot outputs are all spent by now.
+	GetTweaks(blockHeight, dustLimit uint64) ([][33]byte, error)
+	// GetTweakIndex is like GetTweaks but always returns the full tweak index without cut-through.
+	// Needed to find outputs in older history which have been spent since.
+	GetTweakIndex(blockHeight, dustLimit uint64) ([][33]byte, error)
+	// GetUTXOs returns all taproot outputs that were created in the block
+	GetUTXOs(blockHeight uint64) ([]*UTXOServed, error)
+	// GetFilter returns the filter of the given type for the block
+	GetFilter(blockHeight uint64, filterType FilterType) (*Filter, error)
+	// GetSpentOutpointsIndex returns the short hashes of all outpoints spent in the block
+	GetSpentOutpointsIndex(blockHeight uint64) (SpentOutpointsIndex, error)
+	// GetChainTip returns the height of the latest block the backend has indexed
+	GetChainTip() (uint64, error)
+}
+
+// MempoolTransaction is an unconfirmed transaction which is eligible for silent payments
+type MempoolTransaction struct {
+	Txid         [32]byte
+	Tweak        [33]byte
+	HighestValue uint64        // value of the largest taproot output, used for the dust limit
+	Outputs      []*UTXOServed // taproot outputs of the transaction, block data is not set
+}
+
+// MempoolBackend is implemented by backends which can also serve unconfirmed transactions.
+// It is optional, the mempool is only scanned if the configured backend supports it.
+// Only bitcoind does, the oracle has no mempool endpoint.
+type MempoolBackend interface {
+	// GetMempoolTransactions returns all transactions currently in the mempool which are eligible for silent payments.
+	// No dust limit is applied so that the result is the complete set of eligible transactions.
+	GetMempoolTransactions() ([]*MempoolTransaction, error)
+}
+
+// ContextBackend is implemented by backends whose requests can be cancelled.
+// The daemon sets its context so that stopping a sync also aborts the requests in flight.
+type ContextBackend interface {
+	SetContext(ctx context.Context)
+}
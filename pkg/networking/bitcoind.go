@@ -0,0 +1,666 @@
+package networking
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/btcsuite/btcd/btcutil"
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbitd/src/utils"
+	"github.com/setavenger/go-bip352"
+)
+
+// number of processed blocks which are kept in memory.
+// A block is requested several times while it is scanned (tweaks, filters, utxos).
+const bitcoinCoreBlockCacheSize = 32
+
+// RPC_INVALID_ADDRESS_OR_KEY, returned among others for transactions that are not in the mempool
+const rpcErrInvalidAddressOrKey = -5
+
+// ClientBitcoinCore is an IndexBackend which reads blocks including their undo data (prevouts)
+// from a Bitcoin Core node over JSON-RPC and computes tweaks, filters and the spent index locally.
+// Requires Bitcoin Core v25.0 or newer for getblock verbosity 3.
+//
+// The backend always serves the full tweak index, there is no cut-through. GetTweaks and GetTweakIndex are the same.
+// UTXOServed.Spent is only set for outputs which are spent within the same block,
+// later spends are picked up through the spent index while scanning forward.
+type ClientBitcoinCore struct {
+	RpcUrl     string
+	User       string
+	Pass       string
+	CookiePath string // used instead of User and Pass if set
+
+	HttpClient *http.Client
+
+	requestId atomic.Uint64
+
+	cacheMu    sync.Mutex
+	cache      map[[32]byte]*bitcoinCoreBlock
+	cacheOrder [][32]byte
+
+	// mempoolCache holds the processed mempool transactions of the last poll by txid,
+	// nil for transactions that are not eligible. Only new transactions are fetched on the next poll.
+	mempoolMu    sync.Mutex
+	mempoolCache map[string]*MempoolTransaction
+}
+
+func NewClientBitcoinCore(rpcUrl, user, pass, cookiePath string) *ClientBitcoinCore {
+	return &ClientBitcoinCore{
+		RpcUrl:     rpcUrl,
+		User:       user,
+		Pass:       pass,
+		CookiePath: cookiePath,
+		HttpClient: http.DefaultClient,
+		cache:      map[[32]byte]*bitcoinCoreBlock{},
+	}
+}
+
+// bitcoinCoreBlock is a block with all the data derived for scanning
+type bitcoinCoreBlock struct {
+	height         uint64
+	blockHash      [32]byte
+	tweaks         []IndexedTweak
+	utxos          []*UTXOServed
+	spentHashes    [][8]byte
+	newUTXOsFilter []byte
+	spentFilter    []byte
+}
+
+type rpcRequest struct {
+	JsonRpc string `json:"jsonrpc"`
+	Id      uint64 `json:"id"`
+	Method  string `json:"method"`
+	Params  []any  `json:"params"`
+}
+
+type rpcResponse struct {
+	Result json.RawMessage `json:"result"`
+	Error  *RpcError       `json:"error"`
+	Id     uint64          `json:"id"`
+}
+
+// RpcError is an error returned by the Bitcoin Core JSON-RPC interface
+type RpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *RpcError) Error() string {
+	return fmt.Sprintf("rpc error %d: %s", e.Code, e.Message)
+}
+
+type rpcScriptPubKey struct {
+	Hex string `json:"hex"`
+}
+
+type rpcVin struct {
+	Coinbase    string   `json:"coinbase"`
+	Txid        string   `json:"txid"`
+	Vout        uint32   `json:"vout"`
+	TxInWitness []string `json:"txinwitness"`
+	ScriptSig   struct {
+		Hex string `json:"hex"`
+	} `json:"scriptSig"`
+	Prevout *struct {
+		Value        float64         `json:"value"`
+		ScriptPubKey rpcScriptPubKey `json:"scriptPubKey"`
+	} `json:"prevout"`
+}
+
+type rpcVout struct {
+	Value        float64         `json:"value"`
+	N            uint32          `json:"n"`
+	ScriptPubKey rpcScriptPubKey `json:"scriptPubKey"`
+}
+
+type rpcTx struct {
+	Txid string    `json:"txid"`
+	Vin  []rpcVin  `json:"vin"`
+	Vout []rpcVout `json:"vout"`
+}
+
+type rpcBlock struct {
+	Hash   string  `json:"hash"`
+	Height uint64  `json:"height"`
+	Time   uint64  `json:"time"`
+	Tx     []rpcTx `json:"tx"`
+}
+
+func (c *ClientBitcoinCore) call(method string, result any, params ...any) error {
+	if params == nil {
+		params = []any{}
+	}
+	reqBody, err := json.Marshal(rpcRequest{
+		JsonRpc: "1.0",
+		Id:      c.requestId.Add(1),
+		Method:  method,
+		Params:  params,
+	})
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	req, err := http.NewRequest(http.MethodPost, c.RpcUrl, bytes.NewReader(reqBody))
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	user, pass, err := c.credentials()
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	req.SetBasicAuth(user, pass)
+
+	httpClient := c.HttpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		err = errors.New("bitcoin core rpc: unauthorized")
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	// bitcoin core also answers with 404 and 500 for rpc level errors, the body holds the details
+	var rpcResp rpcResponse
+	err = json.Unmarshal(body, &rpcResp)
+	if err != nil {
+		logging.L.Err(err).Int("status", resp.StatusCode).Msg("")
+		return err
+	}
+	if rpcResp.Error != nil {
+		return rpcResp.Error
+	}
+
+	return json.Unmarshal(rpcResp.Result, result)
+}
+
+func (c *ClientBitcoinCore) credentials() (string, string, error) {
+	if c.CookiePath == "" {
+		return c.User, c.Pass, nil
+	}
+
+	// the cookie is rewritten on every restart of bitcoind so we read it every time
+	data, err := os.ReadFile(utils.ResolvePath(c.CookiePath))
+	if err != nil {
+		return "", "", err
+	}
+	user, pass, found := strings.Cut(strings.TrimSpace(string(data)), ":")
+	if !found {
+		return "", "", errors.New("invalid bitcoin core cookie file")
+	}
+	return user, pass, nil
+}
+
+func (c *ClientBitcoinCore) GetChainTip() (uint64, error) {
+	var blockCount uint64
+	err := c.call("getblockcount", &blockCount)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return 0, err
+	}
+	return blockCount, nil
+}
+
+func (c *ClientBitcoinCore) GetTweaks(blockHeight, dustLimit uint64) ([][33]byte, error) {
+	block, err := c.getBlock(blockHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	var tweaks [][33]byte
+	for _, tweak := range block.tweaks {
+		if dustLimit > 0 && tweak.HighestValue < dustLimit {
+			continue
+		}
+		tweaks = append(tweaks, tweak.Tweak)
+	}
+	return tweaks, nil
+}
+
+func (c *ClientBitcoinCore) GetTweakIndex(blockHeight, dustLimit uint64) ([][33]byte, error) {
+	return c.GetTweaks(blockHeight, dustLimit)
+}
+
+func (c *ClientBitcoinCore) GetUTXOs(blockHeight uint64) ([]*UTXOServed, error) {
+	block, err := c.getBlock(blockHeight)
+	if err != nil {
+		return nil, err
+	}
+	return block.utxos, nil
+}
+
+func (c *ClientBitcoinCore) GetFilter(blockHeight uint64, filterType FilterType) (*Filter, error) {
+	block, err := c.getBlock(blockHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	filter := &Filter{
+		BlockHeight: block.height,
+		BlockHash:   block.blockHash,
+	}
+	switch filterType {
+	case NewUTXOFilterType:
+		filter.FilterType = 1
+		filter.Data = block.newUTXOsFilter
+	case SpentOutpointsFilterType:
+		filter.FilterType = 2
+		filter.Data = block.spentFilter
+	default:
+		return nil, fmt.Errorf("unknown filter type %s", filterType)
+	}
+
+	return filter, nil
+}
+
+func (c *ClientBitcoinCore) GetSpentOutpointsIndex(blockHeight uint64) (SpentOutpointsIndex, error) {
+	block, err := c.getBlock(blockHeight)
+	if err != nil {
+		return SpentOutpointsIndex{}, err
+	}
+	return SpentOutpointsIndex{
+		BlockHash: block.blockHash,
+		Data:      block.spentHashes,
+	}, nil
+}
+
+// GetMempoolTransactions requires getrawtransaction to include the prevouts (Bitcoin Core v25.0 or newer)
+func (c *ClientBitcoinCore) GetMempoolTransactions() ([]*MempoolTransaction, error) {
+	var txids []string
+	err := c.call("getrawmempool", &txids, false)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+
+	c.mempoolMu.Lock()
+	defer c.mempoolMu.Unlock()
+
+	processed := make(map[string]*MempoolTransaction, len(txids))
+	var txs []*MempoolTransaction
+	for _, txid := range txids {
+		tx, known := c.mempoolCache[txid]
+		if !known {
+			var rawTx rpcTx
+			err = c.call("getrawtransaction", &rawTx, txid, 2)
+			if err != nil {
+				var rpcErr *RpcError
+				if errors.As(err, &rpcErr) && rpcErr.Code == rpcErrInvalidAddressOrKey {
+					// left the mempool since getrawmempool was called
+					continue
+				}
+				logging.L.Err(err).Str("txid", txid).Msg("")
+				return nil, err
+			}
+			tx, err = processMempoolTransaction(&rawTx)
+			if err != nil {
+				logging.L.Err(err).Str("txid", txid).Msg("")
+				return nil, err
+			}
+		}
+		processed[txid] = tx
+		if tx != nil {
+			txs = append(txs, tx)
+		}
+	}
+	c.mempoolCache = processed
+
+	return txs, nil
+}
+
+// getBlock returns the processed block at blockHeight.
+// The hash is looked up on every call so a reorg never serves a stale block from the cache.
+func (c *ClientBitcoinCore) getBlock(blockHeight uint64) (*bitcoinCoreBlock, error) {
+	var blockHashStr string
+	err := c.call("getblockhash", &blockHashStr, blockHeight)
+	if err != nil {
+		logging.L.Err(err).Uint64("height", blockHeight).Msg("")
+		return nil, err
+	}
+
+	blockHashBytes, err := hex.DecodeString(blockHashStr)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+	blockHash := bip352.ConvertToFixedLength32(blockHashBytes)
+
+	c.cacheMu.Lock()
+	block, ok := c.cache[blockHash]
+	c.cacheMu.Unlock()
+	if ok {
+		return block, nil
+	}
+
+	var rawBlock rpcBlock
+	err = c.call("getblock", &rawBlock, blockHashStr, 3)
+	if err != nil {
+		logging.L.Err(err).Uint64("height", blockHeight).Msg("")
+		return nil, err
+	}
+
+	block, err = processBitcoinCoreBlock(&rawBlock)
+	if err != nil {
+		logging.L.Err(err).Uint64("height", blockHeight).Msg("")
+		return nil, err
+	}
+
+	c.cacheMu.Lock()
+	defer c.cacheMu.Unlock()
+	if c.cache == nil {
+		c.cache = map[[32]byte]*bitcoinCoreBlock{}
+	}
+	if _, ok = c.cache[blockHash]; !ok {
+		c.cache[blockHash] = block
+		c.cacheOrder = append(c.cacheOrder, blockHash)
+		if len(c.cacheOrder) > bitcoinCoreBlockCacheSize {
+			delete(c.cache, c.cacheOrder[0])
+			c.cacheOrder = c.cacheOrder[1:]
+		}
+	}
+
+	return block, nil
+}
+
+func processBitcoinCoreBlock(rawBlock *rpcBlock) (*bitcoinCoreBlock, error) {
+	blockHashBytes, err := hex.DecodeString(rawBlock.Hash)
+	if err != nil {
+		return nil, err
+	}
+
+	block := &bitcoinCoreBlock{
+		height:    rawBlock.Height,
+		blockHash: bip352.ConvertToFixedLength32(blockHashBytes),
+	}
+
+	// outpoints spent within this block, used to flag outputs that never made it into the utxo set
+	spentInBlock := make(map[Outpoint]struct{})
+	var taprootOutputs [][]byte
+	var spentValues [][]byte
+
+	for i := range rawBlock.Tx {
+		tx := &rawBlock.Tx[i]
+
+		tweak, highestValue, ok, err := computeTweak(tx)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			block.tweaks = append(block.tweaks, IndexedTweak{Tweak: tweak, HighestValue: highestValue})
+		}
+
+		for _, vin := range tx.Vin {
+			if vin.Coinbase != "" || vin.Prevout == nil {
+				continue
+			}
+			txidBytes, err := hex.DecodeString(vin.Txid)
+			if err != nil {
+				return nil, err
+			}
+			outpoint := Outpoint{Txid: bip352.ConvertToFixedLength32(txidBytes), Vout: vin.Vout}
+			spentInBlock[outpoint] = struct{}{}
+
+			// only taproot outputs can be silent payments, so the index only covers those
+			prevScript, err := hex.DecodeString(vin.Prevout.ScriptPubKey.Hex)
+			if err != nil {
+				return nil, err
+			}
+			if isTaprootScript(prevScript) {
+				hash := ComputeSpentOutpointHash(outpoint.Txid, outpoint.Vout, block.blockHash)
+				block.spentHashes = append(block.spentHashes, hash)
+				spentValues = append(spentValues, hash[:])
+			}
+		}
+	}
+
+	for i := range rawBlock.Tx {
+		tx := &rawBlock.Tx[i]
+		txidBytes, err := hex.DecodeString(tx.Txid)
+		if err != nil {
+			return nil, err
+		}
+		txid := bip352.ConvertToFixedLength32(txidBytes)
+
+		for _, vout := range tx.Vout {
+			script, err := hex.DecodeString(vout.ScriptPubKey.Hex)
+			if err != nil {
+				return nil, err
+			}
+			if !isTaprootScript(script) {
+				continue
+			}
+			amount, err := btcutil.NewAmount(vout.Value)
+			if err != nil {
+				return nil, err
+			}
+			_, spent := spentInBlock[Outpoint{Txid: txid, Vout: vout.N}]
+			block.utxos = append(block.utxos, &UTXOServed{
+				Txid:         txid,
+				Vout:         vout.N,
+				Amount:       uint64(amount),
+				ScriptPubKey: utils.ConvertToFixedLength34(script),
+				BlockHeight:  block.height,
+				BlockHash:    block.blockHash,
+				Timestamp:    rawBlock.Time,
+				Spent:        spent,
+			})
+			taprootOutputs = append(taprootOutputs, script[2:])
+		}
+	}
+
+	block.newUTXOsFilter, err = BuildFilter(block.blockHash, taprootOutputs)
+	if err != nil {
+		return nil, err
+	}
+	block.spentFilter, err = BuildFilter(block.blockHash, spentValues)
+	if err != nil {
+		return nil, err
+	}
+
+	return block, nil
+}
+
+// processMempoolTransaction returns nil if the transaction is not eligible for silent payments
+func processMempoolTransaction(rawTx *rpcTx) (*MempoolTransaction, error) {
+	tweak, highestValue, ok, err := computeTweak(rawTx)
+	if err != nil || !ok {
+		return nil, err
+	}
+
+	txidBytes, err := hex.DecodeString(rawTx.Txid)
+	if err != nil {
+		return nil, err
+	}
+
+	tx := &MempoolTransaction{
+		Txid:         bip352.ConvertToFixedLength32(txidBytes),
+		Tweak:        tweak,
+		HighestValue: highestValue,
+	}
+	for _, vout := range rawTx.Vout {
+		script, err := hex.DecodeString(vout.ScriptPubKey.Hex)
+		if err != nil {
+			return nil, err
+		}
+		if !isTaprootScript(script) {
+			continue
+		}
+		amount, err := btcutil.NewAmount(vout.Value)
+		if err != nil {
+			return nil, err
+		}
+		tx.Outputs = append(tx.Outputs, &UTXOServed{
+			Txid:         tx.Txid,
+			Vout:         vout.N,
+			Amount:       uint64(amount),
+			ScriptPubKey: utils.ConvertToFixedLength34(script),
+		})
+	}
+
+	return tx, nil
+}
+
+// computeTweak computes input_hash * A_sum for a transaction as defined in BIP352.
+// ok is false if the transaction is not eligible for silent payments.
+// highestValue is the value of the largest taproot output, needed for the dust limit.
+func computeTweak(tx *rpcTx) (tweak [33]byte, highestValue uint64, ok bool, err error) {
+	var hasTaprootOutput bool
+	for _, vout := range tx.Vout {
+		var script []byte
+		script, err = hex.DecodeString(vout.ScriptPubKey.Hex)
+		if err != nil {
+			return
+		}
+		if !isTaprootScript(script) {
+			continue
+		}
+		hasTaprootOutput = true
+		var amount btcutil.Amount
+		amount, err = btcutil.NewAmount(vout.Value)
+		if err != nil {
+			return
+		}
+		if uint64(amount) > highestValue {
+			highestValue = uint64(amount)
+		}
+	}
+	if !hasTaprootOutput {
+		return
+	}
+
+	vins := make([]*bip352.Vin, 0, len(tx.Vin))
+	for _, rawVin := range tx.Vin {
+		if rawVin.Coinbase != "" {
+			return
+		}
+		if rawVin.Prevout == nil {
+			err = fmt.Errorf("tx %s is missing prevout data", tx.Txid)
+			return
+		}
+
+		vin := &bip352.Vin{Vout: rawVin.Vout}
+		var txid []byte
+		txid, err = hex.DecodeString(rawVin.Txid)
+		if err != nil {
+			return
+		}
+		vin.Txid = bip352.ConvertToFixedLength32(txid)
+
+		vin.ScriptPubKey, err = hex.DecodeString(rawVin.Prevout.ScriptPubKey.Hex)
+		if err != nil {
+			return
+		}
+		if isUnknownWitnessVersion(vin.ScriptPubKey) {
+			// spending outputs of future segwit versions makes the transaction ineligible
+			return
+		}
+		vin.ScriptSig, err = hex.DecodeString(rawVin.ScriptSig.Hex)
+		if err != nil {
+			return
+		}
+		for _, item := range rawVin.TxInWitness {
+			var witnessItem []byte
+			witnessItem, err = hex.DecodeString(item)
+			if err != nil {
+				return
+			}
+			vin.Witness = append(vin.Witness, witnessItem)
+		}
+
+		vins = append(vins, vin)
+	}
+
+	var pubKeys [][33]byte
+	for _, vin := range vins {
+		// bip352.ExtractPubKey reads the last witness item of P2WPKH and P2SH-P2WPKH spends
+		// without checking the length and panics (index out of range) on an empty witness
+		if (isP2WPKHScript(vin.ScriptPubKey) || isP2SHP2WPKHSpend(vin.ScriptPubKey, vin.ScriptSig)) && len(vin.Witness) == 0 {
+			continue
+		}
+		pubKey, utxoType := bip352.ExtractPubKey(vin)
+		switch {
+		case utxoType == bip352.Unknown:
+			continue
+		case utxoType == bip352.P2TR:
+			pubKeys = append(pubKeys, bip352.ConvertToFixedLength33(append([]byte{0x02}, pubKey...)))
+		default:
+			pubKeys = append(pubKeys, bip352.ConvertToFixedLength33(pubKey))
+		}
+	}
+	if len(pubKeys) == 0 {
+		return
+	}
+
+	publicKeySum, sumErr := bip352.SumPublicKeys(pubKeys)
+	if sumErr != nil {
+		// the keys cancel each other out, nothing can be derived from this transaction
+		return
+	}
+
+	inputHash, err := bip352.ComputeInputHash(vins, publicKeySum)
+	if err != nil {
+		return
+	}
+
+	tweak, err = bip352.CreateSharedSecret(publicKeySum, inputHash, nil)
+	if err != nil {
+		return
+	}
+
+	ok = true
+	return
+}
+
+func isTaprootScript(script []byte) bool {
+	return len(script) == 34 && script[0] == 0x51 && script[1] == 0x20
+}
+
+func isP2WPKHScript(script []byte) bool {
+	return len(script) == 22 && script[0] == 0x00 && script[1] == 0x14
+}
+
+func isP2SHScript(script []byte) bool {
+	return len(script) == 23 && script[0] == 0xa9 && script[1] == 0x14 && script[22] == 0x87
+}
+
+// isP2SHP2WPKHSpend checks for a P2SH output whose scriptSig only pushes a P2WPKH redeem script
+func isP2SHP2WPKHSpend(scriptPubKey, scriptSig []byte) bool {
+	return isP2SHScript(scriptPubKey) && len(scriptSig) == 23 && scriptSig[0] == 0x16 && isP2WPKHScript(scriptSig[1:])
+}
+
+// isUnknownWitnessVersion checks for segwit outputs with a version above 1 (OP_2 to OP_16)
+func isUnknownWitnessVersion(script []byte) bool {
+	if len(script) < 4 || len(script) > 42 {
+		return false
+	}
+	return script[0] >= 0x52 && script[0] <= 0x60 && int(script[1]) == len(script)-2
+}
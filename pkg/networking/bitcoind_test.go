@@ -0,0 +1,338 @@
+package networking
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	"github.com/btcsuite/btcd/btcutil/gcs"
+	"github.com/btcsuite/btcd/btcutil/gcs/builder"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/setavenger/go-bip352"
+)
+
+// newStubBitcoinCore serves the blocks in the testdata files matching pattern over a minimal Bitcoin Core JSON-RPC interface.
+// synthetic_block_*.json are hand-written, regtest_block_*.json are recorded with testdata/record_regtest_block.sh.
+// bip352_vectors_block_*.json holds the transactions of the BIP352 receiving test vectors,
+// bip352_vectors_tweaks.json the tweaks the vectors expect for them in block order.
+func newStubBitcoinCore(t *testing.T, pattern, user, pass string) *httptest.Server {
+	t.Helper()
+
+	files, err := filepath.Glob(pattern)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocksByHash := map[string]json.RawMessage{}
+	hashesByHeight := map[uint64]string{}
+	var tip uint64
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var header struct {
+			Hash   string `json:"hash"`
+			Height uint64 `json:"height"`
+		}
+		if err = json.Unmarshal(data, &header); err != nil {
+			t.Fatal(err)
+		}
+		blocksByHash[header.Hash] = data
+		hashesByHeight[header.Height] = header.Hash
+		if header.Height > tip {
+			tip = header.Height
+		}
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != user || p != pass {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		var req struct {
+			Id     uint64            `json:"id"`
+			Method string            `json:"method"`
+			Params []json.RawMessage `json:"params"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		var result any
+		var rpcErr *RpcError
+		switch req.Method {
+		case "getblockcount":
+			result = tip
+		case "getblockhash":
+			var height uint64
+			_ = json.Unmarshal(req.Params[0], &height)
+			hash, ok := hashesByHeight[height]
+			if !ok {
+				rpcErr = &RpcError{Code: -8, Message: "Block height out of range"}
+				break
+			}
+			result = hash
+		case "getblock":
+			var hash string
+			_ = json.Unmarshal(req.Params[0], &hash)
+			block, ok := blocksByHash[hash]
+			if !ok {
+				rpcErr = &RpcError{Code: -5, Message: "Block not found"}
+				break
+			}
+			result = block
+		default:
+			rpcErr = &RpcError{Code: -32601, Message: "Method not found"}
+		}
+
+		if rpcErr != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+		}
+		_ = json.NewEncoder(w).Encode(map[string]any{"id": req.Id, "result": result, "error": rpcErr})
+	}))
+}
+
+func filterMatches(t *testing.T, filter *Filter, value []byte) bool {
+	t.Helper()
+	c := chainhash.Hash{}
+	if err := c.SetBytes(bip352.ReverseBytesCopy(filter.BlockHash[:])); err != nil {
+		t.Fatal(err)
+	}
+	f, err := gcs.FromNBytes(builder.DefaultP, builder.DefaultM, filter.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	match, err := f.Match(builder.DeriveKey(&c), value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return match
+}
+
+func TestClientBitcoinCore(t *testing.T) {
+	server := newStubBitcoinCore(t, "testdata/synthetic_block_*.json", "user", "pass")
+	defer server.Close()
+
+	client := NewClientBitcoinCore(server.URL, "user", "pass", "")
+
+	tip, err := client.GetChainTip()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tip != 101 {
+		t.Fatalf("expected chain tip 101, got %d", tip)
+	}
+
+	allTweaks, err := client.GetTweaks(101, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(allTweaks) != 2 {
+		t.Fatalf("expected 2 tweaks without dust limit, got %d", len(allTweaks))
+	}
+	tweaks, err := client.GetTweaks(101, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tweaks) != 1 {
+		t.Fatalf("expected 1 tweak with dust limit, got %d", len(tweaks))
+	}
+
+	utxos, err := client.GetUTXOs(101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(utxos) != 2 {
+		t.Fatalf("expected 2 taproot outputs, got %d", len(utxos))
+	}
+
+	// the tweak computed from the block has to let the receiver find the output the sender created
+	scanSecret := sha256.Sum256([]byte("scan"))
+	spendSecret := sha256.Sum256([]byte("spend"))
+	_, spendPub := btcec.PrivKeyFromBytes(spendSecret[:])
+	var outputs [][32]byte
+	for _, utxo := range utxos {
+		outputs = append(outputs, bip352.ConvertToFixedLength32(utxo.ScriptPubKey[2:]))
+	}
+	found, err := bip352.ReceiverScanTransaction(
+		scanSecret,
+		bip352.ConvertToFixedLength33(spendPub.SerializeCompressed()),
+		nil,
+		outputs,
+		tweaks[0],
+		nil,
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectedOutput, _ := hex.DecodeString("1e2da268bc32098d74363e2088f4ff604fe5f78015f781487346a308e80db284")
+	if len(found) != 1 || found[0].Output != bip352.ConvertToFixedLength32(expectedOutput) {
+		t.Fatalf("expected to find the silent payment output, found %d outputs", len(found))
+	}
+	for _, utxo := range utxos {
+		if utxo.Spent {
+			t.Errorf("output %x:%d should not be spent", utxo.Txid, utxo.Vout)
+		}
+		if utxo.BlockHeight != 101 || utxo.Timestamp != 1700000000 {
+			t.Errorf("unexpected block data on output %x:%d", utxo.Txid, utxo.Vout)
+		}
+	}
+
+	newUTXOsFilter, err := client.GetFilter(101, NewUTXOFilterType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !filterMatches(t, newUTXOsFilter, expectedOutput) {
+		t.Errorf("new utxos filter does not match the silent payment output")
+	}
+
+	// only the two taproot prevouts are part of the spent index
+	index, err := client.GetSpentOutpointsIndex(101)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(index.Data) != 2 || index.BlockHash != newUTXOsFilter.BlockHash {
+		t.Fatalf("expected 2 spent taproot outpoints, got %d", len(index.Data))
+	}
+	spentFilter, err := client.GetFilter(101, SpentOutpointsFilterType)
+	if err != nil {
+		t.Fatal(err)
+	}
+	spentTaproot := ComputeSpentOutpointHash(sha256.Sum256([]byte("prev-3")), 0, spentFilter.BlockHash)
+	if index.Data[1] != spentTaproot || !filterMatches(t, spentFilter, spentTaproot[:]) {
+		t.Errorf("spent taproot outpoint is missing from the spent index")
+	}
+	spentSegwit := ComputeSpentOutpointHash(sha256.Sum256([]byte("prev-1")), 1, spentFilter.BlockHash)
+	for _, hash := range index.Data {
+		if hash == spentSegwit {
+			t.Errorf("non taproot outpoint should not be in the spent index")
+		}
+	}
+
+	if _, err = client.GetTweaks(102, 0); err == nil {
+		t.Errorf("expected an error for a height above the chain tip")
+	}
+}
+
+func TestClientBitcoinCoreCookieAuth(t *testing.T) {
+	server := newStubBitcoinCore(t, "testdata/synthetic_block_*.json", "__cookie__", "secret")
+	defer server.Close()
+
+	cookiePath := filepath.Join(t.TempDir(), ".cookie")
+	if err := os.WriteFile(cookiePath, []byte("__cookie__:secret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewClientBitcoinCore(server.URL, "", "", cookiePath).GetChainTip(); err != nil {
+		t.Fatalf("cookie auth failed: %s", err)
+	}
+	if _, err := NewClientBitcoinCore(server.URL, "user", "wrong", "").GetChainTip(); err == nil {
+		t.Fatalf("expected wrong credentials to fail")
+	}
+}
+
+// TestClientBitcoinCoreRecordedBlocks checks that real getblock output of bitcoind is parsed
+func TestClientBitcoinCoreRecordedBlocks(t *testing.T) {
+	files, err := filepath.Glob("testdata/regtest_block_*.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Skip("no recorded regtest blocks, see testdata/record_regtest_block.sh")
+	}
+
+	server := newStubBitcoinCore(t, "testdata/regtest_block_*.json", "user", "pass")
+	defer server.Close()
+	client := NewClientBitcoinCore(server.URL, "user", "pass", "")
+
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var block rpcBlock
+		if err = json.Unmarshal(data, &block); err != nil {
+			t.Fatal(err)
+		}
+
+		tweaks, err := client.GetTweaks(block.Height, 0)
+		if err != nil {
+			t.Fatalf("%s: %s", file, err)
+		}
+		if len(tweaks) == 0 {
+			t.Errorf("%s: expected the recorded silent payment transactions to have tweaks", file)
+		}
+		if _, err = client.GetFilter(block.Height, SpentOutpointsFilterType); err != nil {
+			t.Errorf("%s: %s", file, err)
+		}
+	}
+}
+
+// TestClientBitcoinCoreBIP352Vectors checks the tweaks of all vectors, including P2PKH, P2WPKH, P2SH and P2TR inputs
+func TestClientBitcoinCoreBIP352Vectors(t *testing.T) {
+	data, err := os.ReadFile("testdata/bip352_vectors_tweaks.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var expected []struct {
+		Comment string `json:"comment"`
+		Tweak   string `json:"tweak"`
+	}
+	if err = json.Unmarshal(data, &expected); err != nil {
+		t.Fatal(err)
+	}
+
+	server := newStubBitcoinCore(t, "testdata/bip352_vectors_block_*.json", "user", "pass")
+	defer server.Close()
+	client := NewClientBitcoinCore(server.URL, "user", "pass", "")
+
+	tweaks, err := client.GetTweaks(102, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tweaks) != len(expected) {
+		t.Fatalf("expected %d tweaks, got %d", len(expected), len(tweaks))
+	}
+	for i, tweak := range tweaks {
+		if hex.EncodeToString(tweak[:]) != expected[i].Tweak {
+			t.Errorf("%s: expected tweak %s, got %x", expected[i].Comment, expected[i].Tweak, tweak)
+		}
+	}
+}
+
+func TestComputeTweakSkipsEmptyWitness(t *testing.T) {
+	// P2SH-P2WPKH and P2WPKH spends without witness next to a valid taproot spend
+	var tx rpcTx
+	err := json.Unmarshal([]byte(`{
+		"txid": "0000000000000000000000000000000000000000000000000000000000000000",
+		"vin": [
+			{"txid": "0101010101010101010101010101010101010101010101010101010101010101", "vout": 0, "scriptSig": {"hex": "160014468779c6899a3834fe1b9693babb5c6f0d36b7bf"},
+			 "prevout": {"value": 0.1, "scriptPubKey": {"hex": "a914b4a4ad36b9a3b1a35d1bc0d6c0a1d5b1f2e1c3d487"}}},
+			{"txid": "0202020202020202020202020202020202020202020202020202020202020202", "vout": 0, "scriptSig": {"hex": ""},
+			 "prevout": {"value": 0.1, "scriptPubKey": {"hex": "0014468779c6899a3834fe1b9693babb5c6f0d36b7bf"}}},
+			{"txid": "0303030303030303030303030303030303030303030303030303030303030303", "vout": 0, "scriptSig": {"hex": ""}, "txinwitness": ["00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000"],
+			 "prevout": {"value": 0.1, "scriptPubKey": {"hex": "512068409de5e4968d52ee185a42ed557d08c0904fb83efe839cfa659035dde6ab55"}}}
+		],
+		"vout": [{"n": 0, "value": 0.2, "scriptPubKey": {"hex": "51205529876bef05ab2af86af0886129095f75c5d66bba1d81daa42ca92c490ad7db"}}]
+	}`), &tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, ok, err := computeTweak(&tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !ok {
+		t.Errorf("expected a tweak from the taproot input")
+	}
+}
@@ -1,12 +1,11 @@
 package networking
 
 import (
+	"context"
 	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io"
-	"net/http"
 
 	"github.com/setavenger/blindbit-scan/pkg/logging"
 	"github.com/setavenger/blindbitd/src/utils"
@@ -26,6 +25,7 @@ const (
 
 type ClientBlindBit struct {
 	BaseUrl string
+	Oracle  *OracleClient // the default client with DefaultOracleClientOptions is used if nil
 }
 
 type Filter struct {
@@ -51,27 +51,37 @@ type SpentOutpointsIndex struct {
 	Data      [][8]byte `json:"data"`
 }
 
+// oracle returns the client which does the requests, the breaker state is shared by all requests of the client
+func (c ClientBlindBit) oracle() *OracleClient {
+	if c.Oracle == nil {
+		return defaultOracleClient
+	}
+	return c.Oracle
+}
+
+// SetContext ties the requests to ctx, cancelling it aborts in flight requests and retries
+func (c ClientBlindBit) SetContext(ctx context.Context) {
+	c.oracle().SetContext(ctx)
+}
+
+// GetTweaks uses the /tweaks endpoint which applies cut-through
 func (c ClientBlindBit) GetTweaks(blockHeight, dustLimit uint64) ([][33]byte, error) {
-	// todo add support for the /tweak-index/ endpoint
-	url := fmt.Sprintf("%s/tweaks/%d", c.BaseUrl, blockHeight)
+	return c.getTweaks("tweaks", blockHeight, dustLimit)
+}
+
+// GetTweakIndex uses the /tweak-index endpoint which serves all tweaks ever indexed for the block
+func (c ClientBlindBit) GetTweakIndex(blockHeight, dustLimit uint64) ([][33]byte, error) {
+	return c.getTweaks("tweak-index", blockHeight, dustLimit)
+}
+
+func (c ClientBlindBit) getTweaks(endpoint string, blockHeight, dustLimit uint64) ([][33]byte, error) {
+	url := fmt.Sprintf("%s/%s/%d", c.BaseUrl, endpoint, blockHeight)
 	if dustLimit > 0 {
 		url = fmt.Sprintf("%s?dustLimit=%d", url, dustLimit)
 	}
 
-	// HTTP GET request
-	resp, err := http.Get(url)
+	body, err := c.oracle().Get(endpoint, url, nil)
 	if err != nil {
-		logging.L.Err(err).Msg("")
-		return nil, err
-	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(resp.Body)
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logging.L.Err(err).Msg("")
 		return nil, err
 	}
 
@@ -108,19 +118,8 @@ func (c ClientBlindBit) GetTweaks(blockHeight, dustLimit uint64) ([][33]byte, er
 func (c ClientBlindBit) GetChainTip() (uint64, error) {
 	url := fmt.Sprintf("%s/block-height", c.BaseUrl)
 
-	// HTTP GET request
-	resp, err := http.Get(url)
+	body, err := c.oracle().Get("block-height", url, nil)
 	if err != nil {
-		logging.L.Err(err).Msg("")
-		return 0, err
-	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(resp.Body)
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logging.L.Err(err).Msg("")
 		return 0, err
 	}
 
@@ -139,19 +138,8 @@ func (c ClientBlindBit) GetChainTip() (uint64, error) {
 func (c ClientBlindBit) GetFilter(blockHeight uint64, filterType FilterType) (*Filter, error) {
 	url := fmt.Sprintf("%s/filter/%s/%d", c.BaseUrl, filterType, blockHeight)
 
-	// HTTP GET request
-	resp, err := http.Get(url)
+	body, err := c.oracle().Get("filter/"+string(filterType), url, nil)
 	if err != nil {
-		logging.L.Err(err).Msg("")
-		return nil, err
-	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(resp.Body)
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logging.L.Err(err).Msg("")
 		return nil, err
 	}
 
@@ -196,20 +184,8 @@ func (c ClientBlindBit) GetFilter(blockHeight uint64, filterType FilterType) (*F
 func (c ClientBlindBit) GetUTXOs(blockHeight uint64) ([]*UTXOServed, error) {
 	url := fmt.Sprintf("%s/utxos/%d", c.BaseUrl, blockHeight)
 
-	// HTTP GET request
-	resp, err := http.Get(url)
-	if err != nil {
-		logging.L.Err(err).Msg("")
-		return nil, err
-	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(resp.Body)
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
+	body, err := c.oracle().Get("utxos", url, nil)
 	if err != nil {
-		logging.L.Err(err).Msg("")
 		return nil, err
 	}
 
@@ -271,20 +247,8 @@ func (c ClientBlindBit) GetUTXOs(blockHeight uint64) ([]*UTXOServed, error) {
 func (c ClientBlindBit) GetSpentOutpointsIndex(blockHeight uint64) (SpentOutpointsIndex, error) {
 	url := fmt.Sprintf("%s/spent-index/%d", c.BaseUrl, blockHeight)
 
-	// HTTP GET request
-	resp, err := http.Get(url)
+	body, err := c.oracle().Get("spent-index", url, nil)
 	if err != nil {
-		logging.L.Err(err).Msg("")
-		return SpentOutpointsIndex{}, err
-	}
-	defer func(Body io.ReadCloser) {
-		_ = Body.Close()
-	}(resp.Body)
-
-	// Read response body
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		logging.L.Err(err).Msg("")
 		return SpentOutpointsIndex{}, err
 	}
 
@@ -0,0 +1,42 @@
+package networking
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/btcsuite/btcd/btcutil/gcs/builder"
+	"github.com/btcsuite/btcd/chaincfg/chainhash"
+	"github.com/setavenger/go-bip352"
+)
+
+// BuildFilter builds a BIP158 style filter over values keyed with the block hash.
+// Returns the filter in the same N||data encoding the indexing server serves.
+func BuildFilter(blockHash [32]byte, values [][]byte) ([]byte, error) {
+	c := chainhash.Hash{}
+	err := c.SetBytes(bip352.ReverseBytesCopy(blockHash[:]))
+	if err != nil {
+		return nil, err
+	}
+
+	filter, err := builder.WithKey(builder.DeriveKey(&c)).AddEntries(values).Build()
+	if err != nil {
+		return nil, err
+	}
+
+	return filter.NBytes()
+}
+
+// ComputeSpentOutpointHash computes the short hash under which an outpoint is listed in the spent outpoints index.
+// txid is expected in the human-readable byte order.
+func ComputeSpentOutpointHash(txid [32]byte, vout uint32, blockHash [32]byte) [8]byte {
+	var buf bytes.Buffer
+	buf.Write(bip352.ReverseBytesCopy(txid[:]))
+	_ = binary.Write(&buf, binary.LittleEndian, vout)
+	buf.Write(bip352.ReverseBytesCopy(blockHash[:]))
+
+	hashed := sha256.Sum256(buf.Bytes())
+	var shortHash [8]byte
+	copy(shortHash[:], hashed[:])
+	return shortHash
+}
@@ -0,0 +1,194 @@
+package networking
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// IndexedTweak is a tweak together with the value of the largest taproot output of its transaction,
+// needed to apply the dust limit the same way the indexing server does.
+type IndexedTweak struct {
+	Tweak        [33]byte
+	HighestValue uint64
+	Spent        bool // all taproot outputs of the transaction are spent, such tweaks are removed by cut-through
+}
+
+// Outpoint references a transaction output. Txid is in the human-readable byte order.
+type Outpoint struct {
+	Txid [32]byte
+	Vout uint32
+}
+
+// FixtureBlock is the data a FixtureBackend serves for a single height
+type FixtureBlock struct {
+	BlockHash      [32]byte
+	Tweaks         []IndexedTweak
+	UTXOs          []*UTXOServed
+	SpentOutpoints []Outpoint
+}
+
+// FixtureBackend is a deterministic in-memory IndexBackend.
+// Heights up to the chain tip without an explicit block are served as empty blocks
+// with a hash derived from the height. Meant for tests and offline use.
+type FixtureBackend struct {
+	mu       sync.RWMutex
+	blocks   map[uint64]*FixtureBlock
+	chainTip uint64
+	mempool  []*MempoolTransaction
+}
+
+func NewFixtureBackend() *FixtureBackend {
+	return &FixtureBackend{
+		blocks: map[uint64]*FixtureBlock{},
+	}
+}
+
+// FixtureBlockHash is the hash of the empty block a FixtureBackend serves for heights without explicit data
+func FixtureBlockHash(blockHeight uint64) [32]byte {
+	var buf [15]byte
+	copy(buf[:], "fixture")
+	binary.BigEndian.PutUint64(buf[7:], blockHeight)
+	return sha256.Sum256(buf[:])
+}
+
+// SetBlock stores the block for the height and moves the chain tip up if needed
+func (f *FixtureBackend) SetBlock(blockHeight uint64, block *FixtureBlock) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocks[blockHeight] = block
+	if blockHeight > f.chainTip {
+		f.chainTip = blockHeight
+	}
+}
+
+// SetChainTip sets the chain tip. Blocks above the new tip are dropped, which allows simulating reorgs.
+func (f *FixtureBackend) SetChainTip(blockHeight uint64) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for height := range f.blocks {
+		if height > blockHeight {
+			delete(f.blocks, height)
+		}
+	}
+	f.chainTip = blockHeight
+}
+
+// SetMempool replaces the transactions which are currently in the mempool
+func (f *FixtureBackend) SetMempool(txs []*MempoolTransaction) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.mempool = txs
+}
+
+func (f *FixtureBackend) getBlock(blockHeight uint64) (*FixtureBlock, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	if blockHeight > f.chainTip {
+		return nil, fmt.Errorf("fixture: height %d is above chain tip %d", blockHeight, f.chainTip)
+	}
+	block, ok := f.blocks[blockHeight]
+	if !ok {
+		return &FixtureBlock{BlockHash: FixtureBlockHash(blockHeight)}, nil
+	}
+	return block, nil
+}
+
+// GetTweaks applies cut-through, tweaks marked as spent are not served
+func (f *FixtureBackend) GetTweaks(blockHeight, dustLimit uint64) ([][33]byte, error) {
+	return f.getTweaks(blockHeight, dustLimit, true)
+}
+
+func (f *FixtureBackend) GetTweakIndex(blockHeight, dustLimit uint64) ([][33]byte, error) {
+	return f.getTweaks(blockHeight, dustLimit, false)
+}
+
+func (f *FixtureBackend) getTweaks(blockHeight, dustLimit uint64, cutThrough bool) ([][33]byte, error) {
+	block, err := f.getBlock(blockHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	var tweaks [][33]byte
+	for _, tweak := range block.Tweaks {
+		if dustLimit > 0 && tweak.HighestValue < dustLimit {
+			continue
+		}
+		if cutThrough && tweak.Spent {
+			continue
+		}
+		tweaks = append(tweaks, tweak.Tweak)
+	}
+	return tweaks, nil
+}
+
+func (f *FixtureBackend) GetUTXOs(blockHeight uint64) ([]*UTXOServed, error) {
+	block, err := f.getBlock(blockHeight)
+	if err != nil {
+		return nil, err
+	}
+	return block.UTXOs, nil
+}
+
+func (f *FixtureBackend) GetFilter(blockHeight uint64, filterType FilterType) (*Filter, error) {
+	block, err := f.getBlock(blockHeight)
+	if err != nil {
+		return nil, err
+	}
+
+	var values [][]byte
+	var filterTypeNum uint8
+	switch filterType {
+	case NewUTXOFilterType:
+		filterTypeNum = 1
+		for _, utxo := range block.UTXOs {
+			values = append(values, utxo.ScriptPubKey[2:])
+		}
+	case SpentOutpointsFilterType:
+		filterTypeNum = 2
+		for _, outpoint := range block.SpentOutpoints {
+			hash := ComputeSpentOutpointHash(outpoint.Txid, outpoint.Vout, block.BlockHash)
+			values = append(values, hash[:])
+		}
+	default:
+		return nil, fmt.Errorf("fixture: unknown filter type %s", filterType)
+	}
+
+	data, err := BuildFilter(block.BlockHash, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Filter{
+		FilterType:  filterTypeNum,
+		BlockHeight: blockHeight,
+		BlockHash:   block.BlockHash,
+		Data:        data,
+	}, nil
+}
+
+func (f *FixtureBackend) GetSpentOutpointsIndex(blockHeight uint64) (SpentOutpointsIndex, error) {
+	block, err := f.getBlock(blockHeight)
+	if err != nil {
+		return SpentOutpointsIndex{}, err
+	}
+
+	index := SpentOutpointsIndex{BlockHash: block.BlockHash}
+	for _, outpoint := range block.SpentOutpoints {
+		index.Data = append(index.Data, ComputeSpentOutpointHash(outpoint.Txid, outpoint.Vout, block.BlockHash))
+	}
+	return index, nil
+}
+
+func (f *FixtureBackend) GetChainTip() (uint64, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.chainTip, nil
+}
+
+func (f *FixtureBackend) GetMempoolTransactions() ([]*MempoolTransaction, error) {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.mempool, nil
+}
@@ -0,0 +1,271 @@
+package networking
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+)
+
+// ErrOracleDisagreement is returned in paranoid mode if the servers serve different data for the same height.
+// At least one of them is broken or lying, scanning must not continue.
+var ErrOracleDisagreement = errors.New("oracle servers disagree")
+
+// MaxChainTipSpread is how far the chain tips of the servers may be apart in paranoid mode,
+// servers need a moment to index a new block.
+const MaxChainTipSpread = 6
+
+// DisagreementRechecks is how often paranoid mode asks all servers again before it reports a disagreement.
+// Near the tip servers can briefly serve different blocks after a reorg or while indexing a new block.
+const DisagreementRechecks = 3
+
+// DefaultRecheckDelay is how long paranoid mode waits before asking the servers again
+const DefaultRecheckDelay = 10 * time.Second
+
+// OracleServer is one of the servers of a MultiBackend
+type OracleServer struct {
+	Name    string // used in logs and errors, e.g. the url
+	Backend IndexBackend
+}
+
+// MultiBackend spreads the requests over several oracle servers.
+// By default it fails over: requests go to the last server which answered and the next ones are tried on errors.
+// In paranoid mode every request goes to all servers and the answers are compared,
+// a single server can then neither hide payments by omitting tweaks nor fake spends.
+type MultiBackend struct {
+	servers  []OracleServer
+	paranoid bool
+
+	// RecheckDelay is the wait before servers which disagree are asked again, DefaultRecheckDelay by default
+	RecheckDelay time.Duration
+
+	mu      sync.Mutex
+	current int             // server which answered the last request
+	ctx     context.Context // cancels waiting for a recheck
+}
+
+func NewMultiBackend(servers []OracleServer, paranoid bool) *MultiBackend {
+	return &MultiBackend{
+		servers:      servers,
+		paranoid:     paranoid,
+		RecheckDelay: DefaultRecheckDelay,
+		ctx:          context.Background(),
+	}
+}
+
+// GetTweaks returns the cut-through tweaks in failover mode.
+// Cut-through depends on the tip a server has indexed, so paranoid mode compares the full tweak index instead.
+func (m *MultiBackend) GetTweaks(blockHeight, dustLimit uint64) ([][33]byte, error) {
+	if m.paranoid {
+		return m.GetTweakIndex(blockHeight, dustLimit)
+	}
+	return failover(m, func(b IndexBackend) ([][33]byte, error) {
+		return b.GetTweaks(blockHeight, dustLimit)
+	})
+}
+
+func (m *MultiBackend) GetTweakIndex(blockHeight, dustLimit uint64) ([][33]byte, error) {
+	return query(m, fmt.Sprintf("tweaks of height %d", blockHeight), func(b IndexBackend) ([][33]byte, error) {
+		return b.GetTweakIndex(blockHeight, dustLimit)
+	}, sameTweaks)
+}
+
+func (m *MultiBackend) GetUTXOs(blockHeight uint64) ([]*UTXOServed, error) {
+	return query(m, fmt.Sprintf("utxos of height %d", blockHeight), func(b IndexBackend) ([]*UTXOServed, error) {
+		return b.GetUTXOs(blockHeight)
+	}, sameUTXOs)
+}
+
+func (m *MultiBackend) GetFilter(blockHeight uint64, filterType FilterType) (*Filter, error) {
+	return query(m, fmt.Sprintf("%s filter of height %d", filterType, blockHeight), func(b IndexBackend) (*Filter, error) {
+		return b.GetFilter(blockHeight, filterType)
+	}, func(a, b *Filter) bool {
+		return a.BlockHash == b.BlockHash && a.FilterType == b.FilterType && bytes.Equal(a.Data, b.Data)
+	})
+}
+
+func (m *MultiBackend) GetSpentOutpointsIndex(blockHeight uint64) (SpentOutpointsIndex, error) {
+	return query(m, fmt.Sprintf("spent index of height %d", blockHeight), func(b IndexBackend) (SpentOutpointsIndex, error) {
+		return b.GetSpentOutpointsIndex(blockHeight)
+	}, func(a, b SpentOutpointsIndex) bool {
+		return a.BlockHash == b.BlockHash && sameSet(a.Data, b.Data, func(x, y [8]byte) int { return bytes.Compare(x[:], y[:]) })
+	})
+}
+
+// GetChainTip returns the lowest chain tip in paranoid mode, every height below it can be compared
+func (m *MultiBackend) GetChainTip() (uint64, error) {
+	if !m.paranoid {
+		return failover(m, IndexBackend.GetChainTip)
+	}
+
+	tips, err := compare(m, IndexBackend.GetChainTip, func(tips []uint64) error {
+		lowest, highest := slices.Min(tips), slices.Max(tips)
+		if highest-lowest > MaxChainTipSpread {
+			return fmt.Errorf("%w: chain tips %s %d and %s %d are more than %d blocks apart",
+				ErrOracleDisagreement,
+				m.servers[slices.Index(tips, lowest)].Name, lowest,
+				m.servers[slices.Index(tips, highest)].Name, highest,
+				MaxChainTipSpread,
+			)
+		}
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+	return slices.Min(tips), nil
+}
+
+func (m *MultiBackend) SetContext(ctx context.Context) {
+	m.mu.Lock()
+	m.ctx = ctx
+	m.mu.Unlock()
+	for _, server := range m.servers {
+		if backend, ok := server.Backend.(ContextBackend); ok {
+			backend.SetContext(ctx)
+		}
+	}
+}
+
+// query fails over or compares the answers of all servers in paranoid mode
+func query[T any](m *MultiBackend, what string, call func(IndexBackend) (T, error), equal func(a, b T) bool) (T, error) {
+	if !m.paranoid {
+		return failover(m, call)
+	}
+
+	results, err := compare(m, call, func(results []T) error {
+		for i := 1; i < len(results); i++ {
+			if !equal(results[0], results[i]) {
+				return fmt.Errorf("%w: %s differ between %s and %s",
+					ErrOracleDisagreement, what, m.servers[0].Name, m.servers[i].Name,
+				)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+	return results[0], nil
+}
+
+// compare queries all servers until check accepts their answers.
+// A disagreement is only returned if it persists over DisagreementRechecks rechecks RecheckDelay apart.
+func compare[T any](m *MultiBackend, call func(IndexBackend) (T, error), check func([]T) error) ([]T, error) {
+	m.mu.Lock()
+	ctx := m.ctx
+	m.mu.Unlock()
+
+	for recheck := 0; ; recheck++ {
+		results, err := all(m, call)
+		if err != nil {
+			return nil, err
+		}
+		err = check(results)
+		if err == nil {
+			return results, nil
+		}
+		if recheck == DisagreementRechecks {
+			return nil, err
+		}
+
+		logging.L.Warn().Err(err).Dur("delay", m.RecheckDelay).Msg("asking the oracle servers again")
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(m.RecheckDelay):
+		}
+	}
+}
+
+// failover starts with the server which answered last and tries all others in order if it fails
+func failover[T any](m *MultiBackend, call func(IndexBackend) (T, error)) (T, error) {
+	m.mu.Lock()
+	start := m.current
+	m.mu.Unlock()
+
+	var errs []error
+	for i := range m.servers {
+		idx := (start + i) % len(m.servers)
+		server := m.servers[idx]
+
+		result, err := call(server.Backend)
+		if err == nil {
+			if idx != start {
+				m.mu.Lock()
+				m.current = idx
+				m.mu.Unlock()
+				logging.L.Warn().Str("server", server.Name).Msg("failed over to oracle server")
+			}
+			return result, nil
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", server.Name, err))
+	}
+
+	var zero T
+	return zero, errors.Join(errs...)
+}
+
+// all queries every server concurrently, it fails if any of them fails
+func all[T any](m *MultiBackend, call func(IndexBackend) (T, error)) ([]T, error) {
+	results := make([]T, len(m.servers))
+	errs := make([]error, len(m.servers))
+
+	var wg sync.WaitGroup
+	for i, server := range m.servers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			results[i], errs[i] = call(server.Backend)
+			if errs[i] != nil {
+				errs[i] = fmt.Errorf("%s: %w", server.Name, errs[i])
+			}
+		}()
+	}
+	wg.Wait()
+
+	err := errors.Join(errs...)
+	if err != nil {
+		return nil, err
+	}
+	return results, nil
+}
+
+func sameTweaks(a, b [][33]byte) bool {
+	return sameSet(a, b, func(x, y [33]byte) int { return bytes.Compare(x[:], y[:]) })
+}
+
+// sameUTXOs ignores the spent flag, it depends on the tip a server has indexed
+func sameUTXOs(a, b []*UTXOServed) bool {
+	strip := func(utxos []*UTXOServed) []UTXOServed {
+		stripped := make([]UTXOServed, len(utxos))
+		for i, utxo := range utxos {
+			stripped[i] = *utxo
+			stripped[i].Spent = false
+		}
+		return stripped
+	}
+	return sameSet(strip(a), strip(b), func(x, y UTXOServed) int {
+		if c := bytes.Compare(x.Txid[:], y.Txid[:]); c != 0 {
+			return c
+		}
+		return int(x.Vout) - int(y.Vout)
+	})
+}
+
+// sameSet compares a and b ignoring the order, the slices are not modified
+func sameSet[T comparable](a, b []T, cmp func(x, y T) int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	a, b = slices.Clone(a), slices.Clone(b)
+	slices.SortFunc(a, cmp)
+	slices.SortFunc(b, cmp)
+	return slices.Equal(a, b)
+}
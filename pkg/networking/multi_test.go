@@ -0,0 +1,101 @@
+package networking
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestMultiBackend(t *testing.T) {
+	hash := FixtureBlockHash(5)
+	utxo := &UTXOServed{Txid: [32]byte{1}, Amount: 10_000, BlockHeight: 5, BlockHash: hash}
+	spentUTXO := *utxo
+	spentUTXO.Spent = true
+
+	honest := NewFixtureBackend()
+	honest.SetBlock(5, &FixtureBlock{
+		BlockHash: hash,
+		Tweaks:    []IndexedTweak{{Tweak: [33]byte{2, 1}, HighestValue: 10_000}},
+		UTXOs:     []*UTXOServed{utxo},
+	})
+	// knows the utxo is spent by now, which is no disagreement
+	lying := NewFixtureBackend()
+	lying.SetBlock(5, &FixtureBlock{
+		BlockHash: hash,
+		UTXOs:     []*UTXOServed{&spentUTXO},
+	})
+	lagging := NewFixtureBackend()
+	lagging.SetChainTip(3)
+
+	servers := func(backends ...*FixtureBackend) []OracleServer {
+		var servers []OracleServer
+		for i, backend := range backends {
+			servers = append(servers, OracleServer{Name: string(rune('a' + i)), Backend: backend})
+		}
+		return servers
+	}
+
+	failover := NewMultiBackend(servers(lagging, honest), false)
+	tweaks, err := failover.GetTweaks(5, 0)
+	if err != nil || len(tweaks) != 1 {
+		t.Fatalf("expected the tweak from the second server, got %d (%v)", len(tweaks), err)
+	}
+	if failover.current != 1 {
+		t.Errorf("expected the next request to go to the second server")
+	}
+
+	paranoid := NewMultiBackend(servers(honest, lying), true)
+	paranoid.RecheckDelay = time.Millisecond
+	if _, err = paranoid.GetUTXOs(5); err != nil {
+		t.Errorf("expected the spent flag to be ignored, got %v", err)
+	}
+	if _, err = paranoid.GetTweaks(5, 0); !errors.Is(err, ErrOracleDisagreement) {
+		t.Errorf("expected a disagreement on the tweaks, got %v", err)
+	}
+
+	honest.SetChainTip(3 + MaxChainTipSpread + 1)
+	paranoid = NewMultiBackend(servers(honest, lagging), true)
+	paranoid.RecheckDelay = time.Millisecond
+	if _, err = paranoid.GetChainTip(); !errors.Is(err, ErrOracleDisagreement) {
+		t.Errorf("expected a disagreement on the chain tip, got %v", err)
+	}
+}
+
+// staleOnceBackend answers the first filter request from stale, like a server which did not follow a reorg yet
+type staleOnceBackend struct {
+	IndexBackend
+	stale IndexBackend
+	asked bool
+}
+
+func (b *staleOnceBackend) GetFilter(blockHeight uint64, filterType FilterType) (*Filter, error) {
+	if !b.asked {
+		b.asked = true
+		return b.stale.GetFilter(blockHeight, filterType)
+	}
+	return b.IndexBackend.GetFilter(blockHeight, filterType)
+}
+
+func TestMultiBackendRechecksTipDisagreement(t *testing.T) {
+	first := NewFixtureBackend()
+	first.SetChainTip(8)
+	second := NewFixtureBackend()
+	second.SetChainTip(8)
+	// the second server still serves the orphaned block 8 on the first request
+	orphaned := NewFixtureBackend()
+	orphaned.SetBlock(8, &FixtureBlock{BlockHash: [32]byte{8}})
+
+	paranoid := NewMultiBackend([]OracleServer{
+		{Name: "a", Backend: first},
+		{Name: "b", Backend: &staleOnceBackend{IndexBackend: second, stale: orphaned}},
+	}, true)
+	paranoid.RecheckDelay = time.Millisecond
+
+	filter, err := paranoid.GetFilter(8, NewUTXOFilterType)
+	if err != nil {
+		t.Fatalf("expected the servers to agree on recheck, got %v", err)
+	}
+	if filter.BlockHash != FixtureBlockHash(8) {
+		t.Errorf("expected the block of the new chain")
+	}
+}
@@ -1,6 +1,13 @@
 package nwc
 
-import "encoding/json"
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// PermissionSpendData allows an app to receive the private key tweaks of utxos via list_utxos
+const PermissionSpendData = "spend_data"
 
 // Apps maps client pubkey to wallet key data
 type Apps map[string]AppsItem
@@ -10,9 +17,40 @@ type AppsItem struct {
 	WalletPriv string
 	WalletPub  string
 	ClientPub  string
+	// Permissions beyond the read only default, see PermissionSpendData
+	Permissions []string `json:",omitempty"`
 	// Potentially add metadata
 }
 
+func (a *AppsItem) HasPermission(permission string) bool {
+	for _, p := range a.Permissions {
+		if p == permission {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidatePermissions checks that only known permissions are requested
+func ValidatePermissions(permissions []string) error {
+	for _, p := range permissions {
+		switch p {
+		case PermissionSpendData:
+		default:
+			return fmt.Errorf("invalid permission: (%s)", p)
+		}
+	}
+	return nil
+}
+
+type appContextKey struct{}
+
+// AppFromContext returns the app which sent the request a handler is called for, nil if unknown
+func AppFromContext(ctx context.Context) *AppsItem {
+	app, _ := ctx.Value(appContextKey{}).(*AppsItem)
+	return app
+}
+
 func (ks Apps) FindByClientPub(pub string) *AppsItem {
 	if item, ok := ks[pub]; ok {
 		return &item
@@ -42,10 +42,38 @@ type GetInfoResponseBody struct {
 	Network     string   `json:"network"`
 	BlockHeight int      `json:"block_height"`
 	Methods     []string `json:"methods"`
+	Status      any      `json:"status,omitempty"` // sync status of the scanner, same as GET /status
 }
 
+// ListUtxosRequestBody takes the same filters as GET /utxos
+type ListUtxosRequestBody = wallet.UTXOQuery
+
 type ListUtxosResponseBody struct {
-	Utxos wallet.UtxoCollection `json:"utxos"`
+	Utxos      wallet.UtxoCollection `json:"utxos"`
+	NextCursor string                `json:"next_cursor,omitempty"`
+}
+
+// ListTransactionsRequestBody are the list_transactions params, from and until are unix timestamps
+type ListTransactionsRequestBody struct {
+	From   uint64 `json:"from,omitempty"`
+	Until  uint64 `json:"until,omitempty"`
+	Limit  int    `json:"limit,omitempty"`
+	Offset int    `json:"offset,omitempty"`
+	Type   string `json:"type,omitempty"` // incoming or outgoing, both if empty
+}
+
+type ListTransactionsResponseBody struct {
+	Transactions []Transaction `json:"transactions"`
+}
+
+// Transaction follows the NIP-47 transaction format, the on-chain details are part of the metadata
+type Transaction struct {
+	Type      string              `json:"type"`
+	Amount    int64               `json:"amount"` // in millisatoshis
+	FeesPaid  int64               `json:"fees_paid"`
+	CreatedAt int64               `json:"created_at"`
+	SettledAt *int64              `json:"settled_at,omitempty"` // only set once confirmed
+	Metadata  *wallet.Transaction `json:"metadata"`
 }
 
 // marshals into the passed request struct
@@ -10,6 +10,7 @@ import (
 	"github.com/nbd-wtf/go-nostr"
 	"github.com/nbd-wtf/go-nostr/nip04"
 	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/metrics"
 )
 
 const (
@@ -72,7 +73,7 @@ func (c *Nip47Controller) RegisterHandler(
 // must return the raw marshalled response as byte slice for later encrypting and publishing
 type Nip47ControllerHandlerFunc func(context.Context, Nip47Request) ([]byte, error)
 
-func (c *Nip47Controller) NewConnection() (
+func (c *Nip47Controller) NewConnection(permissions ...string) (
 	pubKeyWalletService string,
 	secretClient string,
 	err error,
@@ -90,9 +91,10 @@ func (c *Nip47Controller) NewConnection() (
 	}
 
 	newKeystore := AppsItem{
-		WalletPriv: privKeyWalletService,
-		WalletPub:  pubKeyWalletService,
-		ClientPub:  pubKeyClient,
+		WalletPriv:  privKeyWalletService,
+		WalletPub:   pubKeyWalletService,
+		ClientPub:   pubKeyClient,
+		Permissions: permissions,
 	}
 
 	err = c.PublishInfoEvent(c.relay, privKeyWalletService, pubKeyWalletService)
@@ -113,8 +115,8 @@ func (c *Nip47Controller) StopListening() {
 }
 
 // NewConnectionUri calls NewConnection but simply returns the uri and a possible error
-func (c *Nip47Controller) NewConnectionUri() (uri string, err error) {
-	pubKeyWalletService, clientSecret, err := c.NewConnection()
+func (c *Nip47Controller) NewConnectionUri(permissions ...string) (uri string, err error) {
+	pubKeyWalletService, clientSecret, err := c.NewConnection(permissions...)
 	uri = fmt.Sprintf(
 		"nostr+walletconnect://%s?relay=%s&secret=%s",
 		pubKeyWalletService, relayURL, clientSecret,
@@ -189,6 +191,7 @@ func (c *Nip47Controller) processEvent(ev *nostr.Event) {
 		// 	app, ev, req.Method, "NOT_IMPLEMENTED",
 		// 	fmt.Errorf("method not implemented: %s", req.Method),
 		// )
+		c.countRequest("", "decrypt_error")
 		return
 	}
 
@@ -200,6 +203,7 @@ func (c *Nip47Controller) processEvent(ev *nostr.Event) {
 			app, ev, req.Method, "UNAUTHORIZED",
 			fmt.Errorf("no app for: %s", ev.PubKey),
 		)
+		c.countRequest(req.Method, "unauthorized")
 		return
 	}
 
@@ -211,19 +215,32 @@ func (c *Nip47Controller) processEvent(ev *nostr.Event) {
 			app, ev, req.Method, "NOT_IMPLEMENTED",
 			fmt.Errorf("method not implemented: %s", req.Method),
 		)
+		c.countRequest(req.Method, "not_implemented")
 		return
 	}
 
 	// Execute the handler to get the response bytes.
-	respData, err := handlerFunc(c.ctx, req)
+	// The app is passed along so handlers can check its permissions.
+	respData, err := handlerFunc(context.WithValue(c.ctx, appContextKey{}, app), req)
 	if err != nil {
 		logging.L.Err(err).Any("request", req).Msg("error in handlerFunc")
 		c.publishErrorResponse(app, ev, req.Method, "INTERNAL", err)
+		c.countRequest(req.Method, "error")
 		return
 	}
 
 	// Publish the response.
 	c.publishResponse(app, ev, respData)
+	c.countRequest(req.Method, "ok")
+}
+
+// countRequest records the request in the metrics.
+// Methods without a handler are counted as other so clients can't create arbitrary labels.
+func (c *Nip47Controller) countRequest(method, outcome string) {
+	if _, ok := c.handlers[method]; !ok {
+		method = "other"
+	}
+	metrics.NWCRequests.WithLabelValues(method, outcome).Inc()
 }
 
 func (c Nip47Controller) DecryptEvent(ev *nostr.Event) (req Nip47Request, err error) {
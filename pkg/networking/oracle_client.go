@@ -0,0 +1,247 @@
+package networking
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+	"github.com/setavenger/blindbit-scan/pkg/metrics"
+)
+
+// ErrCircuitOpen is returned without contacting the oracle while the circuit breaker is open
+var ErrCircuitOpen = errors.New("oracle circuit breaker is open")
+
+// HTTPStatusError is returned for responses of the oracle with a non-2xx status
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+	Body       string // truncated
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s: %s: %s", e.URL, e.Status, e.Body)
+}
+
+// Retryable reports whether the same request can succeed later, e.g. 503 while the oracle restarts
+func (e *HTTPStatusError) Retryable() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500
+}
+
+// OracleClientOptions configures the requests to the oracle
+type OracleClientOptions struct {
+	Timeout          time.Duration // per attempt, including reading the body
+	Retries          int           // additional attempts for network errors, 429 and 5xx
+	MinBackoff       time.Duration // doubled for every retry, with up to 50% jitter
+	MaxBackoff       time.Duration
+	BreakerThreshold int           // consecutive failed requests which open the breaker, 0 disables it
+	BreakerCooldown  time.Duration // how long the breaker stays open until a single probe request is let through
+	Proxy            string        // SOCKS5 proxy (host:port) all requests go through, direct connections if empty
+}
+
+func DefaultOracleClientOptions() OracleClientOptions {
+	return OracleClientOptions{
+		Timeout:          30 * time.Second,
+		Retries:          3,
+		MinBackoff:       500 * time.Millisecond,
+		MaxBackoff:       10 * time.Second,
+		BreakerThreshold: 5,
+		BreakerCooldown:  30 * time.Second,
+	}
+}
+
+// OracleClient does the HTTP requests to the oracle. All requests are GETs and therefore retried.
+// It is shared by the copies of ClientBlindBit, the breaker state is per client.
+type OracleClient struct {
+	opts   OracleClientOptions
+	client *http.Client
+
+	mu  sync.Mutex
+	ctx context.Context
+
+	// breaker
+	failures  int // consecutive failed requests
+	openUntil time.Time
+	probing   bool // a probe request is in flight while half open
+}
+
+func NewOracleClient(opts OracleClientOptions) *OracleClient {
+	client := &http.Client{}
+	if opts.Proxy != "" {
+		client.Transport = newSOCKS5Transport(opts.Proxy)
+	}
+	return &OracleClient{
+		opts:   opts,
+		client: client,
+		ctx:    context.Background(),
+	}
+}
+
+// defaultOracleClient is used by a ClientBlindBit without an OracleClient
+var defaultOracleClient = NewOracleClient(DefaultOracleClientOptions())
+
+// SetContext sets the context for all following requests. Cancelling it aborts in flight requests and retries.
+func (c *OracleClient) SetContext(ctx context.Context) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ctx = ctx
+}
+
+// Get requests url and returns the body of a 2xx response.
+// endpoint is the path without the height, it labels the request metrics.
+// Failed attempts are only logged at debug level, the caller gets the last error.
+func (c *OracleClient) Get(endpoint, url string, header http.Header) ([]byte, error) {
+	c.mu.Lock()
+	ctx := c.ctx
+	c.mu.Unlock()
+
+	if err := c.allow(); err != nil {
+		return nil, err
+	}
+
+	var body []byte
+	var err error
+	for attempt := 0; ; attempt++ {
+		body, err = c.get(ctx, endpoint, url, header)
+		if err == nil || ctx.Err() != nil || !retryable(err) || attempt >= c.opts.Retries {
+			break
+		}
+
+		wait := c.backoff(attempt)
+		logging.L.Debug().Err(err).Str("url", url).Int("attempt", attempt+1).Dur("wait", wait).Msg("retrying oracle request")
+		select {
+		case <-ctx.Done():
+		case <-time.After(wait):
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+
+	if ctx.Err() != nil {
+		// a cancelled request says nothing about the oracle
+		c.release()
+		return nil, ctx.Err()
+	}
+	c.record(err)
+	return body, err
+}
+
+func (c *OracleClient) get(ctx context.Context, endpoint, url string, header http.Header) ([]byte, error) {
+	if c.opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.opts.Timeout)
+		defer cancel()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	for key, values := range header {
+		req.Header[key] = values
+	}
+
+	start := time.Now()
+	resp, err := c.client.Do(req)
+	if err != nil {
+		metrics.ObserveOracleRequest(endpoint, time.Since(start), true)
+		return nil, err
+	}
+	defer func(Body io.ReadCloser) {
+		_ = Body.Close()
+	}(resp.Body)
+
+	body, err := io.ReadAll(resp.Body)
+	failed := err != nil || resp.StatusCode < 200 || resp.StatusCode > 299
+	metrics.ObserveOracleRequest(endpoint, time.Since(start), failed)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		if len(body) > 512 {
+			body = body[:512]
+		}
+		return nil, &HTTPStatusError{URL: url, StatusCode: resp.StatusCode, Status: resp.Status, Body: string(body)}
+	}
+
+	return body, nil
+}
+
+// retryable is false for statuses like 404 which will not change on a retry
+func retryable(err error) bool {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		return statusErr.Retryable()
+	}
+	return true
+}
+
+func (c *OracleClient) backoff(attempt int) time.Duration {
+	wait := c.opts.MinBackoff
+	for i := 0; i < attempt && wait < c.opts.MaxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, c.opts.MaxBackoff)
+	return wait + time.Duration(rand.Int64N(int64(wait)/2+1))
+}
+
+// allow returns ErrCircuitOpen while the breaker is open.
+// After the cooldown a single request is let through, it closes the breaker if it succeeds.
+func (c *OracleClient) allow() error {
+	if c.opts.BreakerThreshold <= 0 {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.failures < c.opts.BreakerThreshold {
+		return nil
+	}
+	if c.probing || time.Now().Before(c.openUntil) {
+		return ErrCircuitOpen
+	}
+	c.probing = true
+	return nil
+}
+
+func (c *OracleClient) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+}
+
+// record updates the breaker with the result of a request, only failures a retry could fix count.
+// State changes are logged once instead of every failed request.
+func (c *OracleClient) record(err error) {
+	if c.opts.BreakerThreshold <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.probing = false
+
+	if err == nil || !retryable(err) {
+		if c.failures >= c.opts.BreakerThreshold {
+			logging.L.Info().Msg("oracle reachable again, circuit breaker closed")
+		}
+		c.failures = 0
+		return
+	}
+
+	c.failures++
+	if c.failures >= c.opts.BreakerThreshold {
+		if c.failures == c.opts.BreakerThreshold {
+			logging.L.Warn().Err(err).Int("failures", c.failures).Dur("cooldown", c.opts.BreakerCooldown).
+				Msg("oracle unreachable, circuit breaker opened")
+		}
+		c.openUntil = time.Now().Add(c.opts.BreakerCooldown)
+	}
+}
@@ -0,0 +1,109 @@
+package networking
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func testOracleClient() *OracleClient {
+	return NewOracleClient(OracleClientOptions{
+		Timeout:          time.Second,
+		Retries:          3,
+		MinBackoff:       time.Millisecond,
+		MaxBackoff:       5 * time.Millisecond,
+		BreakerThreshold: 2,
+		BreakerCooldown:  time.Hour,
+	})
+}
+
+func TestOracleClientRetries(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		switch {
+		case r.URL.Path == "/missing":
+			w.WriteHeader(http.StatusNotFound)
+		case n < 3:
+			w.WriteHeader(http.StatusServiceUnavailable)
+		default:
+			_, _ = w.Write([]byte(`{"block_height":840000}`))
+		}
+	}))
+	defer server.Close()
+
+	client := ClientBlindBit{BaseUrl: server.URL, Oracle: testOracleClient()}
+
+	height, err := client.GetChainTip()
+	if err != nil || height != 840_000 {
+		t.Fatalf("chain tip: got %d (%v)", height, err)
+	}
+	if requests.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", requests.Load())
+	}
+
+	requests.Store(10)
+	_, err = client.Oracle.Get("missing", server.URL+"/missing", nil)
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected a 404 status error, got %v", err)
+	}
+	if requests.Load() != 11 {
+		t.Errorf("expected a 404 not to be retried, got %d attempts", requests.Load()-10)
+	}
+}
+
+func TestOracleClientBreaker(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client := testOracleClient()
+	for i := 0; i < 2; i++ {
+		if _, err := client.Get("block-height", server.URL, nil); err == nil {
+			t.Fatal("expected an error")
+		}
+	}
+	if requests.Load() != 8 {
+		t.Errorf("expected 8 attempts, got %d", requests.Load())
+	}
+
+	if _, err := client.Get("block-height", server.URL, nil); !errors.Is(err, ErrCircuitOpen) {
+		t.Fatalf("expected the breaker to be open, got %v", err)
+	}
+	if requests.Load() != 8 {
+		t.Errorf("expected no request while the breaker is open")
+	}
+
+	// after the cooldown a single probe is let through
+	client.openUntil = time.Now()
+	if _, err := client.Get("block-height", server.URL, nil); errors.Is(err, ErrCircuitOpen) {
+		t.Fatal("expected a probe request")
+	}
+}
+
+func TestOracleClientContext(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer server.Close()
+
+	client := testOracleClient()
+	ctx, cancel := context.WithCancel(context.Background())
+	client.SetContext(ctx)
+	time.AfterFunc(10*time.Millisecond, cancel)
+
+	if _, err := client.Get("block-height", server.URL, nil); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the request to be cancelled, got %v", err)
+	}
+	if client.failures != 0 {
+		t.Errorf("expected a cancelled request not to count for the breaker")
+	}
+}
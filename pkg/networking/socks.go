@@ -0,0 +1,36 @@
+package networking
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"net"
+	"net/http"
+
+	"golang.org/x/net/proxy"
+)
+
+// newSOCKS5Transport dials all connections through the SOCKS5 proxy at proxyAddr, e.g. Tor at 127.0.0.1:9050.
+// Hostnames are resolved by the proxy, so .onion addresses work and no DNS requests leak.
+// Every host gets its own credentials, Tor then builds a separate circuit per host (IsolateSOCKSAuth is on by default).
+func newSOCKS5Transport(proxyAddr string) *http.Transport {
+	// random per process, the circuits of a host are not linkable across restarts
+	var nonce [16]byte
+	_, _ = rand.Read(nonce[:])
+	password := hex.EncodeToString(nonce[:])
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		dialer, err := proxy.SOCKS5("tcp", proxyAddr, &proxy.Auth{User: host, Password: password}, proxy.Direct)
+		if err != nil {
+			return nil, err
+		}
+		return dialer.(proxy.ContextDialer).DialContext(ctx, network, addr)
+	}
+	return transport
+}
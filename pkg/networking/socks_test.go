@@ -0,0 +1,127 @@
+package networking
+
+import (
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+)
+
+// socks5StandIn is a minimal SOCKS5 proxy (username/password auth, CONNECT to domain names).
+// It connects every requested host to target and records the requested hosts and usernames.
+type socks5StandIn struct {
+	listener net.Listener
+	target   string
+
+	mu    sync.Mutex
+	hosts []string
+	users []string
+}
+
+func newSOCKS5StandIn(t *testing.T, target string) *socks5StandIn {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &socks5StandIn{listener: listener, target: target}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	t.Cleanup(func() { _ = listener.Close() })
+	return s
+}
+
+func (s *socks5StandIn) serve(conn net.Conn) {
+	defer conn.Close()
+
+	// greeting, only username/password auth is accepted
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return
+	}
+	if _, err := io.ReadFull(conn, make([]byte, header[1])); err != nil {
+		return
+	}
+	_, _ = conn.Write([]byte{5, 2})
+
+	// username/password auth
+	readString := func() string {
+		length := make([]byte, 1)
+		_, _ = io.ReadFull(conn, length)
+		value := make([]byte, length[0])
+		_, _ = io.ReadFull(conn, value)
+		return string(value)
+	}
+	_, _ = io.ReadFull(conn, make([]byte, 1))
+	user := readString()
+	_ = readString()
+	_, _ = conn.Write([]byte{1, 0})
+
+	// connect request
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(conn, request); err != nil || request[3] != 3 {
+		_, _ = conn.Write([]byte{5, 8, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	host := readString()
+	port := make([]byte, 2)
+	_, _ = io.ReadFull(conn, port)
+
+	s.mu.Lock()
+	s.hosts = append(s.hosts, net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+	s.users = append(s.users, user)
+	s.mu.Unlock()
+
+	upstream, err := net.Dial("tcp", s.target)
+	if err != nil {
+		_, _ = conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer upstream.Close()
+	_, _ = conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+
+	go func() { _, _ = io.Copy(upstream, conn) }()
+	_, _ = io.Copy(conn, upstream)
+}
+
+func TestOracleClientSOCKS5(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"block_height":840000}`))
+	}))
+	defer server.Close()
+
+	standIn := newSOCKS5StandIn(t, server.Listener.Addr().String())
+
+	opts := DefaultOracleClientOptions()
+	opts.Proxy = standIn.listener.Addr().String()
+	oracle := NewOracleClient(opts)
+
+	onion := ClientBlindBit{BaseUrl: "http://oracleexample.onion", Oracle: oracle}
+	other := ClientBlindBit{BaseUrl: "http://oracle.example:8000", Oracle: oracle}
+	for _, client := range []ClientBlindBit{onion, other} {
+		height, err := client.GetChainTip()
+		if err != nil || height != 840_000 {
+			t.Fatalf("chain tip via %s: got %d (%v)", client.BaseUrl, height, err)
+		}
+	}
+
+	standIn.mu.Lock()
+	defer standIn.mu.Unlock()
+	// the hostnames are resolved by the proxy
+	if len(standIn.hosts) != 2 || standIn.hosts[0] != "oracleexample.onion:80" || standIn.hosts[1] != "oracle.example:8000" {
+		t.Fatalf("unexpected connections %v", standIn.hosts)
+	}
+	if standIn.users[0] != "oracleexample.onion" || standIn.users[1] != "oracle.example" {
+		t.Errorf("expected the streams to be isolated per host, got users %v", standIn.users)
+	}
+}
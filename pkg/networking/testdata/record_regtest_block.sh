@@ -0,0 +1,32 @@
+#!/usr/bin/env bash
+# Records a regtest block with silent payment eligible transactions as served by `getblock <hash> 3`.
+# Needs bitcoind and bitcoin-cli (v25 or later for prevout data) in PATH, writes regtest_block_<height>.json
+# next to this script. TestClientBitcoinCoreRecordedBlocks picks the file up.
+set -euo pipefail
+
+cd "$(dirname "$0")"
+datadir=$(mktemp -d)
+cli() { bitcoin-cli -regtest -datadir="$datadir" "$@"; }
+
+bitcoind -regtest -datadir="$datadir" -daemon -fallbackfee=0.0001 -txindex=1
+trap 'cli stop >/dev/null 2>&1 || true; sleep 2; rm -rf "$datadir"' EXIT
+until cli getblockchaininfo >/dev/null 2>&1; do sleep 0.2; done
+
+cli -named createwallet wallet_name=recorder >/dev/null
+segwit=$(cli getnewaddress "" bech32)
+cli generatetoaddress 101 "$segwit" >/dev/null
+
+# P2WPKH and P2SH-P2WPKH inputs, both are eligible for silent payments
+nested=$(cli getnewaddress "" p2sh-segwit)
+cli sendtoaddress "$nested" 1 >/dev/null
+cli generatetoaddress 1 "$segwit" >/dev/null
+
+# taproot outputs make the transactions eligible
+cli sendtoaddress "$(cli getnewaddress "" bech32m)" 0.5 >/dev/null
+cli -named send outputs="{\"$(cli getnewaddress "" bech32m)\": 0.3}" \
+  options="{\"inputs\": $(cli listunspent 1 9999999 "[\"$nested\"]" | jq '[.[] | {txid, vout}]')}" >/dev/null
+hash=$(cli generatetoaddress 1 "$segwit" | jq -r '.[0]')
+
+height=$(cli getblock "$hash" 1 | jq '.height')
+cli getblock "$hash" 3 > "regtest_block_${height}.json"
+echo "recorded block $height to regtest_block_${height}.json"
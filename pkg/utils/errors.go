@@ -4,4 +4,6 @@ import "errors"
 
 var (
 	ErrLabelAlreadyExists = errors.New("label already exists")
+	ErrLabelNotFound      = errors.New("label not found")
+	ErrBlockHashMismatch  = errors.New("block hash mismatch")
 )
@@ -0,0 +1,106 @@
+package wallet
+
+import "sort"
+
+// Balance holds the summed up amounts of utxos per state, spent utxos are not counted
+type Balance struct {
+	Confirmed        uint64 `json:"confirmed"`         // StateUnspent
+	Unconfirmed      uint64 `json:"unconfirmed"`       // StateUnconfirmed, incoming payments in the mempool
+	UnconfirmedSpent uint64 `json:"unconfirmed_spent"` // StateUnconfirmedSpent, outgoing payments in the mempool
+}
+
+func (b *Balance) add(utxo *OwnedUTXO) {
+	switch utxo.State {
+	case StateUnspent:
+		b.Confirmed += utxo.Amount
+	case StateUnconfirmed:
+		b.Unconfirmed += utxo.Amount
+	case StateUnconfirmedSpent:
+		b.UnconfirmedSpent += utxo.Amount
+	}
+}
+
+// LabelBalance is the balance of the outputs received on a single label
+type LabelBalance struct {
+	M       uint32 `json:"m"`
+	Name    string `json:"name,omitempty"`
+	Address string `json:"address"`
+	Change  bool   `json:"change,omitempty"` // m = 0
+	Balance
+}
+
+// BalanceBreakdown splits the balance of the wallet into unlabeled outputs and outputs per label
+type BalanceBreakdown struct {
+	Total     Balance        `json:"total"`
+	Unlabeled Balance        `json:"unlabeled"`
+	Labels    []LabelBalance `json:"labels"` // all labels of the wallet ordered by m, including those without funds
+}
+
+// GetBalance sums up all utxos of the wallet per state
+func (w *Wallet) GetBalance() Balance {
+	var balance Balance
+	for _, utxo := range w.UTXOs {
+		balance.add(utxo)
+	}
+	return balance
+}
+
+// GetBalanceBreakdown sums up the utxos per state and per label
+func (w *Wallet) GetBalanceBreakdown() *BalanceBreakdown {
+	unlabeled, labelled := w.GroupUTXOsByLabel()
+
+	breakdown := &BalanceBreakdown{}
+	for _, utxo := range unlabeled {
+		breakdown.Total.add(utxo)
+		breakdown.Unlabeled.add(utxo)
+	}
+
+	names := w.labelNames()
+	labels := w.GetLabels()
+	labelBalances := make(map[uint32]*LabelBalance, len(labels))
+	for _, label := range labels {
+		labelBalances[label.M] = &LabelBalance{
+			M:       label.M,
+			Name:    names[label.M],
+			Address: label.Address,
+			Change:  label.M == 0,
+		}
+	}
+
+	for m, utxos := range labelled {
+		labelBalance, ok := labelBalances[m]
+		if !ok {
+			// the label is attached to the utxo, but is not part of the wallet (anymore)
+			labelBalance = &LabelBalance{M: m, Address: utxos[0].Label.Address, Change: m == 0}
+			labelBalances[m] = labelBalance
+		}
+		for _, utxo := range utxos {
+			breakdown.Total.add(utxo)
+			labelBalance.add(utxo)
+		}
+	}
+
+	breakdown.Labels = make([]LabelBalance, 0, len(labelBalances))
+	for _, labelBalance := range labelBalances {
+		breakdown.Labels = append(breakdown.Labels, *labelBalance)
+	}
+	sort.Slice(breakdown.Labels, func(i, j int) bool {
+		return breakdown.Labels[i].M < breakdown.Labels[j].M
+	})
+
+	return breakdown
+}
+
+// GroupUTXOsByLabel splits the utxos into unlabeled ones and the ones per label m
+func (w *Wallet) GroupUTXOsByLabel() (UtxoCollection, map[uint32]UtxoCollection) {
+	var unlabeled UtxoCollection
+	labelled := make(map[uint32]UtxoCollection)
+	for _, utxo := range w.UTXOs {
+		if utxo.Label == nil {
+			unlabeled = append(unlabeled, utxo)
+			continue
+		}
+		labelled[utxo.Label.M] = append(labelled[utxo.Label.M], utxo)
+	}
+	return unlabeled, labelled
+}
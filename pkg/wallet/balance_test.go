@@ -0,0 +1,55 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/setavenger/go-bip352"
+)
+
+func TestGetBalanceBreakdown(t *testing.T) {
+	change := &bip352.Label{PubKey: [33]byte{2, 0}, M: 0, Address: "change"}
+	customer := &bip352.Label{PubKey: [33]byte{2, 1}, M: 1, Address: "customer"}
+	empty := &bip352.Label{PubKey: [33]byte{2, 2}, M: 2, Address: "empty"}
+	w := &Wallet{
+		Labels:      LabelMap{change.PubKey: change, customer.PubKey: customer, empty.PubKey: empty},
+		LabelNames:  map[uint32]string{1: "customer-1"},
+		UTXOMapping: UTXOMapping{},
+	}
+
+	_, err := w.AddUTXOs([]*OwnedUTXO{
+		{Txid: [32]byte{1}, Amount: 1000, State: StateUnspent},
+		{Txid: [32]byte{2}, Amount: 2000, State: StateUnconfirmed},
+		{Txid: [32]byte{3}, Amount: 4000, State: StateUnspent, Label: customer},
+		{Txid: [32]byte{4}, Amount: 8000, State: StateUnconfirmedSpent, Label: customer},
+		{Txid: [32]byte{5}, Amount: 16000, State: StateSpent, Label: customer},
+		{Txid: [32]byte{6}, Amount: 32000, State: StateUnspent, Label: change},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	breakdown := w.GetBalanceBreakdown()
+	if breakdown.Total != (Balance{Confirmed: 37000, Unconfirmed: 2000, UnconfirmedSpent: 8000}) {
+		t.Errorf("unexpected total %+v", breakdown.Total)
+	}
+	if breakdown.Total != w.GetBalance() {
+		t.Errorf("total %+v does not match GetBalance %+v", breakdown.Total, w.GetBalance())
+	}
+	if breakdown.Unlabeled != (Balance{Confirmed: 1000, Unconfirmed: 2000}) {
+		t.Errorf("unexpected unlabeled balance %+v", breakdown.Unlabeled)
+	}
+
+	if len(breakdown.Labels) != 3 {
+		t.Fatalf("expected all 3 labels, got %d", len(breakdown.Labels))
+	}
+	expected := []LabelBalance{
+		{M: 0, Address: "change", Change: true, Balance: Balance{Confirmed: 32000}},
+		{M: 1, Name: "customer-1", Address: "customer", Balance: Balance{Confirmed: 4000, UnconfirmedSpent: 8000}},
+		{M: 2, Address: "empty"},
+	}
+	for i := range expected {
+		if breakdown.Labels[i] != expected[i] {
+			t.Errorf("expected %+v, got %+v", expected[i], breakdown.Labels[i])
+		}
+	}
+}
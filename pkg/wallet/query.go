@@ -0,0 +1,207 @@
+package wallet
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// UTXOQuery filters, sorts and pages the utxos of the wallet. All filters are optional and combined.
+type UTXOQuery struct {
+	States    []UTXOState `json:"utxo_state,omitempty"`
+	Labels    []uint32    `json:"label,omitempty"`     // label m
+	Unlabeled bool        `json:"unlabeled,omitempty"` // utxos without a label, combined with Labels if both are set
+	MinAmount uint64      `json:"min_amount,omitempty"`
+	MaxAmount uint64      `json:"max_amount,omitempty"`
+	MinHeight uint64      `json:"min_height,omitempty"` // height bounds exclude unconfirmed utxos
+	MaxHeight uint64      `json:"max_height,omitempty"`
+	From      uint64      `json:"from,omitempty"` // timestamp
+	Until     uint64      `json:"until,omitempty"`
+	Txid      string      `json:"txid,omitempty"`
+
+	Sort   string `json:"sort,omitempty"`   // height (default), amount or timestamp
+	Order  string `json:"order,omitempty"`  // asc (default) or desc
+	Cursor string `json:"cursor,omitempty"` // NextCursor of the previous page
+	Limit  int    `json:"limit,omitempty"`  // 0 for no limit
+
+	// IncludeTweaks returns the utxos with their private key and label tweaks, see OwnedUTXO.Redacted.
+	// It is never read from request params, callers set it when disclosing spend data is allowed.
+	IncludeTweaks bool `json:"-"`
+}
+
+// UTXOPage is the result of a query, NextCursor is empty on the last page
+type UTXOPage struct {
+	UTXOs      UtxoCollection `json:"utxos"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+const (
+	sortByHeight    = "height"
+	sortByAmount    = "amount"
+	sortByTimestamp = "timestamp"
+)
+
+// QueryUTXOs returns copies of the utxos matching q.
+// Utxos with the same sort value are ordered by txid and vout, so the cursor stays stable while utxos are added.
+func (w *Wallet) QueryUTXOs(q UTXOQuery) (*UTXOPage, error) {
+	sortBy := q.Sort
+	if sortBy == "" {
+		sortBy = sortByHeight
+	}
+	var sortValue func(*OwnedUTXO) uint64
+	switch sortBy {
+	case sortByHeight:
+		sortValue = func(u *OwnedUTXO) uint64 { return u.BlockHeight }
+	case sortByAmount:
+		sortValue = func(u *OwnedUTXO) uint64 { return u.Amount }
+	case sortByTimestamp:
+		sortValue = func(u *OwnedUTXO) uint64 { return u.Timestamp }
+	default:
+		return nil, fmt.Errorf("invalid sort: (%s)", q.Sort)
+	}
+
+	var descending bool
+	switch q.Order {
+	case "", "asc":
+	case "desc":
+		descending = true
+	default:
+		return nil, fmt.Errorf("invalid order: (%s)", q.Order)
+	}
+
+	var txid []byte
+	if q.Txid != "" {
+		var err error
+		txid, err = hex.DecodeString(q.Txid)
+		if err != nil || len(txid) != 32 {
+			return nil, fmt.Errorf("invalid txid: (%s)", q.Txid)
+		}
+	}
+
+	type entry struct {
+		utxo  *OwnedUTXO
+		value uint64
+		key   [36]byte
+	}
+	var entries []entry
+	for _, utxo := range w.UTXOs {
+		if !q.matches(utxo, txid) {
+			continue
+		}
+		key, err := utxo.GetKey()
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry{utxo: utxo, value: sortValue(utxo), key: key})
+	}
+
+	less := func(aValue uint64, aKey [36]byte, bValue uint64, bKey [36]byte) bool {
+		if aValue != bValue {
+			return aValue < bValue != descending
+		}
+		cmp := bytes.Compare(aKey[:], bKey[:])
+		if descending {
+			return cmp > 0
+		}
+		return cmp < 0
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return less(entries[i].value, entries[i].key, entries[j].value, entries[j].key)
+	})
+
+	if q.Cursor != "" {
+		cursorValue, cursorKey, err := decodeCursor(q.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		start := sort.Search(len(entries), func(i int) bool {
+			return less(cursorValue, cursorKey, entries[i].value, entries[i].key)
+		})
+		entries = entries[start:]
+	}
+
+	page := &UTXOPage{UTXOs: make(UtxoCollection, 0, len(entries))}
+	if q.Limit > 0 && q.Limit < len(entries) {
+		entries = entries[:q.Limit]
+		last := entries[len(entries)-1]
+		page.NextCursor = encodeCursor(last.value, last.key)
+	}
+
+	for _, e := range entries {
+		if q.IncludeTweaks {
+			utxo := *e.utxo
+			page.UTXOs = append(page.UTXOs, &utxo)
+		} else {
+			page.UTXOs = append(page.UTXOs, e.utxo.Redacted())
+		}
+	}
+
+	return page, nil
+}
+
+func (q *UTXOQuery) matches(utxo *OwnedUTXO, txid []byte) bool {
+	if len(q.States) > 0 {
+		var found bool
+		for _, state := range q.States {
+			if utxo.State == state {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(q.Labels) > 0 || q.Unlabeled {
+		found := q.Unlabeled && utxo.Label == nil
+		for _, m := range q.Labels {
+			if utxo.Label != nil && utxo.Label.M == m {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if utxo.Amount < q.MinAmount || (q.MaxAmount != 0 && utxo.Amount > q.MaxAmount) {
+		return false
+	}
+
+	if q.MinHeight != 0 || q.MaxHeight != 0 {
+		if utxo.BlockHeight == 0 || utxo.BlockHeight < q.MinHeight || (q.MaxHeight != 0 && utxo.BlockHeight > q.MaxHeight) {
+			return false
+		}
+	}
+
+	if utxo.Timestamp < q.From || (q.Until != 0 && utxo.Timestamp > q.Until) {
+		return false
+	}
+
+	if txid != nil && !bytes.Equal(utxo.Txid[:], txid) {
+		return false
+	}
+
+	return true
+}
+
+// the cursor is the sort value and the key of the last utxo of a page
+func encodeCursor(value uint64, key [36]byte) string {
+	data := binary.BigEndian.AppendUint64(nil, value)
+	return hex.EncodeToString(append(data, key[:]...))
+}
+
+func decodeCursor(cursor string) (uint64, [36]byte, error) {
+	data, err := hex.DecodeString(cursor)
+	if err != nil || len(data) != 8+36 {
+		return 0, [36]byte{}, ErrInvalidCursor
+	}
+	return binary.BigEndian.Uint64(data[:8]), [36]byte(data[8:]), nil
+}
@@ -0,0 +1,104 @@
+package wallet
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/setavenger/go-bip352"
+)
+
+func TestQueryUTXOs(t *testing.T) {
+	label := &bip352.Label{PubKey: [33]byte{2, 1}, Tweak: [32]byte{9}, M: 1}
+	w := &Wallet{UTXOMapping: UTXOMapping{}}
+	_, err := w.AddUTXOs([]*OwnedUTXO{
+		{Txid: [32]byte{1}, Amount: 1000, State: StateSpent, BlockHeight: 10, Timestamp: 100, PrivKeyTweak: [32]byte{1}},
+		{Txid: [32]byte{2}, Amount: 5000, State: StateUnspent, BlockHeight: 12, Timestamp: 120, Label: label},
+		{Txid: [32]byte{3}, Amount: 3000, State: StateUnspent, BlockHeight: 12, Timestamp: 120},
+		{Txid: [32]byte{4}, Amount: 7000, State: StateUnconfirmed, Timestamp: 130},
+		{Txid: [32]byte{5}, Amount: 2000, State: StateUnspent, BlockHeight: 15, Timestamp: 150, Label: label},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		query    UTXOQuery
+		expected []byte // first txid byte of the expected utxos in order
+	}{
+		{"default sort by height", UTXOQuery{}, []byte{4, 1, 2, 3, 5}},
+		{"states", UTXOQuery{States: []UTXOState{StateUnspent, StateUnconfirmed}}, []byte{4, 2, 3, 5}},
+		{"label", UTXOQuery{Labels: []uint32{1}}, []byte{2, 5}},
+		{"unlabeled", UTXOQuery{Unlabeled: true, States: []UTXOState{StateUnspent}}, []byte{3}},
+		{"amount range", UTXOQuery{MinAmount: 2000, MaxAmount: 5000}, []byte{2, 3, 5}},
+		{"height range excludes unconfirmed", UTXOQuery{MaxHeight: 12}, []byte{1, 2, 3}},
+		{"timestamp range", UTXOQuery{From: 120, Until: 130}, []byte{4, 2, 3}},
+		{"txid", UTXOQuery{Txid: "0300000000000000000000000000000000000000000000000000000000000000"}, []byte{3}},
+		{"amount descending", UTXOQuery{Sort: "amount", Order: "desc"}, []byte{4, 2, 3, 5, 1}},
+	}
+	for _, tt := range tests {
+		page, err := w.QueryUTXOs(tt.query)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if len(page.UTXOs) != len(tt.expected) {
+			t.Errorf("%s: expected %d utxos, got %d", tt.name, len(tt.expected), len(page.UTXOs))
+			continue
+		}
+		for i, utxo := range page.UTXOs {
+			if utxo.Txid[0] != tt.expected[i] {
+				t.Errorf("%s: expected txid %d at %d, got %d", tt.name, tt.expected[i], i, utxo.Txid[0])
+			}
+		}
+	}
+
+	// page through everything sorted by height descending, two at a time
+	var paged []byte
+	query := UTXOQuery{Order: "desc", Limit: 2}
+	for {
+		page, err := w.QueryUTXOs(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, utxo := range page.UTXOs {
+			paged = append(paged, utxo.Txid[0])
+		}
+		if page.NextCursor == "" {
+			break
+		}
+		query.Cursor = page.NextCursor
+	}
+	if string(paged) != string([]byte{5, 3, 2, 1, 4}) {
+		t.Errorf("unexpected order when paging: %v", paged)
+	}
+
+	page, err := w.QueryUTXOs(UTXOQuery{Txid: "0100000000000000000000000000000000000000000000000000000000000000"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.UTXOs[0].PrivKeyTweak != [32]byte{} || w.UTXOs[0].PrivKeyTweak != [32]byte{1} {
+		t.Errorf("expected a redacted copy without touching the wallet")
+	}
+	page, err = w.QueryUTXOs(UTXOQuery{Txid: "0100000000000000000000000000000000000000000000000000000000000000", IncludeTweaks: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.UTXOs[0].PrivKeyTweak != [32]byte{1} {
+		t.Errorf("expected the tweak to be included")
+	}
+
+	page, err = w.QueryUTXOs(UTXOQuery{Labels: []uint32{1}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.UTXOs[0].Label.Tweak != [32]byte{} || label.Tweak != [32]byte{9} {
+		t.Errorf("expected a redacted copy of the label")
+	}
+
+	if _, err = w.QueryUTXOs(UTXOQuery{Cursor: "zz"}); !errors.Is(err, ErrInvalidCursor) {
+		t.Errorf("expected ErrInvalidCursor, got %v", err)
+	}
+	if _, err = w.QueryUTXOs(UTXOQuery{Sort: "label"}); err == nil {
+		t.Errorf("expected an error for an invalid sort")
+	}
+}
@@ -0,0 +1,256 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"sort"
+
+	"github.com/setavenger/go-bip352"
+)
+
+// ScannedBlock records against which block a height was scanned
+// and what the scan changed in the wallet, so that it can be undone if the block gets orphaned.
+type ScannedBlock struct {
+	Height     uint64
+	BlockHash  [32]byte
+	Added      [][36]byte             // keys of the utxos which were found in this block
+	Confirmed  [][36]byte             // keys of the utxos which were already seen in the mempool and got confirmed in this block
+	Backfilled [][36]byte             // keys of known utxos whose block data was filled in from this block
+	Spent      map[[36]byte]UTXOState // keys of the utxos which were marked as spent in this block mapped to their previous state
+
+	SpentHeightFilled [][36]byte // keys of utxos which were already spent, their spent height was filled in from this block
+}
+
+type ScannedBlockJSON struct {
+	Height     uint64               `json:"height"`
+	BlockHash  string               `json:"block_hash"`
+	Added      []string             `json:"added,omitempty"`
+	Confirmed  []string             `json:"confirmed,omitempty"`
+	Backfilled []string             `json:"backfilled,omitempty"`
+	Spent      map[string]UTXOState `json:"spent,omitempty"`
+
+	SpentHeightFilled []string `json:"spent_height_filled,omitempty"`
+}
+
+// ScannedBlocks is the rolling window of the most recently scanned blocks ordered by height
+type ScannedBlocks []*ScannedBlock
+
+func (b ScannedBlock) MarshalJSON() ([]byte, error) {
+	aux := ScannedBlockJSON{
+		Height:    b.Height,
+		BlockHash: hex.EncodeToString(b.BlockHash[:]),
+	}
+	for _, key := range b.Added {
+		aux.Added = append(aux.Added, hex.EncodeToString(key[:]))
+	}
+	for _, key := range b.Confirmed {
+		aux.Confirmed = append(aux.Confirmed, hex.EncodeToString(key[:]))
+	}
+	for _, key := range b.Backfilled {
+		aux.Backfilled = append(aux.Backfilled, hex.EncodeToString(key[:]))
+	}
+	for _, key := range b.SpentHeightFilled {
+		aux.SpentHeightFilled = append(aux.SpentHeightFilled, hex.EncodeToString(key[:]))
+	}
+	if len(b.Spent) > 0 {
+		aux.Spent = make(map[string]UTXOState, len(b.Spent))
+		for key, state := range b.Spent {
+			aux.Spent[hex.EncodeToString(key[:])] = state
+		}
+	}
+	return json.Marshal(aux)
+}
+
+func (b *ScannedBlock) UnmarshalJSON(data []byte) error {
+	var aux ScannedBlockJSON
+	if err := json.Unmarshal(data, &aux); err != nil {
+		return err
+	}
+
+	blockHash, err := hex.DecodeString(aux.BlockHash)
+	if err != nil {
+		return err
+	}
+
+	*b = ScannedBlock{
+		Height:    aux.Height,
+		BlockHash: bip352.ConvertToFixedLength32(blockHash),
+	}
+
+	b.Added, err = decodeKeys(aux.Added)
+	if err != nil {
+		return err
+	}
+	b.Confirmed, err = decodeKeys(aux.Confirmed)
+	if err != nil {
+		return err
+	}
+	b.Backfilled, err = decodeKeys(aux.Backfilled)
+	if err != nil {
+		return err
+	}
+	b.SpentHeightFilled, err = decodeKeys(aux.SpentHeightFilled)
+	if err != nil {
+		return err
+	}
+
+	if len(aux.Spent) > 0 {
+		b.Spent = make(map[[36]byte]UTXOState, len(aux.Spent))
+		for keyStr, state := range aux.Spent {
+			var key [36]byte
+			_, err = hex.Decode(key[:], []byte(keyStr))
+			if err != nil {
+				return err
+			}
+			b.Spent[key] = state
+		}
+	}
+
+	return nil
+}
+
+func decodeKeys(keyStrs []string) ([][36]byte, error) {
+	var keys [][36]byte
+	for _, keyStr := range keyStrs {
+		var key [36]byte
+		_, err := hex.Decode(key[:], []byte(keyStr))
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// RecordScannedBlock adds the block to the rolling window and drops all blocks which fall out of the window.
+// If the height was already recorded against the same block (e.g. during a rescan) the changes are merged.
+func (w *Wallet) RecordScannedBlock(block *ScannedBlock, windowSize int) {
+	idx := sort.Search(len(w.ScannedBlocks), func(i int) bool {
+		return w.ScannedBlocks[i].Height >= block.Height
+	})
+
+	switch {
+	case idx < len(w.ScannedBlocks) && w.ScannedBlocks[idx].Height == block.Height:
+		existing := w.ScannedBlocks[idx]
+		if existing.BlockHash != block.BlockHash {
+			w.ScannedBlocks[idx] = block
+			break
+		}
+		existing.Added = append(existing.Added, block.Added...)
+		existing.Confirmed = append(existing.Confirmed, block.Confirmed...)
+		existing.Backfilled = append(existing.Backfilled, block.Backfilled...)
+		existing.SpentHeightFilled = append(existing.SpentHeightFilled, block.SpentHeightFilled...)
+		for key, state := range block.Spent {
+			if existing.Spent == nil {
+				existing.Spent = make(map[[36]byte]UTXOState)
+			}
+			if _, ok := existing.Spent[key]; !ok {
+				existing.Spent[key] = state
+			}
+		}
+	default:
+		w.ScannedBlocks = append(w.ScannedBlocks, nil)
+		copy(w.ScannedBlocks[idx+1:], w.ScannedBlocks[idx:])
+		w.ScannedBlocks[idx] = block
+	}
+
+	if windowSize < 1 {
+		return
+	}
+
+	top := w.ScannedBlocks[len(w.ScannedBlocks)-1].Height
+	var cut int
+	for cut < len(w.ScannedBlocks) && w.ScannedBlocks[cut].Height+uint64(windowSize) <= top {
+		cut++
+	}
+	w.ScannedBlocks = w.ScannedBlocks[cut:]
+}
+
+// RollbackTo undoes all changes which were recorded for blocks above forkHeight.
+// Found utxos are removed, confirmed utxos become unconfirmed again and spent states are restored.
+// Backfilled utxos were owned before the block, they only lose the block data again.
+// Utxos which were already spent before the block only lose the spent height again.
+// The scan height is reset to forkHeight so that scanning continues on the new chain.
+// Returns the number of blocks which were rolled back.
+func (w *Wallet) RollbackTo(forkHeight uint64) int {
+	var rolledBack int
+	for len(w.ScannedBlocks) > 0 {
+		block := w.ScannedBlocks[len(w.ScannedBlocks)-1]
+		if block.Height <= forkHeight {
+			break
+		}
+
+		for key, state := range block.Spent {
+			if utxo := w.GetUTXO(key); utxo != nil {
+				utxo.State = state
+				utxo.SpentHeight = 0
+				utxo.SpentTxid = [32]byte{}
+			}
+		}
+		for _, key := range block.Added {
+			w.removeUTXO(key)
+		}
+		// the transaction might still be in the mempool, otherwise the next mempool scan drops them
+		for _, key := range block.Confirmed {
+			if utxo := w.GetUTXO(key); utxo != nil {
+				utxo.State = StateUnconfirmed
+				utxo.BlockHeight = 0
+				utxo.BlockHash = [32]byte{}
+			}
+		}
+		for _, key := range block.Backfilled {
+			if utxo := w.GetUTXO(key); utxo != nil {
+				utxo.BlockHeight = 0
+				utxo.BlockHash = [32]byte{}
+			}
+		}
+		for _, key := range block.SpentHeightFilled {
+			if utxo := w.GetUTXO(key); utxo != nil {
+				utxo.SpentHeight = 0
+			}
+		}
+
+		w.ScannedBlocks = w.ScannedBlocks[:len(w.ScannedBlocks)-1]
+		rolledBack++
+	}
+
+	if w.LastScanHeight > forkHeight {
+		w.LastScanHeight = forkHeight
+	}
+
+	return rolledBack
+}
+
+// GetUTXO returns the utxo with the given key or nil if the wallet does not own it
+func (w *Wallet) GetUTXO(key [36]byte) *OwnedUTXO {
+	return w.index()[key]
+}
+
+// index returns utxoIndex, it is built from UTXOs after the wallet was loaded
+func (w *Wallet) index() map[[36]byte]*OwnedUTXO {
+	if w.utxoIndex != nil {
+		return w.utxoIndex
+	}
+	w.utxoIndex = make(map[[36]byte]*OwnedUTXO, len(w.UTXOs))
+	for _, utxo := range w.UTXOs {
+		key, err := utxo.GetKey()
+		if err != nil {
+			continue
+		}
+		w.utxoIndex[key] = utxo
+	}
+	return w.utxoIndex
+}
+
+func (w *Wallet) removeUTXO(key [36]byte) {
+	if utxo := w.index()[key]; utxo != nil {
+		for i := range w.UTXOs {
+			if w.UTXOs[i] == utxo {
+				w.UTXOs = append(w.UTXOs[:i], w.UTXOs[i+1:]...)
+				break
+			}
+		}
+	}
+	delete(w.utxoIndex, key)
+	delete(w.UTXOMapping, key)
+}
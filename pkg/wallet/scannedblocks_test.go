@@ -0,0 +1,177 @@
+package wallet
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestRollbackTo(t *testing.T) {
+	w := &Wallet{UTXOMapping: UTXOMapping{}}
+
+	kept := &OwnedUTXO{Txid: [32]byte{1}, Vout: 0, Amount: 1000, State: StateUnspent}
+	orphaned := &OwnedUTXO{Txid: [32]byte{2}, Vout: 1, Amount: 2000, State: StateUnspent}
+
+	keptKey, _ := kept.GetKey()
+	orphanedKey, _ := orphaned.GetKey()
+
+	added, err := w.AddUTXOs([]*OwnedUTXO{kept})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.RecordScannedBlock(&ScannedBlock{Height: 10, BlockHash: [32]byte{10}, Added: added.New}, 100)
+	w.RecordScannedBlock(&ScannedBlock{Height: 11, BlockHash: [32]byte{11}}, 100)
+
+	// block 12 finds a new utxo and spends the one from block 10
+	added, err = w.AddUTXOs([]*OwnedUTXO{orphaned})
+	if err != nil {
+		t.Fatal(err)
+	}
+	kept.State = StateSpent
+	w.RecordScannedBlock(&ScannedBlock{
+		Height:    12,
+		BlockHash: [32]byte{12},
+		Added:     added.New,
+		Spent:     map[[36]byte]UTXOState{keptKey: StateUnspent},
+	}, 100)
+	w.LastScanHeight = 12
+
+	if rolledBack := w.RollbackTo(11); rolledBack != 1 {
+		t.Fatalf("expected 1 block to be rolled back, got %d", rolledBack)
+	}
+
+	if w.LastScanHeight != 11 {
+		t.Errorf("expected last scan height 11, got %d", w.LastScanHeight)
+	}
+	if len(w.UTXOs) != 1 || w.UTXOs[0] != kept {
+		t.Fatalf("expected only the utxo from block 10 to remain, got %d utxos", len(w.UTXOs))
+	}
+	if _, ok := w.UTXOMapping[orphanedKey]; ok {
+		t.Errorf("orphaned utxo is still in the mapping")
+	}
+	if kept.State != StateUnspent {
+		t.Errorf("expected spent state to be restored, got %s", kept.State)
+	}
+	if len(w.ScannedBlocks) != 2 || w.ScannedBlocks[1].Height != 11 {
+		t.Errorf("expected scanned blocks up to height 11, got %d blocks", len(w.ScannedBlocks))
+	}
+}
+
+func TestRollbackToRevertsConfirmations(t *testing.T) {
+	w := &Wallet{UTXOMapping: UTXOMapping{}}
+
+	unconfirmed := &OwnedUTXO{Txid: [32]byte{1}, Amount: 1000, State: StateUnconfirmed, Timestamp: 100}
+	if _, err := w.AddUTXOs([]*OwnedUTXO{unconfirmed}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the block confirms the utxo which was seen in the mempool
+	added, err := w.AddUTXOs([]*OwnedUTXO{
+		{Txid: [32]byte{1}, Amount: 1000, State: StateUnspent, BlockHeight: 12, BlockHash: [32]byte{12}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added.New) != 0 || len(added.Confirmed) != 1 {
+		t.Fatalf("expected 1 confirmed and no new utxo, got %d and %d", len(added.Confirmed), len(added.New))
+	}
+	w.RecordScannedBlock(&ScannedBlock{Height: 12, BlockHash: [32]byte{12}, Added: added.New, Confirmed: added.Confirmed}, 100)
+
+	w.RollbackTo(11)
+
+	if len(w.UTXOs) != 1 {
+		t.Fatalf("expected the utxo to be kept, got %d utxos", len(w.UTXOs))
+	}
+	if utxo := w.UTXOs[0]; utxo.State != StateUnconfirmed || utxo.BlockHeight != 0 || utxo.BlockHash != [32]byte{} {
+		t.Errorf("expected the utxo to be unconfirmed again, got %s at height %d", utxo.State, utxo.BlockHeight)
+	}
+}
+
+func TestRollbackToKeepsBackfilledUTXOs(t *testing.T) {
+	w := &Wallet{UTXOMapping: UTXOMapping{}}
+
+	// utxo of an older wallet version without block data
+	old := &OwnedUTXO{Txid: [32]byte{1}, Amount: 1000, State: StateSpent}
+	if _, err := w.AddUTXOs([]*OwnedUTXO{old}); err != nil {
+		t.Fatal(err)
+	}
+
+	// the migration rescan fills in the block data
+	added, err := w.AddUTXOs([]*OwnedUTXO{
+		{Txid: [32]byte{1}, Amount: 1000, State: StateUnspent, BlockHeight: 12, BlockHash: [32]byte{12}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(added.New) != 0 || len(added.Backfilled) != 1 || old.BlockHeight != 12 {
+		t.Fatalf("expected 1 backfilled and no new utxo, got %d and %d", len(added.Backfilled), len(added.New))
+	}
+	w.RecordScannedBlock(&ScannedBlock{Height: 12, BlockHash: [32]byte{12}, Added: added.New, Backfilled: added.Backfilled}, 100)
+	// and the spent height without changing the state
+	old.SpentHeight = 20
+	w.RecordScannedBlock(&ScannedBlock{Height: 20, BlockHash: [32]byte{20}, SpentHeightFilled: added.Backfilled}, 100)
+
+	w.RollbackTo(11)
+
+	if len(w.UTXOs) != 1 || w.GetUTXO(added.Backfilled[0]) != old {
+		t.Fatalf("expected the utxo to be kept, got %d utxos", len(w.UTXOs))
+	}
+	if old.State != StateSpent || old.BlockHeight != 0 || old.BlockHash != [32]byte{} || old.SpentHeight != 0 {
+		t.Errorf("expected the spent utxo without block data, got %s at height %d spent at %d", old.State, old.BlockHeight, old.SpentHeight)
+	}
+}
+
+func TestGetUTXO(t *testing.T) {
+	first := &OwnedUTXO{Txid: [32]byte{1}, Vout: 0, Amount: 1000, State: StateUnspent}
+	second := &OwnedUTXO{Txid: [32]byte{1}, Vout: 1, Amount: 2000, State: StateUnspent}
+	firstKey, _ := first.GetKey()
+	secondKey, _ := second.GetKey()
+
+	// a loaded wallet has utxos but no index yet
+	w := &Wallet{UTXOs: UtxoCollection{first}, UTXOMapping: UTXOMapping{firstKey: {}}}
+	if w.GetUTXO(firstKey) != first {
+		t.Fatalf("expected to find the loaded utxo")
+	}
+
+	if _, err := w.AddUTXOs([]*OwnedUTXO{second}); err != nil {
+		t.Fatal(err)
+	}
+	if w.GetUTXO(secondKey) != second {
+		t.Errorf("expected to find the added utxo")
+	}
+
+	w.removeUTXO(firstKey)
+	if w.GetUTXO(firstKey) != nil || len(w.UTXOs) != 1 || w.UTXOs[0] != second {
+		t.Errorf("expected only the second utxo to remain")
+	}
+}
+
+func TestRecordScannedBlockWindow(t *testing.T) {
+	w := &Wallet{}
+	for i := uint64(1); i <= 10; i++ {
+		w.RecordScannedBlock(&ScannedBlock{Height: i, BlockHash: [32]byte{byte(i)}}, 3)
+	}
+
+	if len(w.ScannedBlocks) != 3 || w.ScannedBlocks[0].Height != 8 {
+		t.Fatalf("expected window of heights 8-10, got %d blocks starting at %d", len(w.ScannedBlocks), w.ScannedBlocks[0].Height)
+	}
+
+	// rescanning a height against the same block merges the changes
+	w.RecordScannedBlock(&ScannedBlock{Height: 9, BlockHash: [32]byte{9}, Added: [][36]byte{{1}}, Backfilled: [][36]byte{{2}}, SpentHeightFilled: [][36]byte{{3}}}, 3)
+	if len(w.ScannedBlocks) != 3 || len(w.ScannedBlocks[1].Added) != 1 || len(w.ScannedBlocks[1].Backfilled) != 1 ||
+		len(w.ScannedBlocks[1].SpentHeightFilled) != 1 {
+		t.Fatalf("expected rescanned block to be merged")
+	}
+
+	data, err := json.Marshal(w.ScannedBlocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded ScannedBlocks
+	if err = json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if len(decoded) != 3 || decoded[1].BlockHash != w.ScannedBlocks[1].BlockHash || decoded[1].Added[0] != w.ScannedBlocks[1].Added[0] ||
+		decoded[1].Backfilled[0] != w.ScannedBlocks[1].Backfilled[0] || decoded[1].SpentHeightFilled[0] != w.ScannedBlocks[1].SpentHeightFilled[0] {
+		t.Errorf("scanned blocks did not survive a json round trip")
+	}
+}
@@ -0,0 +1,151 @@
+package wallet
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+)
+
+type TxDirection string
+
+const (
+	TxIncoming TxDirection = "incoming"
+	TxOutgoing TxDirection = "outgoing"
+)
+
+// Transaction is an entry of the transaction history, derived from the utxos of the wallet.
+// Incoming entries group the received outputs by txid.
+// Outgoing entries group the spent outputs by spending txid if it is known (electrum), otherwise there is one entry per spent output.
+type Transaction struct {
+	Direction   TxDirection `json:"direction"`
+	Txid        string      `json:"txid,omitempty"` // empty for outgoing entries where the spending transaction is unknown
+	Amount      uint64      `json:"amount"`         // sum of the wallet's outputs received or spent
+	Confirmed   bool        `json:"confirmed"`
+	BlockHeight uint64      `json:"block_height,omitempty"` // 0 while unconfirmed or if unknown
+	BlockHash   string      `json:"block_hash,omitempty"`
+	Timestamp   uint64      `json:"timestamp,omitempty"` // only known for incoming entries
+	Outpoints   []string    `json:"outpoints"`           // txid:vout of the wallet's outputs
+	Labels      []uint32    `json:"labels,omitempty"`    // labels the outputs were received on
+}
+
+// TxFilter selects a page of the transaction history
+type TxFilter struct {
+	Direction TxDirection // empty for both directions
+	From      uint64      // timestamp, entries without a timestamp are never filtered out
+	Until     uint64      // timestamp, 0 for no upper bound
+	Offset    int
+	Limit     int // 0 for no limit
+}
+
+// GetTransactions derives the transaction history from the utxos, newest first.
+// Unconfirmed entries come first, entries of the same height are ordered by txid.
+func (w *Wallet) GetTransactions() []*Transaction {
+	incoming := make(map[[32]byte]*Transaction)
+	outgoing := make(map[[32]byte]*Transaction)
+	var txs []*Transaction
+
+	for _, utxo := range w.UTXOs {
+		outpoint := fmt.Sprintf("%x:%d", utxo.Txid, utxo.Vout)
+
+		tx, ok := incoming[utxo.Txid]
+		if !ok {
+			tx = &Transaction{
+				Direction:   TxIncoming,
+				Txid:        hex.EncodeToString(utxo.Txid[:]),
+				Confirmed:   utxo.State != StateUnconfirmed,
+				BlockHeight: utxo.BlockHeight,
+				Timestamp:   utxo.Timestamp,
+			}
+			if utxo.BlockHash != [32]byte{} {
+				tx.BlockHash = hex.EncodeToString(utxo.BlockHash[:])
+			}
+			incoming[utxo.Txid] = tx
+			txs = append(txs, tx)
+		}
+		tx.Amount += utxo.Amount
+		tx.Outpoints = append(tx.Outpoints, outpoint)
+		if utxo.Label != nil {
+			tx.Labels = appendLabel(tx.Labels, utxo.Label.M)
+		}
+
+		if utxo.State != StateSpent && utxo.State != StateUnconfirmedSpent {
+			continue
+		}
+
+		var spend *Transaction
+		if utxo.SpentTxid != [32]byte{} {
+			spend = outgoing[utxo.SpentTxid]
+		}
+		if spend == nil {
+			spend = &Transaction{
+				Direction:   TxOutgoing,
+				Confirmed:   utxo.State == StateSpent,
+				BlockHeight: utxo.SpentHeight,
+			}
+			if utxo.SpentTxid != [32]byte{} {
+				spend.Txid = hex.EncodeToString(utxo.SpentTxid[:])
+				outgoing[utxo.SpentTxid] = spend
+			}
+			txs = append(txs, spend)
+		}
+		spend.Amount += utxo.Amount
+		spend.Outpoints = append(spend.Outpoints, outpoint)
+		if spend.BlockHeight == 0 {
+			spend.BlockHeight = utxo.SpentHeight
+		}
+	}
+
+	sort.SliceStable(txs, func(i, j int) bool {
+		a, b := txs[i], txs[j]
+		if a.Confirmed != b.Confirmed {
+			return !a.Confirmed
+		}
+		if a.BlockHeight != b.BlockHeight {
+			return a.BlockHeight > b.BlockHeight
+		}
+		if a.Direction != b.Direction {
+			// the spend of an output received in the same block happened after receiving it
+			return a.Direction == TxOutgoing
+		}
+		if a.Txid != b.Txid {
+			return a.Txid < b.Txid
+		}
+		return a.Outpoints[0] < b.Outpoints[0]
+	})
+
+	return txs
+}
+
+// ListTransactions returns the page of the transaction history selected by filter
+// and the number of entries matching the filter in total
+func (w *Wallet) ListTransactions(filter TxFilter) ([]*Transaction, int) {
+	var matching []*Transaction
+	for _, tx := range w.GetTransactions() {
+		if filter.Direction != "" && tx.Direction != filter.Direction {
+			continue
+		}
+		if tx.Timestamp != 0 && (tx.Timestamp < filter.From || (filter.Until != 0 && tx.Timestamp > filter.Until)) {
+			continue
+		}
+		matching = append(matching, tx)
+	}
+
+	total := len(matching)
+	if filter.Offset >= total {
+		return []*Transaction{}, total
+	}
+	matching = matching[max(filter.Offset, 0):]
+	if filter.Limit > 0 && filter.Limit < len(matching) {
+		matching = matching[:filter.Limit]
+	}
+	return matching, total
+}
+
+func appendLabel(labels []uint32, m uint32) []uint32 {
+	for _, existing := range labels {
+		if existing == m {
+			return labels
+		}
+	}
+	return append(labels, m)
+}
@@ -0,0 +1,63 @@
+package wallet
+
+import (
+	"testing"
+
+	"github.com/setavenger/go-bip352"
+)
+
+func TestGetTransactions(t *testing.T) {
+	label := &bip352.Label{PubKey: [33]byte{2, 1}, M: 1}
+	w := &Wallet{UTXOMapping: UTXOMapping{}}
+
+	_, err := w.AddUTXOs([]*OwnedUTXO{
+		// two outputs of the same transaction, one of them spent by a known transaction
+		{Txid: [32]byte{1}, Vout: 0, Amount: 1000, State: StateSpent, BlockHeight: 10, Timestamp: 100, SpentHeight: 12, SpentTxid: [32]byte{9}},
+		{Txid: [32]byte{1}, Vout: 1, Amount: 2000, State: StateUnspent, BlockHeight: 10, Timestamp: 100, Label: label},
+		// spent without knowing the spending transaction
+		{Txid: [32]byte{2}, Vout: 0, Amount: 4000, State: StateSpent, BlockHeight: 11, Timestamp: 110, SpentHeight: 12},
+		{Txid: [32]byte{3}, Vout: 0, Amount: 8000, State: StateUnconfirmed, Timestamp: 130},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	txs := w.GetTransactions()
+	expected := []struct {
+		direction TxDirection
+		amount    uint64
+		height    uint64
+		outputs   int
+	}{
+		{TxIncoming, 8000, 0, 1},
+		{TxOutgoing, 4000, 12, 1}, // unknown spending txid sorts first
+		{TxOutgoing, 1000, 12, 1},
+		{TxIncoming, 4000, 11, 1},
+		{TxIncoming, 3000, 10, 2},
+	}
+	if len(txs) != len(expected) {
+		t.Fatalf("expected %d transactions, got %d", len(expected), len(txs))
+	}
+	for i, e := range expected {
+		tx := txs[i]
+		if tx.Direction != e.direction || tx.Amount != e.amount || tx.BlockHeight != e.height || len(tx.Outpoints) != e.outputs {
+			t.Errorf("entry %d: expected %+v, got %+v", i, e, tx)
+		}
+	}
+	if txs[4].Labels[0] != 1 || txs[0].Confirmed {
+		t.Errorf("expected labels and confirmation status to be derived")
+	}
+
+	page, total := w.ListTransactions(TxFilter{Direction: TxIncoming, Offset: 1, Limit: 1})
+	if total != 3 || len(page) != 1 || page[0].BlockHeight != 11 {
+		t.Errorf("unexpected page of %d out of %d", len(page), total)
+	}
+	// outgoing entries have no timestamp and are not filtered by time
+	page, total = w.ListTransactions(TxFilter{From: 105, Until: 120})
+	if total != 3 {
+		t.Errorf("expected 3 entries between 105 and 120, got %d", total)
+	}
+	if page, _ = w.ListTransactions(TxFilter{Offset: 10}); len(page) != 0 {
+		t.Errorf("expected an empty page past the end")
+	}
+}
@@ -19,7 +19,11 @@ type OwnedUTXO struct {
 	PubKey       [32]byte      `json:"pub_key"` // are always even hence we omit the parity byte
 	Timestamp    uint64        `json:"timestamp"`
 	State        UTXOState     `json:"utxo_state"`
-	Label        *bip352.Label `json:"label"` // the pubKey associated with the label
+	Label        *bip352.Label `json:"label"`                  // the pubKey associated with the label
+	BlockHeight  uint64        `json:"block_height,omitempty"` // height of the confirming block, 0 while unconfirmed
+	BlockHash    [32]byte      `json:"block_hash,omitempty"`
+	SpentHeight  uint64        `json:"spent_height,omitempty"` // height of the spending block, 0 if unspent or unknown
+	SpentTxid    [32]byte      `json:"spent_txid,omitempty"`   // spending transaction, only known if electrum is used
 }
 
 // create alias for hashes basically what btcsuite has. Better for conversion in json to hex etc.
@@ -27,16 +31,20 @@ type OwnedUtxoJSON struct {
 	Txid         string           `json:"txid"`
 	Vout         uint32           `json:"vout"`
 	Amount       uint64           `json:"amount"`
-	PrivKeyTweak string           `json:"priv_key_tweak"`
+	PrivKeyTweak string           `json:"priv_key_tweak,omitempty"` // omitted for redacted utxos
 	PubKey       string           `json:"pub_key"`
 	Timestamp    uint64           `json:"timestamp"`
 	State        UTXOState        `json:"utxo_state"`
 	Label        *Bip352LabelJSON `json:"label"` // the pubKey associated with the label
+	BlockHeight  uint64           `json:"block_height,omitempty"`
+	BlockHash    string           `json:"block_hash,omitempty"`
+	SpentHeight  uint64           `json:"spent_height,omitempty"`
+	SpentTxid    string           `json:"spent_txid,omitempty"`
 }
 
 type Bip352LabelJSON struct {
 	PubKey  string `json:"pub_key"`
-	Tweak   string `json:"tweak"`
+	Tweak   string `json:"tweak,omitempty"` // omitted for redacted utxos
 	Address string `json:"address"`
 	M       uint32 `json:"m"`
 }
@@ -55,13 +63,13 @@ func ConvertLabelJSONToLabel(v Bip352LabelJSON) (*bip352.Label, error) {
 	if err != nil {
 		return nil, err
 	}
-	tweak, err := hex.DecodeString(v.Tweak)
+	tweak, err := decodeOptionalHash(v.Tweak)
 	if err != nil {
 		return nil, err
 	}
 	label := &bip352.Label{
 		PubKey:  bip352.ConvertToFixedLength33(pubKey),
-		Tweak:   bip352.ConvertToFixedLength32(tweak),
+		Tweak:   tweak,
 		Address: v.Address,
 		M:       v.M,
 	}
@@ -75,20 +83,33 @@ func (u OwnedUTXO) MarshalJSON() ([]byte, error) {
 	if u.Label != nil {
 		label = &Bip352LabelJSON{
 			PubKey:  hex.EncodeToString(u.Label.PubKey[:]),
-			Tweak:   hex.EncodeToString(u.Label.Tweak[:]),
 			Address: u.Label.Address,
 			M:       u.Label.M,
 		}
+		if u.Label.Tweak != [32]byte{} {
+			label.Tweak = hex.EncodeToString(u.Label.Tweak[:])
+		}
 	}
 	newUtxo := OwnedUtxoJSON{
-		Txid:         hex.EncodeToString(u.Txid[:]),
-		Vout:         u.Vout,
-		Amount:       u.Amount,
-		PrivKeyTweak: hex.EncodeToString(u.PrivKeyTweak[:]),
-		PubKey:       hex.EncodeToString(u.PubKey[:]),
-		Timestamp:    u.Timestamp,
-		State:        u.State,
-		Label:        label,
+		Txid:        hex.EncodeToString(u.Txid[:]),
+		Vout:        u.Vout,
+		Amount:      u.Amount,
+		PubKey:      hex.EncodeToString(u.PubKey[:]),
+		Timestamp:   u.Timestamp,
+		State:       u.State,
+		Label:       label,
+		BlockHeight: u.BlockHeight,
+		SpentHeight: u.SpentHeight,
+	}
+	// a zero tweak is never valid, it marks a redacted utxo
+	if u.PrivKeyTweak != [32]byte{} {
+		newUtxo.PrivKeyTweak = hex.EncodeToString(u.PrivKeyTweak[:])
+	}
+	if u.BlockHash != [32]byte{} {
+		newUtxo.BlockHash = hex.EncodeToString(u.BlockHash[:])
+	}
+	if u.SpentTxid != [32]byte{} {
+		newUtxo.SpentTxid = hex.EncodeToString(u.SpentTxid[:])
 	}
 
 	return json.Marshal(newUtxo)
@@ -104,7 +125,7 @@ func (u *OwnedUTXO) UnmarshalJSON(data []byte) error {
 	if err != nil {
 		return err
 	}
-	privKeyTweak, err := hex.DecodeString(aux.PrivKeyTweak)
+	privKeyTweak, err := decodeOptionalHash(aux.PrivKeyTweak)
 	if err != nil {
 		return err
 	}
@@ -121,19 +142,62 @@ func (u *OwnedUTXO) UnmarshalJSON(data []byte) error {
 		}
 	}
 
+	// not set for utxos written before block data was recorded
+	blockHash, err := decodeOptionalHash(aux.BlockHash)
+	if err != nil {
+		return err
+	}
+	spentTxid, err := decodeOptionalHash(aux.SpentTxid)
+	if err != nil {
+		return err
+	}
+
 	*u = OwnedUTXO{
 		Txid:         bip352.ConvertToFixedLength32(txid),
 		Vout:         aux.Vout,
 		Amount:       aux.Amount,
-		PrivKeyTweak: bip352.ConvertToFixedLength32(privKeyTweak),
+		PrivKeyTweak: privKeyTweak,
 		PubKey:       bip352.ConvertToFixedLength32(pubKey),
 		Timestamp:    aux.Timestamp,
 		State:        aux.State,
 		Label:        label,
+		BlockHeight:  aux.BlockHeight,
+		BlockHash:    blockHash,
+		SpentHeight:  aux.SpentHeight,
+		SpentTxid:    spentTxid,
 	}
 	return err
 }
 
+// decodeOptionalHash decodes a 32 byte hex string, an empty string results in the zero hash
+func decodeOptionalHash(hashStr string) ([32]byte, error) {
+	var hash [32]byte
+	if hashStr == "" {
+		return hash, nil
+	}
+	decoded, err := hex.DecodeString(hashStr)
+	if err != nil {
+		return hash, err
+	}
+	if len(decoded) != 32 {
+		return hash, fmt.Errorf("invalid hash length %d", len(decoded))
+	}
+	return [32]byte(decoded), nil
+}
+
+// Redacted returns a copy of the utxo without the private key tweak and the label tweak.
+// Combined with the spend key the tweaks spend the output, they are only handed out on explicit request.
+func (u *OwnedUTXO) Redacted() *OwnedUTXO {
+	redacted := *u
+	redacted.PrivKeyTweak = [32]byte{}
+	if u.Label != nil {
+		label := *u.Label
+		label.Tweak = [32]byte{}
+		redacted.Label = &label
+	}
+	return &redacted
+}
+
 func (u OwnedUTXO) SerialiseToOutpoint() ([36]byte, error) {
 	var buf bytes.Buffer
 	buf.Write(bip352.ReverseBytesCopy(u.Txid[:]))
@@ -28,17 +28,26 @@ func (u UTXOState) MarshalJSON() ([]byte, error) {
 }
 
 func (u *UTXOState) UnmarshalJSON(data []byte) error {
-	switch strings.ReplaceAll(string(data), "\"", "") {
+	state, err := ParseUTXOState(strings.ReplaceAll(string(data), "\"", ""))
+	if err != nil {
+		return err
+	}
+	*u = state
+	return nil
+}
+
+// ParseUTXOState parses the string representation of a state
+func ParseUTXOState(s string) (UTXOState, error) {
+	switch s {
 	case StateUnconfirmed.String():
-		*u = StateUnconfirmed
+		return StateUnconfirmed, nil
 	case StateUnspent.String():
-		*u = StateUnspent
+		return StateUnspent, nil
 	case StateUnconfirmedSpent.String():
-		*u = StateUnconfirmedSpent
+		return StateUnconfirmedSpent, nil
 	case StateSpent.String():
-		*u = StateSpent
+		return StateSpent, nil
 	default:
-		return fmt.Errorf("err: %s is not a valid state", string(data))
+		return 0, fmt.Errorf("err: %s is not a valid state", s)
 	}
-	return nil
 }
@@ -3,6 +3,8 @@ package wallet
 import (
 	"encoding/json"
 	"log"
+	"sort"
+	"sync"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcd/chaincfg"
@@ -13,15 +15,32 @@ import (
 	"github.com/setavenger/go-bip352"
 )
 
+// WalletVersion is the version of the wallet format written by this version.
+//
+//	0: utxos without block height, block hash and spent height
+//	1: block data is recorded on every utxo
+const WalletVersion = 1
+
 type Wallet struct {
-	SecretKeyScan  types.SecretKey `json:"sec_key_scan"`
-	PubKeyScan     types.PublicKey `json:"pub_key_scan"`
-	PubKeySpend    types.PublicKey `json:"pub_key_spend"`
-	BirthHeight    uint64          `json:"birth_height,omitempty"`
-	LastScanHeight uint64          `json:"last_scan,omitempty"`
-	UTXOs          UtxoCollection  `json:"utxos,omitempty"`
-	Labels         LabelMap        `json:"labels"`       // Labels contains all labels except for the change label
-	UTXOMapping    UTXOMapping     `json:"utxo_mapping"` // used to keep track of utxos and not add the same twice
+	Version        uint32            `json:"version,omitempty"` // see WalletVersion, older wallets are migrated on startup
+	SecretKeyScan  types.SecretKey   `json:"sec_key_scan"`
+	PubKeyScan     types.PublicKey   `json:"pub_key_scan"`
+	PubKeySpend    types.PublicKey   `json:"pub_key_spend"`
+	BirthHeight    uint64            `json:"birth_height,omitempty"`
+	LastScanHeight uint64            `json:"last_scan,omitempty"`
+	UTXOs          UtxoCollection    `json:"utxos,omitempty"`
+	Labels         LabelMap          `json:"labels"`                   // Labels contains all labels including the change label (m = 0)
+	LabelNames     map[uint32]string `json:"label_names,omitempty"`    // human-readable names of the labels by m
+	UTXOMapping    UTXOMapping       `json:"utxo_mapping"`             // used to keep track of utxos and not add the same twice
+	ScannedBlocks  ScannedBlocks     `json:"scanned_blocks,omitempty"` // recently scanned blocks, used to detect and undo reorgs
+
+	// utxoIndex maps the keys of UTXOs to the utxos, built on first use and kept up to date by AddUTXOs and removeUTXO
+	utxoIndex map[[36]byte]*OwnedUTXO
+
+	// labelsMu guards swapping Labels and LabelNames.
+	// Both maps are replaced instead of modified once the wallet is set up,
+	// so readers only hold the lock while taking the current map.
+	labelsMu sync.RWMutex
 }
 
 // This function is to create a new instance of a wallet.
@@ -48,6 +67,7 @@ func SetupWallet(
 	_, pubKeyScan := btcec.PrivKeyFromBytes(secretKeyScan[:])
 
 	wallet = &Wallet{
+		Version:        WalletVersion,
 		SecretKeyScan:  secretKeyScan,
 		PubKeyScan:     bip352.ConvertToFixedLength33(pubKeyScan.SerializeCompressed()),
 		PubKeySpend:    pubKeySpend,
@@ -73,18 +93,55 @@ func (w *Wallet) Serialise() ([]byte, error) {
 }
 
 func (w *Wallet) DeSerialise(data []byte) error {
+	w.utxoIndex = nil
 	return json.Unmarshal(data, w)
 }
 
-func (w *Wallet) AddUTXOs(utxos []*OwnedUTXO) error {
+// AddedUTXOs are the keys of the utxos which AddUTXOs changed
+type AddedUTXOs struct {
+	New        [][36]byte // utxos which were not known yet
+	Confirmed  [][36]byte // unconfirmed utxos which got the state and block data of the confirmed utxo
+	Backfilled [][36]byte // known utxos which only got their missing block data filled in
+}
+
+// Keys returns the keys of all changed utxos
+func (a AddedUTXOs) Keys() [][36]byte {
+	keys := make([][36]byte, 0, len(a.New)+len(a.Confirmed)+len(a.Backfilled))
+	keys = append(keys, a.New...)
+	keys = append(keys, a.Confirmed...)
+	return append(keys, a.Backfilled...)
+}
+
+// AddUTXOs adds the utxos which are not yet known to the wallet.
+// Known utxos which are still unconfirmed are promoted to the state of the confirmed utxo,
+// known utxos without block data get it filled in.
+func (w *Wallet) AddUTXOs(utxos []*OwnedUTXO) (AddedUTXOs, error) {
+	var added AddedUTXOs
 	for _, utxo := range utxos {
 		key, err := utxo.GetKey()
 		if err != nil {
 			log.Println(err)
-			return err
+			return AddedUTXOs{}, err
 		}
 		_, exists := w.UTXOMapping[key]
 		if exists {
+			existing := w.GetUTXO(key)
+			if existing == nil {
+				continue
+			}
+			if existing.State == StateUnconfirmed && utxo.State != StateUnconfirmed {
+				logging.L.Info().Hex("utxo", key[:]).Msg("utxo confirmed")
+				existing.State = utxo.State
+				existing.Timestamp = utxo.Timestamp
+				existing.BlockHeight = utxo.BlockHeight
+				existing.BlockHash = utxo.BlockHash
+				added.Confirmed = append(added.Confirmed, key)
+			} else if existing.BlockHeight == 0 && utxo.BlockHeight != 0 {
+				logging.L.Debug().Hex("utxo", key[:]).Uint64("height", utxo.BlockHeight).Msg("filled in block data")
+				existing.BlockHeight = utxo.BlockHeight
+				existing.BlockHash = utxo.BlockHash
+				added.Backfilled = append(added.Backfilled, key)
+			}
 			continue
 		}
 
@@ -92,43 +149,171 @@ func (w *Wallet) AddUTXOs(utxos []*OwnedUTXO) error {
 
 		w.UTXOs = append(w.UTXOs, utxo)
 		w.UTXOMapping[key] = struct{}{}
+		w.index()[key] = utxo
+		added.New = append(added.New, key)
 	}
 
-	return nil
+	return added, nil
+}
+
+// RemoveUnconfirmedUTXOs removes all unconfirmed utxos whose transaction is not in inMempool.
+// Returns the keys of the removed utxos.
+func (w *Wallet) RemoveUnconfirmedUTXOs(inMempool map[[32]byte]struct{}) [][36]byte {
+	var removed [][36]byte
+	for _, utxo := range w.GetUTXOsByStates(StateUnconfirmed) {
+		if _, ok := inMempool[utxo.Txid]; ok {
+			continue
+		}
+		key, err := utxo.GetKey()
+		if err != nil {
+			logging.L.Err(err).Msg("")
+			continue
+		}
+		logging.L.Info().Hex("utxo", key[:]).Msg("unconfirmed utxo left the mempool")
+		w.removeUTXO(key)
+		removed = append(removed, key)
+	}
+	return removed
 }
 
 func (w *Wallet) generateNextLabel() error {
+	// we set the next m according to the length/ number of items in the labels map
+	label, err := w.createLabel(w.Labels, uint32(len(w.Labels)))
+	if err != nil {
+		return err
+	}
+
+	w.Labels[label.PubKey] = label
+	return err
+}
+
+// createLabel creates the label m including its address, labels are the existing labels to check for duplicates
+func (w *Wallet) createLabel(labels LabelMap, m uint32) (*bip352.Label, error) {
 	var mainnet bool
 	if config.ChainParams.Name == chaincfg.MainNetParams.Name {
 		mainnet = true
 	}
 
-	// we set the next m according to the length/ number of items in the labels map
-	label, err := bip352.CreateLabel(w.SecretKeyScan, uint32(len(w.Labels)))
+	label, err := bip352.CreateLabel(w.SecretKeyScan, m)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	BmKey, err := bip352.AddPublicKeys(w.PubKeySpend, label.PubKey)
 	if err != nil {
 		logging.L.Err(err).Msg("")
-		return err
+		return nil, err
 	}
 	address, err := bip352.CreateAddress(w.PubKeyScan, BmKey, mainnet, 0)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	label.Address = address
 
-	_, exists := w.Labels[label.PubKey]
+	_, exists := labels[label.PubKey]
 	if exists {
 		// users should not create the same label twice
-		return utils.ErrLabelAlreadyExists
+		return nil, utils.ErrLabelAlreadyExists
 	}
 
-	w.Labels[label.PubKey] = &label
-	return err
+	return &label, nil
+}
+
+// AddLabel creates the label with the next m and attaches name to it
+func (w *Wallet) AddLabel(name string) (*bip352.Label, error) {
+	w.labelsMu.Lock()
+	defer w.labelsMu.Unlock()
+
+	labels := make(LabelMap, len(w.Labels)+1)
+	for k, v := range w.Labels {
+		labels[k] = v
+	}
+	label, err := w.createLabel(labels, uint32(len(labels)))
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return nil, err
+	}
+	labels[label.PubKey] = label
+
+	names := w.copyLabelNames()
+	if name != "" {
+		names[label.M] = name
+	}
+
+	w.Labels = labels
+	w.LabelNames = names
+	return label, nil
+}
+
+// SetLabelName attaches name to the label m, an empty name removes it
+func (w *Wallet) SetLabelName(m uint32, name string) error {
+	w.labelsMu.Lock()
+	defer w.labelsMu.Unlock()
+
+	if w.getLabel(m) == nil {
+		return utils.ErrLabelNotFound
+	}
+
+	names := w.copyLabelNames()
+	if name == "" {
+		delete(names, m)
+	} else {
+		names[m] = name
+	}
+	w.LabelNames = names
+	return nil
+}
+
+func (w *Wallet) copyLabelNames() map[uint32]string {
+	names := make(map[uint32]string, len(w.LabelNames)+1)
+	for m, name := range w.LabelNames {
+		names[m] = name
+	}
+	return names
+}
+
+// GetLabel returns the label m or nil if it does not exist
+func (w *Wallet) GetLabel(m uint32) *bip352.Label {
+	w.labelsMu.RLock()
+	defer w.labelsMu.RUnlock()
+	return w.getLabel(m)
+}
+
+func (w *Wallet) getLabel(m uint32) *bip352.Label {
+	for _, label := range w.Labels {
+		if label.M == m {
+			return label
+		}
+	}
+	return nil
+}
+
+// GetLabels returns all labels ordered by m
+func (w *Wallet) GetLabels() []*bip352.Label {
+	w.labelsMu.RLock()
+	labelMap := w.Labels
+	w.labelsMu.RUnlock()
+	labels := make([]*bip352.Label, 0, len(labelMap))
+	for _, label := range labelMap {
+		labels = append(labels, label)
+	}
+	sort.Slice(labels, func(i, j int) bool {
+		return labels[i].M < labels[j].M
+	})
+	return labels
+}
+
+// LabelName returns the name of the label m, empty if it has none
+func (w *Wallet) LabelName(m uint32) string {
+	return w.labelNames()[m]
+}
+
+// labelNames returns the current names, the map must not be modified
+func (w *Wallet) labelNames() map[uint32]string {
+	w.labelsMu.RLock()
+	defer w.labelsMu.RUnlock()
+	return w.LabelNames
 }
 
 func (w *Wallet) GetUTXOsByStates(states ...UTXOState) UtxoCollection {
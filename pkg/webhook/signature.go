@@ -0,0 +1,61 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader carries "t=<unix timestamp>,v1=<hex hmac>"
+	SignatureHeader = "X-Blindbit-Signature"
+	// DeliveryHeader carries the id of the payload, retries of the same payload have the same id
+	DeliveryHeader = "X-Blindbit-Delivery"
+)
+
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign computes the signature header for body.
+// The HMAC-SHA256 covers "<timestamp>.<body>" so receivers can reject replays of old deliveries.
+func Sign(secret string, timestamp int64, body []byte) string {
+	return fmt.Sprintf("t=%d,v1=%s", timestamp, hex.EncodeToString(computeMAC(secret, timestamp, body)))
+}
+
+// Verify checks the signature header of a received body.
+// Signatures older than tolerance are rejected, a tolerance of 0 skips the check.
+func Verify(secret, header string, body []byte, tolerance time.Duration) error {
+	var timestamp int64
+	var mac []byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(part, "=")
+		switch key {
+		case "t":
+			timestamp, _ = strconv.ParseInt(value, 10, 64)
+		case "v1":
+			mac, _ = hex.DecodeString(value)
+		}
+	}
+	if timestamp == 0 || mac == nil {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal(mac, computeMAC(secret, timestamp, body)) {
+		return ErrInvalidSignature
+	}
+	if tolerance > 0 && time.Since(time.Unix(timestamp, 0)).Abs() > tolerance {
+		return fmt.Errorf("%w: timestamp outside of tolerance", ErrInvalidSignature)
+	}
+	return nil
+}
+
+func computeMAC(secret string, timestamp int64, body []byte) []byte {
+	h := hmac.New(sha256.New, []byte(secret))
+	h.Write([]byte(strconv.FormatInt(timestamp, 10)))
+	h.Write([]byte("."))
+	h.Write(body)
+	return h.Sum(nil)
+}
@@ -0,0 +1,320 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/setavenger/blindbit-scan/internal/config"
+	"github.com/setavenger/blindbit-scan/pkg/database"
+	"github.com/setavenger/blindbit-scan/pkg/logging"
+)
+
+const EventPaymentReceived = "payment_received"
+
+// Payment is an output paid to the wallet which was found in a block
+type Payment struct {
+	Txid          string  `json:"txid"`
+	Vout          uint32  `json:"vout"`
+	Amount        uint64  `json:"amount"`
+	Label         *uint32 `json:"label,omitempty"` // label m, missing for payments to the address without label
+	Address       string  `json:"address"`         // the address the payment was sent to
+	BlockHeight   uint64  `json:"block_height"`
+	BlockHash     string  `json:"block_hash"`
+	Confirmations uint64  `json:"confirmations"` // at the time the payment was found
+}
+
+// Payload is the body posted to the webhook endpoints.
+// Deliveries are at least once, receivers should deduplicate by ID.
+type Payload struct {
+	ID        string  `json:"id"` // txid:vout
+	Event     string  `json:"event"`
+	CreatedAt int64   `json:"created_at"`
+	Payment   Payment `json:"payment"`
+}
+
+type delivery struct {
+	ID          string          `json:"id"`
+	URL         string          `json:"url"`
+	Payload     json.RawMessage `json:"payload"`
+	Attempts    int             `json:"attempts"`
+	NextAttempt time.Time       `json:"next_attempt"`
+	LastError   string          `json:"last_error,omitempty"`
+}
+
+// queue holds the pending deliveries, it is persisted after every change
+type queue struct {
+	Deliveries []*delivery `json:"deliveries"`
+}
+
+func (q *queue) Serialise() ([]byte, error) {
+	return json.Marshal(q)
+}
+
+func (q *queue) DeSerialise(data []byte) error {
+	return json.Unmarshal(data, q)
+}
+
+// Dispatcher posts signed payloads to the configured endpoints.
+// Failed deliveries are retried with exponential backoff, the queue survives restarts.
+// All methods are safe to call on a nil Dispatcher, which is used if no endpoints are configured.
+type Dispatcher struct {
+	endpoints   map[string]config.WebhookEndpoint // by url
+	path        string
+	client      *http.Client
+	maxAttempts int
+	minBackoff  time.Duration
+	maxBackoff  time.Duration
+
+	mu    sync.Mutex
+	queue queue
+	wake  chan struct{}
+}
+
+// NewDispatcher loads the pending deliveries from path. Returns nil if there are no endpoints.
+func NewDispatcher(endpoints []config.WebhookEndpoint, path string, maxAttempts int) (*Dispatcher, error) {
+	if len(endpoints) == 0 {
+		return nil, nil
+	}
+
+	d := &Dispatcher{
+		endpoints:   make(map[string]config.WebhookEndpoint, len(endpoints)),
+		path:        path,
+		client:      &http.Client{Timeout: 10 * time.Second},
+		maxAttempts: maxAttempts,
+		minBackoff:  10 * time.Second,
+		maxBackoff:  time.Hour,
+		wake:        make(chan struct{}, 1),
+	}
+	for _, endpoint := range endpoints {
+		d.endpoints[endpoint.URL] = endpoint
+	}
+
+	if database.Exists(path) {
+		err := database.ReadFromDB(path, &d.queue)
+		if err != nil {
+			logging.L.Err(err).Msg("")
+			return nil, err
+		}
+	}
+
+	// endpoints can be removed from the config while deliveries are pending
+	var kept []*delivery
+	for _, del := range d.queue.Deliveries {
+		if _, ok := d.endpoints[del.URL]; !ok {
+			logging.L.Warn().Str("url", del.URL).Str("id", del.ID).Msg("dropped webhook delivery, endpoint is not configured anymore")
+			continue
+		}
+		kept = append(kept, del)
+	}
+	d.queue.Deliveries = kept
+
+	return d, nil
+}
+
+// Enqueue queues the payment for every endpoint whose labels match.
+// The queue is persisted before returning.
+func (d *Dispatcher) Enqueue(payment Payment) error {
+	if d == nil {
+		return nil
+	}
+
+	payload := Payload{
+		ID:        fmt.Sprintf("%s:%d", payment.Txid, payment.Vout),
+		Event:     EventPaymentReceived,
+		CreatedAt: time.Now().Unix(),
+		Payment:   payment,
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	queueLength := len(d.queue.Deliveries)
+	var queued bool
+	for _, endpoint := range d.endpoints {
+		if !matchesLabels(endpoint, payment.Label) || d.isPending(endpoint.URL, payload.ID) {
+			continue
+		}
+		d.queue.Deliveries = append(d.queue.Deliveries, &delivery{
+			ID:          payload.ID,
+			URL:         endpoint.URL,
+			Payload:     body,
+			NextAttempt: time.Now(),
+		})
+		queued = true
+	}
+	if !queued {
+		return nil
+	}
+
+	err = database.WriteToDB(d.path, &d.queue)
+	if err != nil {
+		// not pending unless stored, so that the payment is queued again on retry
+		d.queue.Deliveries = d.queue.Deliveries[:queueLength]
+		logging.L.Err(err).Msg("")
+		return err
+	}
+
+	select {
+	case d.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// Run delivers the queued payloads until ctx is cancelled
+func (d *Dispatcher) Run(ctx context.Context) {
+	if d == nil {
+		return
+	}
+
+	for {
+		timer := time.NewTimer(d.deliverDue(ctx))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-d.wake:
+		case <-timer.C:
+		}
+		timer.Stop()
+	}
+}
+
+// Pending returns the number of deliveries which were not successful yet
+func (d *Dispatcher) Pending() int {
+	if d == nil {
+		return 0
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return len(d.queue.Deliveries)
+}
+
+// deliverDue sends all deliveries which are due and returns how long to wait until the next one is
+func (d *Dispatcher) deliverDue(ctx context.Context) time.Duration {
+	d.mu.Lock()
+	var due []*delivery
+	for _, del := range d.queue.Deliveries {
+		if !del.NextAttempt.After(time.Now()) {
+			due = append(due, del)
+		}
+	}
+	d.mu.Unlock()
+
+	for _, del := range due {
+		if ctx.Err() != nil {
+			break
+		}
+		// the lock is not held while sending, Enqueue only appends
+		err := d.send(ctx, del)
+
+		d.mu.Lock()
+		del.Attempts++
+		switch {
+		case err == nil:
+			d.remove(del)
+			logging.L.Info().Str("url", del.URL).Str("id", del.ID).Msg("delivered webhook")
+		case del.Attempts >= d.maxAttempts:
+			d.remove(del)
+			logging.L.Error().Err(err).Str("url", del.URL).Str("id", del.ID).Int("attempts", del.Attempts).
+				Msg("dropped webhook delivery, too many failed attempts")
+		default:
+			del.LastError = err.Error()
+			del.NextAttempt = time.Now().Add(d.backoff(del.Attempts))
+			logging.L.Warn().Err(err).Str("url", del.URL).Str("id", del.ID).Time("next_attempt", del.NextAttempt).
+				Msg("webhook delivery failed")
+		}
+		err = database.WriteToDB(d.path, &d.queue)
+		if err != nil {
+			logging.L.Err(err).Msg("could not persist webhook queue")
+		}
+		d.mu.Unlock()
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	wait := d.maxBackoff
+	for _, del := range d.queue.Deliveries {
+		wait = min(wait, time.Until(del.NextAttempt))
+	}
+	return max(wait, 0)
+}
+
+func (d *Dispatcher) send(ctx context.Context, del *delivery) error {
+	endpoint := d.endpoints[del.URL]
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, del.URL, bytes.NewReader(del.Payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(DeliveryHeader, del.ID)
+	req.Header.Set(SignatureHeader, Sign(endpoint.Secret, time.Now().Unix(), del.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// backoff doubles the wait for every failed attempt, with up to 10% jitter
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	wait := d.minBackoff
+	for i := 1; i < attempts && wait < d.maxBackoff; i++ {
+		wait *= 2
+	}
+	wait = min(wait, d.maxBackoff)
+	return wait + time.Duration(rand.Int64N(int64(wait)/10+1))
+}
+
+func (d *Dispatcher) isPending(url, id string) bool {
+	for _, del := range d.queue.Deliveries {
+		if del.URL == url && del.ID == id {
+			return true
+		}
+	}
+	return false
+}
+
+func (d *Dispatcher) remove(del *delivery) {
+	for i, existing := range d.queue.Deliveries {
+		if existing == del {
+			d.queue.Deliveries = append(d.queue.Deliveries[:i], d.queue.Deliveries[i+1:]...)
+			return
+		}
+	}
+}
+
+func matchesLabels(endpoint config.WebhookEndpoint, label *uint32) bool {
+	if len(endpoint.Labels) == 0 {
+		return true
+	}
+	if label == nil {
+		return false
+	}
+	for _, m := range endpoint.Labels {
+		if m == *label {
+			return true
+		}
+	}
+	return false
+}
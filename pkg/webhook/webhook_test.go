@@ -0,0 +1,145 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/setavenger/blindbit-scan/internal/config"
+)
+
+func TestDispatcherRetriesSignedDeliveries(t *testing.T) {
+	const secret = "shop-secret"
+	var attempts atomic.Int32
+	received := make(chan Payload, 1)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// the first two attempts fail
+		if attempts.Add(1) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify(secret, r.Header.Get(SignatureHeader), body, time.Minute); err != nil {
+			t.Errorf("signature: %v", err)
+		}
+		var payload Payload
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Error(err)
+		}
+		if r.Header.Get(DeliveryHeader) != payload.ID {
+			t.Errorf("expected delivery header %s, got %s", payload.ID, r.Header.Get(DeliveryHeader))
+		}
+		received <- payload
+	}))
+	defer receiver.Close()
+
+	var otherRequests atomic.Int32
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		otherRequests.Add(1)
+	}))
+	defer other.Close()
+
+	d, err := NewDispatcher([]config.WebhookEndpoint{
+		{URL: receiver.URL, Secret: secret, Labels: []uint32{1}},
+		{URL: other.URL, Secret: "other", Labels: []uint32{2}},
+	}, t.TempDir()+"/webhooks", 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.minBackoff = 10 * time.Millisecond
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		d.Run(ctx)
+		close(done)
+	}()
+	// the queue is written until Run returns
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	m := uint32(1)
+	err = d.Enqueue(Payment{Txid: "aa", Vout: 1, Amount: 10_000, Label: &m, BlockHeight: 5, Confirmations: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case payload := <-received:
+		if payload.ID != "aa:1" || payload.Event != EventPaymentReceived || payload.Payment.Amount != 10_000 {
+			t.Errorf("unexpected payload %+v", payload)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("webhook was not delivered")
+	}
+
+	if attempts.Load() != 3 {
+		t.Errorf("expected 3 attempts, got %d", attempts.Load())
+	}
+	if otherRequests.Load() != 0 {
+		t.Errorf("expected no delivery to the endpoint of label 2")
+	}
+}
+
+func TestDispatcherPersistsQueue(t *testing.T) {
+	path := t.TempDir() + "/webhooks"
+	endpoints := []config.WebhookEndpoint{
+		{URL: "http://127.0.0.1:1/a", Secret: "a"},
+		{URL: "http://127.0.0.1:1/b", Secret: "b"},
+	}
+
+	d, err := NewDispatcher(endpoints, path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Enqueue(Payment{Txid: "aa"}); err != nil {
+		t.Fatal(err)
+	}
+	// the same payment found again, e.g. after a restart, is not queued twice
+	if err = d.Enqueue(Payment{Txid: "aa"}); err != nil {
+		t.Fatal(err)
+	}
+	if d.Pending() != 2 {
+		t.Fatalf("expected 2 pending deliveries, got %d", d.Pending())
+	}
+
+	d, err = NewDispatcher(endpoints[:1], path, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if d.Pending() != 1 {
+		t.Errorf("expected the delivery for the removed endpoint to be dropped, got %d pending", d.Pending())
+	}
+
+	var nilDispatcher *Dispatcher
+	if err = nilDispatcher.Enqueue(Payment{}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	body := []byte(`{"id":"aa:0"}`)
+	header := Sign("secret", time.Now().Unix(), body)
+
+	if err := Verify("secret", header, body, time.Minute); err != nil {
+		t.Errorf("expected a valid signature, got %v", err)
+	}
+	if err := Verify("secret", header, []byte(`{"id":"aa:1"}`), time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected an invalid signature for a modified body, got %v", err)
+	}
+	if err := Verify("wrong", header, body, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected an invalid signature for the wrong secret, got %v", err)
+	}
+	old := Sign("secret", time.Now().Add(-time.Hour).Unix(), body)
+	if err := Verify("secret", old, body, time.Minute); !errors.Is(err, ErrInvalidSignature) {
+		t.Errorf("expected an old signature to be rejected, got %v", err)
+	}
+}